@@ -0,0 +1,509 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/dmonteroh/distributed-resource-collector/internal"
+	"github.com/dmonteroh/distributed-resource-collector/pkg"
+)
+
+// -- COMMANDS
+// drc <command> runs one task and exits, for debugging a node in the field. The commands read the same environment variables
+// and config file as the service.
+
+const usage = `Usage: drc [command] [flags]
+
+Without a command (or with --start-service) the DRC runs as a service.
+
+Commands:
+  stats          collect the server stats once and print them
+  probe          probe the latency targets once and print the results
+  push           post one heartbeat to the gateway
+  register       register the node in the inventory
+  config check   validate the configuration and the connection to the gateway
+
+Run drc <command> -h for the flags of a command.
+`
+
+// Runs the command and returns the exit code: 0 on success, 1 when the command failed and 2 on usage errors.
+// The collectors and probes log to stdout, while a command runs their logs go to stderr so stdout only gets its output.
+func runCommand(name string, args []string) int {
+	out := os.Stdout
+	os.Stdout = os.Stderr
+	defer func() { os.Stdout = out }()
+
+	switch name {
+	case "stats":
+		return statsCommand(args, out)
+	case "probe":
+		return probeCommand(args, out)
+	case "push":
+		return pushCommand(args, out)
+	case "register":
+		return registerCommand(args, out)
+	case "config":
+		if len(args) == 0 || args[0] != "check" {
+			fmt.Fprint(os.Stderr, usage)
+			return 2
+		}
+		return configCheckCommand(args[1:], out)
+	case "help":
+		fmt.Fprint(out, usage)
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "drc: unknown command %s\n\n%s", name, usage)
+		return 2
+	}
+}
+
+// Parses the flags of a command, returns the exit code when the command shouldn't run (-h or invalid flags)
+func parseFlags(flags *flag.FlagSet, args []string) (int, bool) {
+	flags.SetOutput(os.Stderr)
+	if err := flags.Parse(args); err == flag.ErrHelp {
+		return 0, false
+	} else if err != nil {
+		return 2, false
+	}
+	if flags.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "%s: unexpected argument %s\n", flags.Name(), flags.Arg(0))
+		return 2, false
+	}
+	return 0, true
+}
+
+func fail(err error) int {
+	fmt.Fprintln(os.Stderr, "drc:", err)
+	return 1
+}
+
+func checkFormat(format string) error {
+	if format != "json" && format != "table" {
+		return fmt.Errorf("unknown format %s, expected json or table", format)
+	}
+	return nil
+}
+
+// Loads the config file (CONFIG_FILE), the settings and the collectors, like the service does on start
+func loadCommandConfig() (settings, error) {
+	if err := internal.LoadConfig(internal.GetEnv("CONFIG_FILE", "")); err != nil {
+		return settings{}, err
+	}
+	s, err := loadSettings(internal.CurrentConfig())
+	if err != nil {
+		return settings{}, err
+	}
+	return s, internal.ConfigureCollectorsFromEnv()
+}
+
+// Configures the gateway client: TLS and the signing key of the posts
+func configureGateway() error {
+	return configureGatewayKey(internal.LoadSigningKey)
+}
+
+// Configures the gateway client with the signing key read by loadKey, config check reads it without generating a missing key
+func configureGatewayKey(loadKey func(path string) (ed25519.PrivateKey, error)) error {
+	if err := internal.ConfigureGatewayTLS(internal.GetEnv("TLS_CA", ""), internal.GetEnv("TLS_CERT", ""), internal.GetEnv("TLS_KEY", "")); err != nil {
+		return err
+	}
+	if signingKey := internal.GetEnv("SIGNING_KEY", "keys/drc_ed25519.pem"); signingKey != "none" {
+		key, err := loadKey(signingKey)
+		if err != nil {
+			return err
+		}
+		internal.SigningKey = key
+	}
+	return nil
+}
+
+// JSON output is indented for reading, the structs with maps aren't supported by jettison
+func writeJSON(out io.Writer, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(out, string(b))
+	return err
+}
+
+// drc stats [--format json|table]
+// The network and disk rates need two samples, a single sample reports them as 0.
+func statsCommand(args []string, out io.Writer) int {
+	flags := flag.NewFlagSet("drc stats", flag.ContinueOnError)
+	format := flags.String("format", "json", "output format, json or table")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+	if err := checkFormat(*format); err != nil {
+		return fail(err)
+	}
+	if _, err := loadCommandConfig(); err != nil {
+		return fail(err)
+	}
+
+	stats := internal.GetServerStats()
+	if *format == "table" {
+		writeStatsTable(out, stats)
+		return 0
+	}
+	if err := writeJSON(out, stats); err != nil {
+		return fail(err)
+	}
+	return 0
+}
+
+func writeStatsTable(out io.Writer, stats internal.DrcStats) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	defer w.Flush()
+	fmt.Fprintf(w, "SECTION\tNAME\tVALUE\n")
+	fmt.Fprintf(w, "host\thostname\t%s\n", stats.DrcHost.Hostname)
+	fmt.Fprintf(w, "host\tplatform\t%s\n", stats.DrcHost.Platform)
+	fmt.Fprintf(w, "host\tuptime\t%ds\n", stats.DrcHost.Uptime)
+	fmt.Fprintf(w, "cpu\tmodel\t%s\n", stats.CPUStats.ModelName)
+	fmt.Fprintf(w, "cpu\tusage\t%.1f%%\n", stats.CPUStats.AverageUsage)
+	for i, usage := range stats.CPUStats.CoreUsage {
+		fmt.Fprintf(w, "cpu\tcore %d\t%.1f%%\n", i, usage)
+	}
+	fmt.Fprintf(w, "memory\tused\t%.1f%% of %s\n", stats.MemStats.Used, formatBytes(stats.MemStats.Total))
+	fmt.Fprintf(w, "procs\trunning\t%d of %d (%d blocked)\n", stats.ProcStats.RunningProcs, stats.ProcStats.TotalProcs, stats.ProcStats.BlockedProcs)
+	for _, disk := range stats.DiskStats {
+		fmt.Fprintf(w, "disk\t%s\t%.1f%% of %s (%s)\n", disk.Path, disk.UsedPercent, formatBytes(disk.Total), disk.Device)
+	}
+	for _, network := range stats.NetStats {
+		fmt.Fprintf(w, "network\t%s\trx %s, tx %s\n", network.Interface, formatBytes(network.BytesRecv), formatBytes(network.BytesSent))
+	}
+	for _, container := range stats.DockerSats {
+		fmt.Fprintf(w, "docker\t%s\t%s, cpu %.1f%%, memory %.1f%%\n", container.Name, container.State, container.CPUPercent, container.MemoryPercent)
+	}
+	for _, gpu := range stats.GPUStats {
+		fmt.Fprintf(w, "gpu\t%d %s\t%.1f%%, memory %s of %s, %.1f°C\n", gpu.Index, gpu.Name, gpu.Utilization, formatBytes(gpu.MemoryUsed), formatBytes(gpu.MemoryTotal), gpu.Temperature)
+	}
+	for _, sensor := range stats.ThermalStats.Sensors {
+		fmt.Fprintf(w, "thermal\t%s\t%.1f°C\n", sensor.Name, sensor.Temperature)
+	}
+	if stats.ThermalStats.Throttled {
+		fmt.Fprintf(w, "thermal\tthrottled\t%s\n", strings.Join(stats.ThermalStats.ThrottledFlags, ", "))
+	}
+	for name, extra := range stats.Extra {
+		b, _ := json.Marshal(extra)
+		fmt.Fprintf(w, "extra\t%s\t%s\n", name, b)
+	}
+}
+
+func formatBytes(bytes uint64) string {
+	value := float64(bytes)
+	for _, unit := range []string{"B", "KiB", "MiB", "GiB"} {
+		if value < 1024 {
+			return fmt.Sprintf("%.1f %s", value, unit)
+		}
+		value /= 1024
+	}
+	return fmt.Sprintf("%.1f TiB", value)
+}
+
+// drc probe [--targets file.json] [--format json|table]
+// The targets file has the format of POST /latency ("-" reads it from stdin), without it the targets come from the gateway.
+// The probes use the LATENCY_* settings, Ctrl+C ends the round with the targets probed so far.
+func probeCommand(args []string, out io.Writer) int {
+	flags := flag.NewFlagSet("drc probe", flag.ContinueOnError)
+	targetsFile := flags.String("targets", "", "JSON file with the latency targets, - for stdin, empty to get them from the gateway")
+	format := flags.String("format", "json", "output format, json or table")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+	if err := checkFormat(*format); err != nil {
+		return fail(err)
+	}
+	s, err := loadCommandConfig()
+	if err != nil {
+		return fail(err)
+	}
+
+	var latencyTargets internal.LatencyTargets
+	if *targetsFile == "" {
+		if err := configureGateway(); err != nil {
+			return fail(err)
+		}
+		if latencyTargets, err = pkg.FetchLatencyTargets(s.variables["TARGETS_APP"]); err != nil {
+			return fail(err)
+		}
+	} else {
+		var content []byte
+		if *targetsFile == "-" {
+			content, err = ioutil.ReadAll(os.Stdin)
+		} else {
+			content, err = ioutil.ReadFile(*targetsFile)
+		}
+		if err != nil {
+			return fail(err)
+		}
+		if latencyTargets, err = internal.LatencyTargetsJsonToStruct(string(content)); err != nil {
+			return fail(fmt.Errorf("latency: invalid targets: %v", err))
+		}
+		if len(latencyTargets.Targets) == 0 {
+			return fail(errors.New("latency: no targets found"))
+		}
+	}
+
+	sshOptions, err := internal.NewSSHOptionsFromEnv()
+	if err != nil {
+		return fail(err)
+	}
+	probers, err := internal.NewProbersFromEnv(sshOptions)
+	if err != nil {
+		return fail(err)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	latencyResults := pkg.ProbeTargets(ctx, s.variables["EXEC_MODE"], latencyTargets, probers)
+
+	if *format == "table" {
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(w, "TARGET\tMETHOD\tLATENCY\tMIN\tMAX\tJITTER\tLOSS\n")
+		for _, result := range latencyResults.Results {
+			fmt.Fprintf(w, "%s\t%s\t%.2fms\t%.2fms\t%.2fms\t%.2fms\t%.0f%%\n", result.Hostname, result.Method, result.Average, result.Min, result.Max, result.StdDev, result.Loss*100)
+		}
+		w.Flush()
+	} else if err := writeJSON(out, latencyResults); err != nil {
+		return fail(err)
+	}
+	if len(latencyResults.Results) < len(latencyTargets.Targets) {
+		return fail(fmt.Errorf("latency: %d of %d targets weren't probed", len(latencyTargets.Targets)-len(latencyResults.Results), len(latencyTargets.Targets)))
+	}
+	return 0
+}
+
+// drc push [--print]
+// Posts one heartbeat to the gateway (COLLECTOR_APP) without the queue, signed and over TLS like the service
+func pushCommand(args []string, out io.Writer) int {
+	flags := flag.NewFlagSet("drc push", flag.ContinueOnError)
+	printHeartbeat := flags.Bool("print", false, "print the heartbeat that was posted")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+	s, err := loadCommandConfig()
+	if err != nil {
+		return fail(err)
+	}
+	if err := configureGateway(); err != nil {
+		return fail(err)
+	}
+
+	url := s.variables["COLLECTOR_APP"]
+	stats, err := pkg.PushHeartbeat(url, s.variables["EXEC_MODE"])
+	if *printHeartbeat {
+		writeJSON(out, stats)
+	}
+	if err != nil {
+		return fail(err)
+	}
+	fmt.Fprintln(out, "heartbeat posted to", url)
+	return 0
+}
+
+// drc register [--dry-run]
+// Registers the node in the inventory (REGISTER_APP) and prints the asset stored by the inventory. With --dry-run it only prints the
+// discovered asset, without the id the gateway sets.
+func registerCommand(args []string, out io.Writer) int {
+	flags := flag.NewFlagSet("drc register", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "print the discovered asset without registering it")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+	s, err := loadCommandConfig()
+	if err != nil {
+		return fail(err)
+	}
+	if err := configureGateway(); err != nil {
+		return fail(err)
+	}
+	registration, err := newCommandRegistration()
+	if err != nil {
+		return fail(err)
+	}
+
+	if *dryRun {
+		asset, err := registration.Asset()
+		if err != nil {
+			return fail(err)
+		}
+		writeJSON(out, asset)
+		return 0
+	}
+	asset, err := pkg.RegisterNode(s.variables["REGISTER_APP"], registration)
+	if err != nil {
+		return fail(err)
+	}
+	writeJSON(out, asset)
+	return 0
+}
+
+// Creates the registration like the service does, the responder and the bandwidth server are configured but not started
+func newCommandRegistration() (*internal.Registration, error) {
+	responder, err := internal.NewResponderFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	bandwidthServer, err := internal.NewBandwidthServerFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return internal.NewRegistrationFromEnv(responder, bandwidthServer)
+}
+
+// drc config check [--offline]
+// Runs every step of the service start that can fail, without starting it, and gets the targets from the gateway to check the
+// connection. Every check runs even when one fails. Nothing is written: a missing signing key fails instead of being generated and
+// the queue, history and jsonl directories aren't created.
+func configCheckCommand(args []string, out io.Writer) int {
+	flags := flag.NewFlagSet("drc config check", flag.ContinueOnError)
+	offline := flags.Bool("offline", false, "skip the connection to the gateway")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	failed := 0
+	check := func(name string, run func() (string, error)) bool {
+		detail, err := run()
+		if err != nil {
+			failed++
+			fmt.Fprintf(w, "FAIL\t%s\t%v\n", name, err)
+			return false
+		}
+		fmt.Fprintf(w, "ok\t%s\t%s\n", name, detail)
+		return true
+	}
+
+	check("config file", func() (string, error) {
+		configFile := internal.GetEnv("CONFIG_FILE", "")
+		if configFile == "" {
+			return "none, environment only", nil
+		}
+		return configFile, internal.LoadConfig(configFile)
+	})
+	var s settings
+	settingsOk := check("settings", func() (string, error) {
+		var err error
+		s, err = loadSettings(internal.CurrentConfig())
+		return fmt.Sprintf("exec mode %s, gateway %s", s.variables["EXEC_MODE"], s.variables["COLLECTOR_APP"]), err
+	})
+	check("collectors", func() (string, error) {
+		if err := internal.ConfigureCollectorsFromEnv(); err != nil {
+			return "", err
+		}
+		return strings.Join(internal.EnabledCollectors(), ", "), nil
+	})
+	gatewayOk := check("gateway tls and signing key", func() (string, error) {
+		if err := configureGatewayKey(internal.ReadSigningKey); err != nil {
+			return "", err
+		}
+		if internal.SigningKey == nil {
+			return "posts aren't signed", nil
+		}
+		return "public key " + internal.PublicKeyString(internal.SigningKey), nil
+	})
+	check("queue", func() (string, error) {
+		queued, err := internal.CheckForwardQueueFromEnv()
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%d queued posts", queued), nil
+	})
+	// Creating the sinks checks their settings, and connects to the MQTT broker
+	if settingsOk {
+		check("jobs and sinks", func() (string, error) {
+			// Nothing is sent, the http sink doesn't need the queue of the service
+			sinks := internal.NewSinks(&internal.ForwardQueue{})
+			defer sinks.Close()
+			scheduler := internal.NewScheduler()
+			if err := scheduleJobs(scheduler, s, &internal.Probers{}, &internal.BandwidthOptions{}, sinks, nil, &internal.Registration{}); err != nil {
+				return "", err
+			}
+			jobSinks := map[string]string{"heartbeat": s.heartbeatSinks, "latency": s.latencySinks, "bandwidth": s.bandwidthSinks, "register": "gateway", "event": s.heartbeatSinks}
+			jobs := []string{}
+			for _, job := range scheduler.Status().Jobs {
+				jobs = append(jobs, fmt.Sprintf("%s %s to %s", job.Name, job.Schedule, strings.Join(internal.SplitList(jobSinks[job.Name]), "+")))
+			}
+			if len(jobs) == 0 {
+				return "no jobs", nil
+			}
+			return strings.Join(jobs, ", "), nil
+		})
+	}
+	check("history", func() (string, error) {
+		return internal.GetEnv("HISTORY_DIR", "in memory"), internal.CheckHistoryFromEnv()
+	})
+	check("latency probes", func() (string, error) {
+		sshOptions, err := internal.NewSSHOptionsFromEnv()
+		if err != nil {
+			return "", err
+		}
+		probers, err := internal.NewProbersFromEnv(sshOptions)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("default %s, methods %s", probers.Default, strings.Join(probers.Methods(), ", ")), nil
+	})
+	check("responder and bandwidth", func() (string, error) {
+		responder, err := internal.NewResponderFromEnv()
+		if err != nil {
+			return "", err
+		}
+		bandwidthServer, err := internal.NewBandwidthServerFromEnv()
+		if err != nil {
+			return "", err
+		}
+		bandwidthOptions, err := internal.NewBandwidthOptionsFromEnv()
+		if err != nil {
+			return "", err
+		}
+		detail := "responder off"
+		if responder != nil {
+			detail = fmt.Sprintf("responder %s %s", responder.Protocol, responder.Port)
+		}
+		if bandwidthServer != nil {
+			detail += ", bandwidth server " + bandwidthServer.Port
+		}
+		return detail + ", bandwidth tests " + bandwidthOptions.Direction, nil
+	})
+	check("registration", func() (string, error) {
+		registration, err := newCommandRegistration()
+		if err != nil {
+			return "", err
+		}
+		asset, err := registration.Asset()
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s (host id %s), addresses %s", asset.Name, asset.Properties.HostID, asset.Properties.Addresses), nil
+	})
+	if !*offline {
+		check("gateway connection", func() (string, error) {
+			if !settingsOk || !gatewayOk {
+				return "", errors.New("invalid gateway settings")
+			}
+			latencyTargets, err := pkg.FetchLatencyTargets(s.variables["TARGETS_APP"])
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%d latency targets for %s", len(latencyTargets.Targets), latencyTargets.Source), nil
+		})
+	}
+	w.Flush()
+
+	if failed > 0 {
+		return fail(fmt.Errorf("%d checks failed", failed))
+	}
+	return 0
+}
@@ -1,12 +1,15 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"log"
+	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/go-co-op/gocron"
 
 	"github.com/dmonteroh/distributed-resource-collector/internal"
 	"github.com/dmonteroh/distributed-resource-collector/pkg"
@@ -18,49 +21,325 @@ func main() {
 	// fmt.Println("NumCPU", runtime.NumCPU())
 	// fmt.Println("GOMAXPROCS", runtime.GOMAXPROCS(0))
 
+	// COMMANDS (drc stats, probe, push AND config check RUN ONCE AND EXIT), WITHOUT ONE THE DRC RUNS AS A SERVICE
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		os.Exit(runCommand(os.Args[1], os.Args[2:]))
+	}
+	flag.Bool("start-service", true, "run the DRC as a service, the default without a command")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
 	// Create a GINGONIC http server
 	r := gin.Default()
 
+	// CONFIG FILE (CONFIG_FILE, YAML WITH THE SAME KEYS AS THE ENVIROMENTAL VARIABLES, WHICH OVERRIDE IT)
+	configFile := internal.GetEnv("CONFIG_FILE", "")
+	if err := internal.LoadConfig(configFile); err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+	if configFile != "" {
+		fmt.Println("CONFIG FILE:", configFile)
+	}
+
 	// ENVIROMENTAL VARIABLES
-	execMode := internal.GetEnv("EXEC_MODE", "DEBUG")
 	listenPort := internal.GetEnv("INTERNAL_PORT", "8081")
-	appProtocol := internal.GetEnv("APP_PROTOCOL", "http")
-	appIP := internal.GetEnv("APP_IP", "localhost:8080")
-	collectorUrl := internal.GetEnv("APP_URL", "collector")
-	targetsUrl := internal.GetEnv("TARGETS_URL", "latency/servers/targets")
-	latencyUrl := internal.GetEnv("LATENCY_URL", "latency")
-	collectorApp := internal.UrlMaker(appProtocol, appIP, collectorUrl)
-	targetsApp := internal.UrlMaker(appProtocol, appIP, targetsUrl)
-	latencyApp := internal.UrlMaker(appProtocol, appIP, latencyUrl)
-	appCron, _ := strconv.Atoi(internal.GetEnv("APP_CRON", "30"))
-	heartbeat, _ := strconv.ParseBool(internal.GetEnv("HEARTBEAT", "true"))
+	settings, err := loadSettings(internal.CurrentConfig())
+	if err != nil {
+		log.Fatalf("Failed to configure: %v", err)
+	}
 
-	// MAP VARIABLES INTO MAP
-	variables := map[string]string{
-		"EXEC_MODE":     execMode,
-		"COLLECTOR_APP": collectorApp,
-		"LATENCY_APP":   latencyApp,
-		"TARGETS_APP":   targetsApp,
+	// COLLECTORS (COLLECTORS, COLLECTORS_DISABLED AND COLLECTOR_<NAME>_<OPTION>)
+	if err := internal.ConfigureCollectorsFromEnv(); err != nil {
+		log.Fatalf("Failed to configure collectors: %v", err)
+	}
+	fmt.Println("ENABLED COLLECTORS:", internal.EnabledCollectors())
+
+	// GATEWAY TLS (APP_PROTOCOL=https, TLS_CA VERIFIES THE GATEWAY, TLS_CERT AND TLS_KEY ARE THE CLIENT CERTIFICATE)
+	if err := internal.ConfigureGatewayTLS(internal.GetEnv("TLS_CA", ""), internal.GetEnv("TLS_CERT", ""), internal.GetEnv("TLS_KEY", "")); err != nil {
+		log.Fatalf("Failed to configure TLS: %v", err)
+	}
+
+	// SIGNING KEY (SIGNING_KEY, "none" DISABLES THE SIGNATURE OF THE HEARTBEATS)
+	if signingKey := internal.GetEnv("SIGNING_KEY", "keys/drc_ed25519.pem"); signingKey != "none" {
+		key, err := internal.LoadSigningKey(signingKey)
+		if err != nil {
+			log.Fatalf("Failed to load signing key: %v", err)
+		}
+		internal.SigningKey = key
+		fmt.Println("SIGNING PUBLIC KEY:", internal.PublicKeyString(key))
+	}
+
+	// FORWARD QUEUE (QUEUE_DIR, QUEUE_MAX_ENTRIES, QUEUE_MAX_AGE, QUEUE_MIN_BACKOFF AND QUEUE_MAX_BACKOFF)
+	queue, err := internal.NewForwardQueueFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to open forward queue: %v", err)
+	}
+	fmt.Println("FORWARD QUEUE:", queue.Status().String())
+	internal.RegisterQueueMetrics(queue)
+
+	// LOCAL HISTORY (HISTORY_SIZE, HISTORY_ROLLUP_SIZE AND HISTORY_DIR, EMPTY KEEPS IT ONLY IN MEMORY)
+	history, err := internal.NewHistoryFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to open history: %v", err)
+	}
+
+	// SSH LATENCY PROBES (SSH_KEY, SSH_KNOWN_HOSTS, SSH_AGENT, SSH_ALLOW_PASSWORD AND SSH_TIMEOUT)
+	sshOptions, err := internal.NewSSHOptionsFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to configure SSH: %v", err)
+	}
+	// LATENCY PROBES (LATENCY_METHOD AND LATENCY_TIMEOUT, THE METHOD OF EVERY TARGET COMES FROM THE INVENTORY)
+	probers, err := internal.NewProbersFromEnv(sshOptions)
+	if err != nil {
+		log.Fatalf("Failed to configure latency probes: %v", err)
+	}
+	fmt.Println("LATENCY PROBE METHODS:", probers.Methods(), "DEFAULT:", probers.Default)
+
+	// PROBE RESPONDER (RESPONDER_ENABLED, RESPONDER_PORT AND RESPONDER_PROTOCOL), ANSWERS THE UDP AND TCP PROBES OF OTHER DRCS
+	responder, err := internal.NewResponderFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to configure responder: %v", err)
+	}
+	if responder != nil {
+		if err := responder.Start(); err != nil {
+			log.Fatalf("Failed to start responder: %v", err)
+		}
+		fmt.Println("PROBE RESPONDER:", responder.Protocol, responder.Port)
+	}
+
+	// BANDWIDTH SERVER (BANDWIDTH_ENABLED AND BANDWIDTH_PORT) AND TESTS (BANDWIDTH_DIRECTION, BANDWIDTH_DURATION AND BANDWIDTH_CRON, 0 DISABLES THEM)
+	bandwidthServer, err := internal.NewBandwidthServerFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to configure bandwidth server: %v", err)
+	}
+	if bandwidthServer != nil {
+		if err := bandwidthServer.Start(); err != nil {
+			log.Fatalf("Failed to start bandwidth server: %v", err)
+		}
+		fmt.Println("BANDWIDTH SERVER:", bandwidthServer.Port)
+	}
+	bandwidthOptions, err := internal.NewBandwidthOptionsFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to configure bandwidth tests: %v", err)
+	}
+
+	// SELF-REGISTRATION (REGISTER_TYPE, REGISTER_OWNER, REGISTER_ADDRESS AND REGISTER_GPU), REGISTER_SCHEDULE=off DISABLES IT
+	registration, err := internal.NewRegistrationFromEnv(responder, bandwidthServer)
+	if err != nil {
+		log.Fatalf("Failed to configure registration: %v", err)
 	}
 
+	// OUTPUT SINKS (<JOB>_SINKS, SINKS BY DEFAULT: http, jsonl, stdout AND mqtt), EVERY SINK IS CREATED WHEN A JOB FIRST USES IT
+	sinks := internal.NewSinks(queue)
+
+	scheduler := internal.NewScheduler()
+	enviroment := internal.NewEnviroment(settings.variables)
+
 	// SAVE VARIABLES INSIDE GIN CONTEXT
-	r.Use(internal.EnviromentMiddleware(variables))
+	r.Use(internal.EnviromentMiddleware(enviroment))
+	r.Use(internal.QueueMiddleware(queue))
+	r.Use(internal.ProberMiddleware(probers))
+	r.Use(internal.BandwidthMiddleware(bandwidthOptions))
+	r.Use(internal.SchedulerMiddleware(scheduler))
+	r.Use(internal.HistoryMiddleware(history))
 	//r.Use(internal.GroupMiddleware(latencyGroup))
 
 	// HTTP SERVER ROUTES
 	r.GET("/heartbeat", pkg.HeartbeatEndpoint)
 	r.GET("/latency", pkg.LatencyEndpoint)
 	r.POST("/latency", pkg.ManualLatencyEndpoint)
+	r.GET("/bandwidth", pkg.BandwidthEndpoint)
+	r.GET("/status", pkg.StatusEndpoint)
+	r.GET("/metrics", pkg.MetricsEndpoint)
+	r.GET("/history", pkg.HistoryEndpoint)
+
+	// REGISTRATION, HEARTBEAT, EVENT, LATENCY AND BANDWIDTH AUTO POSTING, EVERY JOB ON ITS OWN SCHEDULE
+	if err := scheduleJobs(scheduler, settings, probers, bandwidthOptions, sinks, history, registration); err != nil {
+		log.Fatalf("Failed to schedule jobs: %v", err)
+	}
+	scheduler.Start()
+	fmt.Println("SCHEDULED JOBS:", scheduler.Status().String())
+	queue.Start(time.Second)
 
-	// HEARTBEAT AND LATENCY AUTO POSTING
-	if heartbeat {
-		fmt.Println("INITIATE HEARTBEAT SCHEDULER")
-		cron := gocron.NewScheduler(time.Local)
-		pkg.HeartbeatCron(cron, appCron, collectorApp, execMode)
-		pkg.LatencyCron(cron, appCron, targetsApp, latencyApp, execMode)
-		cron.StartAsync()
+	// CONFIG RELOAD (SIGHUP, OR A CHANGE OF THE CONFIG FILE CHECKED EVERY CONFIG_WATCH), THE SERVER KEEPS RUNNING
+	// RELOADS THE COLLECTORS, THE URLS, EXEC_MODE, THE SCHEDULES, THE SINKS OF THE JOBS AND THE EVENT THRESHOLDS, EVERYTHING ELSE NEEDS A RESTART
+	configWatch, err := time.ParseDuration(internal.GetEnv("CONFIG_WATCH", "10s"))
+	if err != nil {
+		log.Fatalf("Failed to configure: CONFIG_WATCH: %v", err)
 	}
+	// NOTHING IS APPLIED UNTIL EVERY SETTING OF THE NEW FILE IS VALID AND THE JOBS ARE SCHEDULED, THE COLLECTORS ARE CONFIGURED LAST
+	internal.WatchConfig(configWatch, func(file *internal.ConfigFile) error {
+		newSettings, err := loadSettings(file)
+		if err != nil {
+			return err
+		}
+		collectors, err := internal.NewCollectorSettingsFromConfig(file)
+		if err != nil {
+			return err
+		}
+		// THE EVENT WATCHER KEEPS ITS STATE ACROSS RELOADS, ONLY ITS THRESHOLDS ARE UPDATED
+		thresholds := newSettings.events
+		newSettings.events = settings.events
+		// BACK TO THE JOBS THAT WERE RUNNING, A ROLLBACK THAT FAILS LEAVES THE JOBS IT COULD SCHEDULE AND IS LOGGED
+		restoreJobs := func() {
+			scheduler.Clear()
+			if err := scheduleJobs(scheduler, settings, probers, bandwidthOptions, sinks, history, registration); err != nil {
+				internal.CheckError(fmt.Errorf("config: rollback failed, the running jobs couldn't be scheduled again: %v", err))
+			}
+		}
+		scheduler.Clear()
+		if err := scheduleJobs(scheduler, newSettings, probers, bandwidthOptions, sinks, history, registration); err != nil {
+			restoreJobs()
+			return err
+		}
+		if err := collectors.Apply(); err != nil {
+			// Back to the collectors and the jobs that were running
+			running, runningErr := internal.NewCollectorSettingsFromConfig(internal.CurrentConfig())
+			if runningErr == nil {
+				runningErr = running.Apply()
+			}
+			if runningErr != nil {
+				internal.CheckError(fmt.Errorf("config: rollback failed, the running collectors couldn't be configured again: %v", runningErr))
+			}
+			restoreJobs()
+			return err
+		}
+		settings = newSettings
+		settings.events.Configure(thresholds)
+		enviroment.Set(settings.variables)
+		fmt.Println("SCHEDULED JOBS:", scheduler.Status().String())
+		return nil
+	})
 
 	// Start listening on the desired port (similar to ros' spin, "blocks" thread)
 	r.Run(":" + listenPort)
 }
+
+// Settings that can change when the config is reloaded
+type settings struct {
+	variables         map[string]string
+	heartbeat         bool
+	heartbeatSchedule *internal.Schedule
+	latencySchedule   *internal.Schedule
+	bandwidthSchedule *internal.Schedule
+	registerSchedule  *internal.Schedule
+	eventSchedule     *internal.Schedule
+	events            *internal.EventWatcher
+	heartbeatSinks    string
+	latencySinks      string
+	bandwidthSinks    string
+}
+
+// Reads the settings from the environment and file, a config that may not be used yet
+func loadSettings(file *internal.ConfigFile) (settings, error) {
+	execMode := file.Get("EXEC_MODE", "DEBUG")
+	appProtocol := file.Get("APP_PROTOCOL", "http")
+	appIP := file.Get("APP_IP", "localhost:8080")
+	collectorUrl := file.Get("APP_URL", "collector")
+	targetsUrl := file.Get("TARGETS_URL", "latency/servers/targets")
+	latencyUrl := file.Get("LATENCY_URL", "latency")
+	bandwidthUrl := file.Get("BANDWIDTH_URL", "bandwidth")
+	registerUrl := file.Get("REGISTER_URL", "inventory/register")
+	appCron := file.Get("APP_CRON", "30")
+	bandwidthCron := file.Get("BANDWIDTH_CRON", "600")
+	sinks := file.Get("SINKS", "http")
+	heartbeat, err := strconv.ParseBool(file.Get("HEARTBEAT", "true"))
+	if err != nil {
+		return settings{}, fmt.Errorf("HEARTBEAT: %v", err)
+	}
+
+	// MAP VARIABLES INTO MAP
+	s := settings{
+		variables: map[string]string{
+			"EXEC_MODE":     execMode,
+			"COLLECTOR_APP": internal.UrlMaker(appProtocol, appIP, collectorUrl),
+			"LATENCY_APP":   internal.UrlMaker(appProtocol, appIP, latencyUrl),
+			"BANDWIDTH_APP": internal.UrlMaker(appProtocol, appIP, bandwidthUrl),
+			"TARGETS_APP":   internal.UrlMaker(appProtocol, appIP, targetsUrl),
+			"REGISTER_APP":  internal.UrlMaker(appProtocol, appIP, registerUrl),
+		},
+		heartbeat:      heartbeat,
+		heartbeatSinks: file.Get("HEARTBEAT_SINKS", sinks),
+		latencySinks:   file.Get("LATENCY_SINKS", sinks),
+		bandwidthSinks: file.Get("BANDWIDTH_SINKS", sinks),
+	}
+	// TARGETS_FILE READS THE LATENCY AND BANDWIDTH TARGETS FROM A FILE INSTEAD OF THE GATEWAY
+	if targetsFile := file.Get("TARGETS_FILE", ""); targetsFile != "" {
+		s.variables["TARGETS_APP"] = "file://" + targetsFile
+	}
+
+	// JOB SCHEDULES (<JOB>_SCHEDULE, <JOB>_OFFSET AND <JOB>_JITTER, APP_CRON AND BANDWIDTH_CRON ARE THE DEFAULT SCHEDULES)
+	if s.heartbeatSchedule, err = internal.NewScheduleFromConfig(file, "heartbeat", appCron); err != nil {
+		return settings{}, err
+	}
+	if s.latencySchedule, err = internal.NewScheduleFromConfig(file, "latency", appCron); err != nil {
+		return settings{}, err
+	}
+	if s.bandwidthSchedule, err = internal.NewScheduleFromConfig(file, "bandwidth", bandwidthCron); err != nil {
+		return settings{}, err
+	}
+	if s.registerSchedule, err = internal.NewScheduleFromConfig(file, "register", "15m"); err != nil {
+		return settings{}, err
+	}
+	// EVENT HEARTBEATS (EVENT_SCHEDULE SAMPLES THE NODE, EVENT_CPU, EVENT_MEMORY, EVENT_DISK, EVENT_CONTAINERS AND EVENT_COOLDOWN)
+	if s.eventSchedule, err = internal.NewScheduleFromConfig(file, "event", "5s"); err != nil {
+		return settings{}, err
+	}
+	if s.events, err = internal.NewEventWatcherFromConfig(file); err != nil {
+		return settings{}, err
+	}
+	return s, nil
+}
+
+// Adds the enabled jobs to the scheduler, HEARTBEAT=false disables every job (a disabled schedule is nil)
+func scheduleJobs(scheduler *internal.Scheduler, s settings, probers *internal.Probers, bandwidthOptions *internal.BandwidthOptions, sinks *internal.Sinks, history *internal.History, registration *internal.Registration) error {
+	if !s.heartbeat {
+		return nil
+	}
+	execMode := s.variables["EXEC_MODE"]
+	if s.registerSchedule != nil {
+		if err := pkg.RegisterCron(scheduler, *s.registerSchedule, s.variables["REGISTER_APP"], execMode, registration); err != nil {
+			return err
+		}
+	}
+	if s.heartbeatSchedule != nil {
+		group, err := sinks.Group(s.heartbeatSinks)
+		if err != nil {
+			return err
+		}
+		if err := pkg.HeartbeatCron(scheduler, *s.heartbeatSchedule, s.variables["COLLECTOR_APP"], execMode, group, history); err != nil {
+			return err
+		}
+	}
+	if s.eventSchedule != nil {
+		group, err := sinks.Group(s.heartbeatSinks)
+		if err != nil {
+			return err
+		}
+		if err := pkg.EventCron(scheduler, *s.eventSchedule, s.variables["COLLECTOR_APP"], execMode, s.events, group, history); err != nil {
+			return err
+		}
+	}
+	if s.latencySchedule != nil {
+		group, err := sinks.Group(s.latencySinks)
+		if err != nil {
+			return err
+		}
+		if err := pkg.LatencyCron(scheduler, *s.latencySchedule, s.variables["TARGETS_APP"], s.variables["LATENCY_APP"], execMode, probers, group, history); err != nil {
+			return err
+		}
+	}
+	if s.bandwidthSchedule != nil {
+		group, err := sinks.Group(s.bandwidthSinks)
+		if err != nil {
+			return err
+		}
+		if err := pkg.BandwidthCron(scheduler, *s.bandwidthSchedule, s.variables["TARGETS_APP"], s.variables["BANDWIDTH_APP"], execMode, bandwidthOptions, group); err != nil {
+			return err
+		}
+	}
+	return nil
+}
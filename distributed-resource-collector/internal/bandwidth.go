@@ -0,0 +1,345 @@
+package internal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Size of every write of the bulk transfer
+const bandwidthChunkSize = 128 * 1024
+
+// Answer of a bandwidth server that is already running a test, sent instead of the transfer
+const bandwidthBusy = "BUSY"
+
+// -- BANDWIDTH SERVER
+// BandwidthServer is the peer of the bandwidth tests of other DRCs. Every test is a single TCP connection, the client sends a header line
+// "<U|D> <milliseconds>\n": for U (upload) the client sends data until it closes its side and the server answers with the number of bytes it
+// received, for D (download) the server sends data for the given time and closes the connection.
+// The server runs one test at a time, parallel tests would measure each other, and answers "BUSY\n" to the rest. Only the peers
+// of the peer list can run a test, the connections from other addresses are closed.
+type BandwidthServer struct {
+	Port        string
+	MaxDuration time.Duration // longest transfer the server accepts
+	Peers       *PeerList
+	listener    net.Listener
+	running     chan struct{} // holds the test in progress
+}
+
+func NewBandwidthServer(port string, maxDuration time.Duration, peers *PeerList) (*BandwidthServer, error) {
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return nil, fmt.Errorf("bandwidth: invalid port %s", port)
+	}
+	return &BandwidthServer{Port: port, MaxDuration: maxDuration, Peers: peers, running: make(chan struct{}, 1)}, nil
+}
+
+// NewBandwidthServerFromEnv creates the server from BANDWIDTH_PORT (7008) and BANDWIDTH_ALLOW (inventory), it's nil unless BANDWIDTH_ENABLED is true
+func NewBandwidthServerFromEnv() (*BandwidthServer, error) {
+	enabled, err := strconv.ParseBool(GetEnv("BANDWIDTH_ENABLED", "false"))
+	if err != nil {
+		return nil, fmt.Errorf("bandwidth: BANDWIDTH_ENABLED: %v", err)
+	}
+	if !enabled {
+		return nil, nil
+	}
+	inventoryUrl := UrlMaker(GetEnv("APP_PROTOCOL", "http"), GetEnv("APP_IP", "localhost:8080"), GetEnv("INVENTORY_URL", "inventory"))
+	peers, err := NewPeerList(GetEnv("BANDWIDTH_ALLOW", "inventory"), inventoryUrl)
+	if err != nil {
+		return nil, err
+	}
+	return NewBandwidthServer(GetEnv("BANDWIDTH_PORT", "7008"), 10*time.Second, peers)
+}
+
+// Start listens on the bandwidth port and serves the tests in the background
+func (b *BandwidthServer) Start() error {
+	listener, err := net.Listen("tcp", ":"+b.Port)
+	if err != nil {
+		return fmt.Errorf("bandwidth: %v", err)
+	}
+	b.listener = listener
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(conn)
+		}
+	}()
+	return nil
+}
+
+// Close stops the server
+func (b *BandwidthServer) Close() {
+	if b.listener != nil {
+		b.listener.Close()
+	}
+}
+
+func (b *BandwidthServer) serve(conn net.Conn) {
+	defer conn.Close()
+	if b.Peers != nil {
+		if addr, ok := conn.RemoteAddr().(*net.TCPAddr); !ok || !b.Peers.Allowed(addr.IP) {
+			CheckError(fmt.Errorf("bandwidth: refused test from %s, not in the peer list", conn.RemoteAddr()))
+			return
+		}
+	}
+	conn.SetDeadline(time.Now().Add(b.MaxDuration + 5*time.Second))
+	reader := bufio.NewReader(conn)
+	direction, duration, err := readBandwidthHeader(reader)
+	if err != nil {
+		return
+	}
+	if duration > b.MaxDuration {
+		duration = b.MaxDuration
+	}
+
+	select {
+	case b.running <- struct{}{}:
+		defer func() { <-b.running }()
+	default:
+		fmt.Fprintf(conn, "%s\n", bandwidthBusy)
+		return
+	}
+
+	switch direction {
+	case "U":
+		received, _ := io.Copy(ioutil.Discard, reader)
+		fmt.Fprintf(conn, "%d\n", received)
+	case "D":
+		sendBulk(conn, duration)
+	}
+}
+
+func readBandwidthHeader(reader *bufio.Reader) (string, time.Duration, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return "", 0, err
+	}
+	fields := strings.Fields(line)
+	if len(fields) != 2 || (fields[0] != "U" && fields[0] != "D") {
+		return "", 0, fmt.Errorf("bandwidth: invalid header %q", line)
+	}
+	milliseconds, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil || milliseconds <= 0 {
+		return "", 0, fmt.Errorf("bandwidth: invalid duration %q", fields[1])
+	}
+	return fields[0], time.Duration(milliseconds) * time.Millisecond, nil
+}
+
+// Writes for the given time, returns the number of bytes written
+func sendBulk(conn net.Conn, duration time.Duration) int64 {
+	chunk := make([]byte, bandwidthChunkSize)
+	sent := int64(0)
+	end := time.Now().Add(duration)
+	for time.Now().Before(end) {
+		n, err := conn.Write(chunk)
+		sent += int64(n)
+		if err != nil {
+			break
+		}
+	}
+	return sent
+}
+
+// -- PEERS
+// PeerList is the set of addresses allowed to run bandwidth tests. "inventory" allows the nodes of the inventory (the asset key and the
+// properties.hostname of every asset), read from the gateway when an unknown address connects, at most once per minute.
+// IP addresses and CIDRs are allowed as they are, and "any" allows every address.
+type PeerList struct {
+	Any       bool
+	Inventory string // GET url of the inventory assets, empty without the inventory
+	networks  []*net.IPNet
+	mu        sync.Mutex
+	inventory map[string]bool
+	refreshed time.Time
+}
+
+// NewPeerList reads a comma separated list of "inventory", "any", IP addresses and CIDRs
+func NewPeerList(list string, inventoryUrl string) (*PeerList, error) {
+	peers := &PeerList{inventory: map[string]bool{}}
+	for _, item := range SplitList(list) {
+		switch {
+		case item == "any":
+			peers.Any = true
+		case item == "inventory":
+			peers.Inventory = inventoryUrl
+		case strings.Contains(item, "/"):
+			_, network, err := net.ParseCIDR(item)
+			if err != nil {
+				return nil, fmt.Errorf("bandwidth: invalid peer %s: %v", item, err)
+			}
+			peers.networks = append(peers.networks, network)
+		default:
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("bandwidth: invalid peer %s, expected inventory, any, an IP address or a CIDR", item)
+			}
+			peers.networks = append(peers.networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+		}
+	}
+	return peers, nil
+}
+
+// Allowed returns true when the address is in the list
+func (p *PeerList) Allowed(ip net.IP) bool {
+	if p.Any {
+		return true
+	}
+	for _, network := range p.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	if p.Inventory == "" {
+		return false
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.inventory[ip.String()] && time.Since(p.refreshed) > time.Minute {
+		p.refreshed = time.Now()
+		if err := p.refresh(); err != nil {
+			CheckError(fmt.Errorf("bandwidth: failed to read the inventory peers: %v", err))
+		}
+	}
+	return p.inventory[ip.String()]
+}
+
+// Must be called with mu locked
+func (p *PeerList) refresh() error {
+	res, err := GatewayClient.Get(p.Inventory)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned %s", p.Inventory, res.Status)
+	}
+	assets := []Asset{}
+	if err := json.NewDecoder(res.Body).Decode(&assets); err != nil {
+		return err
+	}
+	inventory := map[string]bool{}
+	for _, asset := range assets {
+		for _, host := range []string{asset.ID, asset.Properties.Hostname} {
+			if ip := net.ParseIP(host); ip != nil {
+				inventory[ip.String()] = true
+			} else if host != "" {
+				addresses, _ := net.LookupHost(host)
+				for _, address := range addresses {
+					inventory[address] = true
+				}
+			}
+		}
+	}
+	p.inventory = inventory
+	return nil
+}
+
+// -- BANDWIDTH TEST
+// BandwidthOptions configures the bandwidth tests of this DRC
+type BandwidthOptions struct {
+	Direction string        // download or upload
+	Duration  time.Duration // length of every transfer
+	Timeout   time.Duration // connection timeout, the transfer itself is limited by Duration
+}
+
+// NewBandwidthOptionsFromEnv reads BANDWIDTH_DIRECTION (download) and BANDWIDTH_DURATION (2s)
+func NewBandwidthOptionsFromEnv() (*BandwidthOptions, error) {
+	direction := GetEnv("BANDWIDTH_DIRECTION", "download")
+	if direction != "download" && direction != "upload" {
+		return nil, fmt.Errorf("bandwidth: unknown direction %s, expected download or upload", direction)
+	}
+	duration, err := time.ParseDuration(GetEnv("BANDWIDTH_DURATION", "2s"))
+	if err != nil {
+		return nil, fmt.Errorf("bandwidth: BANDWIDTH_DURATION: %v", err)
+	}
+	return &BandwidthOptions{Direction: direction, Duration: duration, Timeout: 5 * time.Second}, nil
+}
+
+// MeasureBandwidth runs a timed TCP bulk transfer against the bandwidth server of the target. Downloads count the bytes received,
+// uploads the bytes the server says it received, so data still buffered by the kernel isn't counted.
+func MeasureBandwidth(options *BandwidthOptions, target LatencyTarget) (BandwidthResult, error) {
+	result := BandwidthResult{Hostname: target.Hostname, Direction: options.Direction, Bandwidth: -1}
+	if target.BandwidthPort == "" {
+		return result, fmt.Errorf("bandwidth: %s has no bandwidth port", target.Hostname)
+	}
+
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(target.Hostname, target.BandwidthPort), options.Timeout)
+	if err != nil {
+		return result, fmt.Errorf("bandwidth: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(options.Duration + 2*options.Timeout))
+
+	header := "D"
+	if options.Direction == "upload" {
+		header = "U"
+	}
+	start := time.Now()
+	if _, err := fmt.Fprintf(conn, "%s %d\n", header, options.Duration.Milliseconds()); err != nil {
+		return result, fmt.Errorf("bandwidth: %v", err)
+	}
+
+	reader := bufio.NewReader(conn)
+	if options.Direction == "upload" {
+		// The server doesn't write until the upload ends, unless it's busy
+		answer := make(chan string, 1)
+		go func() {
+			line, _ := reader.ReadString('\n')
+			answer <- strings.TrimSpace(line)
+		}()
+		sendBulk(conn, options.Duration)
+		if tcp, ok := conn.(*net.TCPConn); ok {
+			tcp.CloseWrite()
+		}
+		line := <-answer
+		if line == bandwidthBusy {
+			return result, fmt.Errorf("bandwidth: %s is busy with another test", target.Hostname)
+		}
+		if line == "" {
+			return result, fmt.Errorf("bandwidth: no answer from %s, this node may not be in its peer list", target.Hostname)
+		}
+		result.Bytes, err = strconv.ParseInt(line, 10, 64)
+		if err != nil {
+			return result, fmt.Errorf("bandwidth: invalid answer from %s: %q", target.Hostname, line)
+		}
+	} else {
+		// The bulk data is zeros, a busy server answers with text
+		if peek, err := reader.Peek(len(bandwidthBusy) + 1); err == nil && string(peek) == bandwidthBusy+"\n" {
+			return result, fmt.Errorf("bandwidth: %s is busy with another test", target.Hostname)
+		}
+		result.Bytes, err = io.Copy(ioutil.Discard, reader)
+		if err != nil {
+			return result, fmt.Errorf("bandwidth: %v", err)
+		}
+		if result.Bytes == 0 {
+			return result, fmt.Errorf("bandwidth: %s closed the connection, this node may not be in its peer list", target.Hostname)
+		}
+	}
+
+	elapsed := time.Since(start)
+	result.Duration = float64(elapsed.Microseconds()) / 1000
+	if elapsed > 0 {
+		result.Bandwidth = float64(result.Bytes) * 8 / elapsed.Seconds() / 1e6
+	}
+	return result, nil
+}
+
+// Adds the bandwidth options to the gin context, used by the bandwidth endpoint
+func BandwidthMiddleware(options *BandwidthOptions) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("BANDWIDTH", options)
+	}
+}
@@ -0,0 +1,39 @@
+package internal
+
+import (
+	"encoding/json"
+
+	"github.com/wI2L/jettison"
+)
+
+// -- Bandwidth Results
+// Bandwidth tests run against the same targets as the latency probes, only the targets with a bandwidth port (inventory property bandwidthPort)
+type BandwidthResults struct {
+	Source    string            `json:"source"`
+	Timestamp LatencyTimestamp  `json:"timestamp"`
+	Results   []BandwidthResult `json:"results"`
+}
+
+func (r BandwidthResults) String() string {
+	s, _ := jettison.MarshalOpts(r, jettison.NilMapEmpty(), jettison.NilSliceEmpty())
+	return string(s)
+}
+
+func BandwidthResultsJsonToStruct(v string) (results BandwidthResults, err error) {
+	err = json.Unmarshal([]byte(v), &results)
+	return results, err
+}
+
+// Bandwidth is in Mbit/s, -1 when the test failed. Direction is download (the target sends to this DRC) or upload (this DRC sends to the target).
+type BandwidthResult struct {
+	Hostname  string  `json:"hostname"`
+	Bandwidth float64 `json:"bandwidth"`
+	Direction string  `json:"direction"`
+	Bytes     int64   `json:"bytes"`
+	Duration  float64 `json:"duration"` // ms
+}
+
+func (r BandwidthResult) String() string {
+	s, _ := json.Marshal(r)
+	return string(s)
+}
@@ -0,0 +1,195 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Collector is a single source of metrics for the heartbeat. Every collector writes its own section of DrcStats,
+// collectors that don't have a dedicated section should store their result in DrcStats.Extra under their own name.
+// New sources are added by calling RegisterCollector from an init function, GetServerStats doesn't need to know about them.
+type Collector interface {
+	// Name identifies the collector in the configuration (COLLECTORS, COLLECTOR_<NAME>_<OPTION>)
+	Name() string
+	// Configure receives the collector options, keys are lowercase. Unknown keys should be ignored.
+	Configure(options map[string]string) error
+	// Collect adds the collector results to the stats that are being built
+	Collect(stats *DrcStats) error
+}
+
+type collectorEntry struct {
+	collector Collector
+	enabled   bool
+}
+
+var collectorRegistry = struct {
+	sync.RWMutex
+	order   []string
+	entries map[string]*collectorEntry
+}{entries: map[string]*collectorEntry{}}
+
+// RegisterCollector adds a collector to the registry, enabled by default. Registering the same name twice replaces the old collector.
+func RegisterCollector(collector Collector) {
+	collectorRegistry.Lock()
+	defer collectorRegistry.Unlock()
+	name := collector.Name()
+	if _, ok := collectorRegistry.entries[name]; !ok {
+		collectorRegistry.order = append(collectorRegistry.order, name)
+	}
+	collectorRegistry.entries[name] = &collectorEntry{collector: collector, enabled: true}
+}
+
+// CollectorNames returns the name of every registered collector in registration order
+func CollectorNames() []string {
+	collectorRegistry.RLock()
+	defer collectorRegistry.RUnlock()
+	return append([]string{}, collectorRegistry.order...)
+}
+
+// EnabledCollectors returns the name of every collector that currently takes part in GetServerStats
+func EnabledCollectors() (names []string) {
+	collectorRegistry.RLock()
+	defer collectorRegistry.RUnlock()
+	for _, name := range collectorRegistry.order {
+		if collectorRegistry.entries[name].enabled {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func EnableCollector(name string, enabled bool) error {
+	collectorRegistry.Lock()
+	defer collectorRegistry.Unlock()
+	entry, ok := collectorRegistry.entries[name]
+	if !ok {
+		return fmt.Errorf("collector: %s is not registered", name)
+	}
+	entry.enabled = enabled
+	return nil
+}
+
+func ConfigureCollector(name string, options map[string]string) error {
+	collectorRegistry.RLock()
+	entry, ok := collectorRegistry.entries[name]
+	collectorRegistry.RUnlock()
+	if !ok {
+		return fmt.Errorf("collector: %s is not registered", name)
+	}
+	if err := entry.collector.Configure(options); err != nil {
+		return fmt.Errorf("collector: %s: %v", name, err)
+	}
+	return nil
+}
+
+// ConfigureCollectorsFromEnv enables and configures the registered collectors from the environment:
+// COLLECTORS is a comma separated list of the collectors to run ("all" by default), COLLECTORS_DISABLED removes collectors from that list,
+// and every COLLECTOR_<NAME>_<OPTION>=value variable is passed to the collector as the option "<option>".
+// Every setting can also come from the config file (section collector.<name> for the options).
+func ConfigureCollectorsFromEnv() error {
+	collectors, err := NewCollectorSettingsFromConfig(CurrentConfig())
+	if err != nil {
+		return err
+	}
+	return collectors.Apply()
+}
+
+// CollectorSettings are the collectors to enable and their options, read from a config before they are applied
+type CollectorSettings struct {
+	enabled map[string]bool
+	options map[string]map[string]string
+}
+
+// NewCollectorSettingsFromConfig reads the settings of ConfigureCollectorsFromEnv from file without applying them
+func NewCollectorSettingsFromConfig(file *ConfigFile) (*CollectorSettings, error) {
+	enabled := SplitList(file.Get("COLLECTORS", "all"))
+	disabled := SplitList(file.Get("COLLECTORS_DISABLED", ""))
+
+	for _, name := range append(append([]string{}, enabled...), disabled...) {
+		if name != "all" && !StringInSlice(name, CollectorNames()) {
+			return nil, fmt.Errorf("collector: %s is not registered, available collectors are %s", name, strings.Join(CollectorNames(), ", "))
+		}
+	}
+
+	settings := &CollectorSettings{enabled: map[string]bool{}, options: map[string]map[string]string{}}
+	for _, name := range CollectorNames() {
+		settings.enabled[name] = (StringInSlice("all", enabled) || StringInSlice(name, enabled)) && !StringInSlice(name, disabled)
+		settings.options[name] = collectorOptions(file, name)
+	}
+	return settings, nil
+}
+
+// Apply configures every collector, the collectors are only enabled and disabled when every collector took its options
+func (s *CollectorSettings) Apply() error {
+	for _, name := range CollectorNames() {
+		if err := ConfigureCollector(name, s.options[name]); err != nil {
+			return err
+		}
+	}
+	for _, name := range CollectorNames() {
+		if err := EnableCollector(name, s.enabled[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns every COLLECTOR_<NAME>_<OPTION> variable as a map of lowercase option names, the environment overrides the config file
+func collectorOptions(file *ConfigFile, name string) map[string]string {
+	prefix := "COLLECTOR_" + strings.ToUpper(name) + "_"
+	options := map[string]string{}
+	for _, key := range file.Keys(prefix) {
+		if value, _ := file.Value(key); value != "" {
+			options[strings.ToLower(strings.TrimPrefix(key, prefix))] = value
+		}
+	}
+	for _, env := range os.Environ() {
+		pair := strings.SplitN(env, "=", 2)
+		if len(pair) == 2 && strings.HasPrefix(pair[0], prefix) && pair[1] != "" {
+			options[strings.ToLower(strings.TrimPrefix(pair[0], prefix))] = pair[1]
+		}
+	}
+	return options
+}
+
+// Runs every enabled collector over the same DrcStats. A failing collector is reported and skipped, the rest of the heartbeat is still sent.
+func collectStats(stats *DrcStats) {
+	collectorRegistry.RLock()
+	collectors := []Collector{}
+	for _, name := range collectorRegistry.order {
+		if entry := collectorRegistry.entries[name]; entry.enabled {
+			collectors = append(collectors, entry.collector)
+		}
+	}
+	collectorRegistry.RUnlock()
+
+	for _, collector := range collectors {
+		if err := collector.Collect(stats); err != nil {
+			CheckError(fmt.Errorf("collector: %s: %v", collector.Name(), err))
+		}
+	}
+}
+
+// CollectOnce runs a single registered collector over stats, enabled or not
+func CollectOnce(name string, stats *DrcStats) error {
+	collectorRegistry.RLock()
+	entry, ok := collectorRegistry.entries[name]
+	collectorRegistry.RUnlock()
+	if !ok {
+		return fmt.Errorf("collector: %s is not registered", name)
+	}
+	if err := entry.collector.Collect(stats); err != nil {
+		return fmt.Errorf("collector: %s: %v", name, err)
+	}
+	return nil
+}
+
+// SetExtra stores the result of a collector without a dedicated DrcStats section
+func (d *DrcStats) SetExtra(name string, value interface{}) {
+	if d.Extra == nil {
+		d.Extra = map[string]interface{}{}
+	}
+	d.Extra[name] = value
+}
@@ -0,0 +1,245 @@
+package internal
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// -- CONFIG FILE
+// The config file holds the same settings as the environment variables, the keys are the variable names (case doesn't matter).
+// Nested sections are joined with "_", so `latency: {method: tcp}` is LATENCY_METHOD and `collector: {docker: {timeout: 5s}}` is
+// COLLECTOR_DOCKER_TIMEOUT. Lists are joined with commas (COLLECTORS: [cpu, mem]). Environment variables override the file.
+var config = struct {
+	sync.RWMutex
+	file *ConfigFile
+	seen time.Time // modification time of the last file that was read, loaded or not
+}{file: &ConfigFile{values: map[string]string{}}}
+
+// ConfigFile holds the values of a config file that was read, they are only used by GetEnv after UseConfig
+type ConfigFile struct {
+	path    string
+	modTime time.Time
+	values  map[string]string
+}
+
+// ReadConfig reads the YAML config file at path without applying it. An empty path returns a config without values.
+func ReadConfig(path string) (*ConfigFile, error) {
+	file := &ConfigFile{path: path, values: map[string]string{}}
+	if path == "" {
+		return file, nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("config: %v", err)
+	}
+	file.modTime = info.ModTime()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("config: %v", err)
+	}
+	var root map[interface{}]interface{}
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("config: %s: %v", path, err)
+	}
+	for key, value := range root {
+		if err := flattenConfig(fmt.Sprint(key), value, file.values); err != nil {
+			return nil, fmt.Errorf("config: %s: %v", path, err)
+		}
+	}
+	return file, nil
+}
+
+// UseConfig replaces the values read before with the values of file
+func UseConfig(file *ConfigFile) {
+	config.Lock()
+	defer config.Unlock()
+	config.file = file
+	config.seen = file.modTime
+}
+
+// LoadConfig reads the YAML config file at path and replaces the values read before. An empty path clears them.
+func LoadConfig(path string) error {
+	file, err := ReadConfig(path)
+	if err != nil {
+		return err
+	}
+	UseConfig(file)
+	return nil
+}
+
+// CurrentConfig returns the config that GetEnv reads
+func CurrentConfig() *ConfigFile {
+	config.RLock()
+	defer config.RUnlock()
+	return config.file
+}
+
+// Get returns the environment variable key, or its value in the config file, or fallback when both are empty
+func (c *ConfigFile) Get(key string, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok && value != "" {
+		return value
+	}
+	if value, ok := c.values[key]; ok && value != "" {
+		return value
+	}
+	return fallback
+}
+
+// Keys returns the keys of the config file that start with prefix, sorted
+func (c *ConfigFile) Keys(prefix string) (keys []string) {
+	for key := range c.values {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Value returns the value of key in the config file
+func (c *ConfigFile) Value(key string) (string, bool) {
+	value, ok := c.values[key]
+	return value, ok
+}
+
+// Adds the value to values under key, sections are joined to the key with "_"
+func flattenConfig(key string, value interface{}, values map[string]string) error {
+	key = strings.ToUpper(strings.TrimSpace(key))
+	if _, ok := values[key]; ok {
+		return fmt.Errorf("%s is set twice", key)
+	}
+	switch v := value.(type) {
+	case nil:
+	case map[interface{}]interface{}:
+		for subKey, subValue := range v {
+			if err := flattenConfig(key+"_"+fmt.Sprint(subKey), subValue, values); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		items := []string{}
+		for _, item := range v {
+			switch item.(type) {
+			case map[interface{}]interface{}, []interface{}:
+				return fmt.Errorf("%s: lists can only hold plain values", key)
+			}
+			items = append(items, fmt.Sprint(item))
+		}
+		values[key] = strings.Join(items, ",")
+	case string:
+		values[key] = v
+	case bool:
+		values[key] = strconv.FormatBool(v)
+	default:
+		values[key] = fmt.Sprint(v)
+	}
+	return nil
+}
+
+// ConfigValue returns the value of key in the config file
+func ConfigValue(key string) (string, bool) {
+	return CurrentConfig().Value(key)
+}
+
+// ConfigPath returns the path of the loaded config file, empty without one
+func ConfigPath() string {
+	return CurrentConfig().path
+}
+
+// ConfigKeys returns the keys of the config file that start with prefix, sorted
+func ConfigKeys(prefix string) []string {
+	return CurrentConfig().Keys(prefix)
+}
+
+// Returns true when the config file was modified after it was last read
+func configChanged() bool {
+	config.RLock()
+	path, seen := config.file.path, config.seen
+	config.RUnlock()
+	if path == "" {
+		return false
+	}
+	info, err := os.Stat(path)
+	return err == nil && !info.ModTime().Equal(seen)
+}
+
+// Remembers the modification time of a file that failed to load or to apply, it isn't retried until it changes again
+func markConfigSeen(modTime time.Time) {
+	config.Lock()
+	defer config.Unlock()
+	config.seen = modTime
+}
+
+// WatchConfig reloads the config file on SIGHUP and when the file changes (checked every interval, 0 only reloads on SIGHUP).
+// The file is read without replacing the running values and passed to reload, which builds and applies the new settings from it.
+// The values are only replaced when reload succeeds, errors keep the config and the settings that were running.
+func WatchConfig(interval time.Duration, reload func(file *ConfigFile) error) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	var tick <-chan time.Time
+	if interval > 0 {
+		tick = time.NewTicker(interval).C
+	}
+
+	go func() {
+		for {
+			select {
+			case <-hangup:
+			case <-tick:
+				if !configChanged() {
+					continue
+				}
+			}
+			path := ConfigPath()
+			file, err := ReadConfig(path)
+			if err != nil {
+				if info, statErr := os.Stat(path); statErr == nil {
+					markConfigSeen(info.ModTime())
+				}
+				fmt.Println("config: reload failed:", err)
+				continue
+			}
+			if err := reload(file); err != nil {
+				markConfigSeen(file.modTime)
+				fmt.Println("config: reload failed:", err)
+				continue
+			}
+			UseConfig(file)
+			fmt.Println("CONFIG RELOADED:", path)
+		}
+	}()
+}
+
+// -- ENVIROMENT
+// Enviroment holds the variables added to the gin context by EnviromentMiddleware, they can be replaced while the server runs
+type Enviroment struct {
+	mu     sync.RWMutex
+	values map[string]string
+}
+
+func NewEnviroment(values map[string]string) *Enviroment {
+	return &Enviroment{values: values}
+}
+
+// Set replaces every variable, requests already running keep the old values
+func (e *Enviroment) Set(values map[string]string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.values = values
+}
+
+func (e *Enviroment) Values() map[string]string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.values
+}
@@ -1,7 +1,9 @@
 package internal
 
 import (
+	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/shirou/gopsutil/cpu"
@@ -11,8 +13,9 @@ import (
 // There is a function that brings the complete average usage, however, I opted for each core performance
 // Both can be used, however, the average usage is calculated in a similar fashion, and it would require
 // running a second function. If we calculate the average from the data we retrieved, we have more information to work with.
-func GetCPUUsage() (CPUStats DrcCPUStats) {
-	tmpCPU, _ := cpu.Percent(time.Second/5, true)
+// The interval is the sampling window used by cpu.Percent, it's also the time the heartbeat waits for this collector.
+func GetCPUUsage(interval time.Duration) (CPUStats DrcCPUStats) {
+	tmpCPU, _ := cpu.Percent(interval, true)
 	totalPercent := 0.0
 	for _, percent := range tmpCPU {
 		totalPercent += percent
@@ -48,3 +51,43 @@ func GetCPUUsage() (CPUStats DrcCPUStats) {
 		CoreUsage:    tmpCPU,
 	}
 }
+
+// -- COLLECTOR
+// Options: interval (sampling window for cpu.Percent, 200ms by default)
+type cpuCollector struct {
+	mutex    sync.Mutex
+	interval time.Duration
+}
+
+func init() {
+	RegisterCollector(&cpuCollector{interval: time.Second / 5})
+}
+
+func (c *cpuCollector) Name() string {
+	return "cpu"
+}
+
+func (c *cpuCollector) Configure(options map[string]string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if value, ok := options["interval"]; ok {
+		interval, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		if interval <= 0 {
+			return fmt.Errorf("interval must be greater than zero, got %s", value)
+		}
+		c.interval = interval
+	}
+	return nil
+}
+
+func (c *cpuCollector) Collect(stats *DrcStats) error {
+	// The lock isn't held during the sampling window, a reload doesn't wait for it
+	c.mutex.Lock()
+	interval := c.interval
+	c.mutex.Unlock()
+	stats.CPUStats = GetCPUUsage(interval)
+	return nil
+}
@@ -63,12 +63,27 @@ func (d DrcProcStats) String() string {
 }
 
 // -- DOCKER
+// CPUPercent is calculated like "docker stats", 100% is one full core. MemoryUsage doesn't count the page cache.
+// Network and block IO are cumulative since the container was started, in bytes.
 type DrcDockerStats struct {
-	ContainerID string `json:"containerID"`
-	Name        string `json:"name"`
-	Image       string `json:"image"`
-	Status      string `json:"status"`
-	State       string `json:"State"`
+	ContainerID   string  `json:"containerID"`
+	Name          string  `json:"name"`
+	Image         string  `json:"image"`
+	Status        string  `json:"status"`
+	State         string  `json:"State"`
+	CPUPercent    float64 `json:"cpuPercent"`
+	MemoryUsage   uint64  `json:"memoryUsage"`
+	MemoryLimit   uint64  `json:"memoryLimit"`
+	MemoryPercent float64 `json:"memoryPercent"`
+	NetworkRx     uint64  `json:"networkRx"`
+	NetworkTx     uint64  `json:"networkTx"`
+	BlockRead     uint64  `json:"blockRead"`
+	BlockWrite    uint64  `json:"blockWrite"`
+}
+
+// The Docker socket reports the State as "running", GO-PsUtil reports it as "true"
+func (d DrcDockerStats) Running() bool {
+	return d.State == "running" || d.State == "true" || d.Status == "running"
 }
 
 func (d DrcDockerStats) String() string {
@@ -76,6 +91,71 @@ func (d DrcDockerStats) String() string {
 	return string(s)
 }
 
+// -- NETWORK
+// Counters are cumulative since boot, rates are per second since the previous sample
+type DrcNetStats struct {
+	Interface       string  `json:"interface"`
+	Speed           uint64  `json:"speed"` // Mbit/s, 0 if unknown
+	BytesSent       uint64  `json:"bytesSent"`
+	BytesRecv       uint64  `json:"bytesRecv"`
+	PacketsSent     uint64  `json:"packetsSent"`
+	PacketsRecv     uint64  `json:"packetsRecv"`
+	ErrorsIn        uint64  `json:"errorsIn"`
+	ErrorsOut       uint64  `json:"errorsOut"`
+	DropsIn         uint64  `json:"dropsIn"`
+	DropsOut        uint64  `json:"dropsOut"`
+	BytesSentRate   float64 `json:"bytesSentRate"`
+	BytesRecvRate   float64 `json:"bytesRecvRate"`
+	PacketsSentRate float64 `json:"packetsSentRate"`
+	PacketsRecvRate float64 `json:"packetsRecvRate"`
+	ErrorRate       float64 `json:"errorRate"`
+	DropRate        float64 `json:"dropRate"`
+	Utilization     float64 `json:"utilization"` // Busiest direction as a percentage of Speed
+}
+
+func (d DrcNetStats) String() string {
+	s, _ := jettison.MarshalOpts(d, jettison.NilMapEmpty(), jettison.NilSliceEmpty())
+	return string(s)
+}
+
+// -- GPU
+type DrcGPUStats struct {
+	Index       int     `json:"index"`
+	Name        string  `json:"name"`
+	Vendor      string  `json:"vendor"`
+	Utilization float64 `json:"utilization"`
+	MemoryUsed  uint64  `json:"memoryUsed"`
+	MemoryTotal uint64  `json:"memoryTotal"`
+	Temperature float64 `json:"temperature"`
+}
+
+func (d DrcGPUStats) String() string {
+	s, _ := jettison.MarshalOpts(d, jettison.NilMapEmpty(), jettison.NilSliceEmpty())
+	return string(s)
+}
+
+// -- THERMAL
+type DrcThermalSensor struct {
+	Name        string  `json:"name"`
+	Temperature float64 `json:"temperature"`
+	Critical    float64 `json:"critical"` // 0 if unknown
+}
+
+// Temperatures are in Celsius and frequencies in MHz
+type DrcThermalStats struct {
+	Sensors        []DrcThermalSensor `json:"sensors"`
+	MaxTemperature float64            `json:"maxTemperature"`
+	CoreFrequency  []float64          `json:"coreFrequency"`
+	MaxFrequency   float64            `json:"maxFrequency"`
+	Throttled      bool               `json:"throttled"`
+	ThrottledFlags []string           `json:"throttledFlags"`
+}
+
+func (d DrcThermalStats) String() string {
+	s, _ := jettison.MarshalOpts(d, jettison.NilMapEmpty(), jettison.NilSliceEmpty())
+	return string(s)
+}
+
 // -- TIMESTAMP
 type DrcTimestamp struct {
 	TimeLocal   time.Time `json:"timeLocal"`
@@ -104,26 +184,52 @@ func (d DrcHost) String() string {
 	return string(s)
 }
 
+// -- EVENTS
+// Threshold crossed between two scheduled heartbeats, the DRC sends an out-of-band heartbeat with the events that triggered it
+type DrcEvent struct {
+	Type      string  `json:"type"`      // cpu, memory, disk or container
+	Subject   string  `json:"subject"`   // disk path or container name, empty for cpu and memory
+	Value     float64 `json:"value"`     // usage in percent, 0 for containers
+	Threshold float64 `json:"threshold"` // percent, 0 for containers
+	State     string  `json:"state"`     // state the container moved to (exited, dead, removed...)
+}
+
 // -- RESPONSE OBJECT
 type DrcStats struct {
-	Timestamp  DrcTimestamp     `json:"timestamp"`
-	DrcHost    DrcHost          `json:"host"`
-	CPUStats   DrcCPUStats      `json:"cpuStats"`
-	MemStats   DrcMemStats      `json:"memStats"`
-	DiskStats  []DrcDiskStats   `json:"diskStats"`
-	ProcStats  DrcProcStats     `json:"procStats"`
-	DockerSats []DrcDockerStats `json:"dockerStats"`
+	Timestamp    DrcTimestamp     `json:"timestamp"`
+	DrcHost      DrcHost          `json:"host"`
+	CPUStats     DrcCPUStats      `json:"cpuStats"`
+	MemStats     DrcMemStats      `json:"memStats"`
+	DiskStats    []DrcDiskStats   `json:"diskStats"`
+	ProcStats    DrcProcStats     `json:"procStats"`
+	DockerSats   []DrcDockerStats `json:"dockerStats"`
+	NetStats     []DrcNetStats    `json:"netStats"`
+	GPUStats     []DrcGPUStats    `json:"gpuStats"`
+	ThermalStats DrcThermalStats  `json:"thermalStats"`
+	// Results of collectors without a dedicated section, indexed by collector name
+	Extra map[string]interface{} `json:"extra,omitempty"`
+	// Thresholds that triggered an out-of-band heartbeat, empty on scheduled heartbeats
+	Events []DrcEvent `json:"events,omitempty"`
+	// State of the forward queue, only reported by the /heartbeat endpoint of the DRC
+	Queue *QueueStatus `json:"queue,omitempty"`
 }
 
 type StoredStat struct {
-	ID         string           `json:"id"`
-	Timestamp  DrcTimestamp     `json:"timestamp"`
-	DrcHost    DrcHost          `json:"host"`
-	CPUStats   DrcCPUStats      `json:"cpuStats"`
-	MemStats   DrcMemStats      `json:"memStats"`
-	DiskStats  []DrcDiskStats   `json:"diskStats"`
-	ProcStats  DrcProcStats     `json:"procStats"`
-	DockerSats []DrcDockerStats `json:"dockerStats"`
+	ID           string           `json:"id"`
+	Timestamp    DrcTimestamp     `json:"timestamp"`
+	DrcHost      DrcHost          `json:"host"`
+	CPUStats     DrcCPUStats      `json:"cpuStats"`
+	MemStats     DrcMemStats      `json:"memStats"`
+	DiskStats    []DrcDiskStats   `json:"diskStats"`
+	ProcStats    DrcProcStats     `json:"procStats"`
+	DockerSats   []DrcDockerStats `json:"dockerStats"`
+	NetStats     []DrcNetStats    `json:"netStats"`
+	GPUStats     []DrcGPUStats    `json:"gpuStats"`
+	ThermalStats DrcThermalStats  `json:"thermalStats"`
+	// Results of collectors without a dedicated section, indexed by collector name
+	Extra map[string]interface{} `json:"extra,omitempty"`
+	// Thresholds that triggered an out-of-band heartbeat, empty on scheduled heartbeats
+	Events []DrcEvent `json:"events,omitempty"`
 }
 
 func JsonToStoredStat(v string) (storedStat StoredStat, err error) {
@@ -136,8 +242,9 @@ func ArrayStoredStat(v string) (storedStats []StoredStat, err error) {
 	return storedStats, err
 }
 
+// encoding/json instead of jettison, which can't encode the interface values of Extra
 func (d DrcStats) String() string {
-	s, _ := jettison.MarshalOpts(d, jettison.NilMapEmpty(), jettison.NilSliceEmpty())
+	s, _ := json.Marshal(d)
 	return string(s)
 }
 
@@ -148,18 +255,24 @@ func DrcJsonToStruct(v string) (drcStats DrcStats, err error) {
 
 func ConvertToStorage(drcStats DrcStats) StoredStat {
 	return StoredStat{
-		ID:         "",
-		Timestamp:  drcStats.Timestamp,
-		DrcHost:    drcStats.DrcHost,
-		CPUStats:   drcStats.CPUStats,
-		MemStats:   drcStats.MemStats,
-		DiskStats:  drcStats.DiskStats,
-		ProcStats:  drcStats.ProcStats,
-		DockerSats: drcStats.DockerSats,
+		ID:           "",
+		Timestamp:    drcStats.Timestamp,
+		DrcHost:      drcStats.DrcHost,
+		CPUStats:     drcStats.CPUStats,
+		MemStats:     drcStats.MemStats,
+		DiskStats:    drcStats.DiskStats,
+		ProcStats:    drcStats.ProcStats,
+		DockerSats:   drcStats.DockerSats,
+		NetStats:     drcStats.NetStats,
+		GPUStats:     drcStats.GPUStats,
+		ThermalStats: drcStats.ThermalStats,
+		Extra:        drcStats.Extra,
+		Events:       drcStats.Events,
 	}
 }
 
+// encoding/json instead of jettison, which can't encode the interface values of Extra
 func (d StoredStat) String() string {
-	s, _ := jettison.MarshalOpts(d, jettison.NilMapEmpty(), jettison.NilSliceEmpty())
+	s, _ := json.Marshal(d)
 	return string(s)
 }
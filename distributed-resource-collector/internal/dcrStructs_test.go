@@ -0,0 +1,25 @@
+package internal
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestDrcStatsStringWithExtra(t *testing.T) {
+	stats := DrcStats{}
+	stats.SetExtra("count", 1)
+	stats.SetExtra("npu", map[string]interface{}{"device": "rknpu", "load": 12.5})
+
+	for name, s := range map[string]string{"DrcStats": stats.String(), "StoredStat": ConvertToStorage(stats).String()} {
+		var decoded DrcStats
+		if err := json.Unmarshal([]byte(s), &decoded); err != nil {
+			t.Fatalf("%s.String() = %s, not JSON: %v", name, s, err)
+		}
+		if decoded.Extra["count"] != 1.0 {
+			t.Errorf("%s.String() extra count = %v, want 1", name, decoded.Extra["count"])
+		}
+		if npu, ok := decoded.Extra["npu"].(map[string]interface{}); !ok || npu["device"] != "rknpu" {
+			t.Errorf("%s.String() extra npu = %v", name, decoded.Extra["npu"])
+		}
+	}
+}
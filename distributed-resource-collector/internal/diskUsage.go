@@ -1,10 +1,13 @@
 package internal
 
 import (
+	"sync"
+
 	"github.com/shirou/gopsutil/disk"
 )
 
-func GetDiskUsage() []DrcDiskStats {
+// Include and exclude are lists of substrings matched against the mount path. An empty include list keeps every mount that isn't excluded.
+func GetDiskUsage(include []string, exclude []string) []DrcDiskStats {
 	parts, err := disk.Partitions(false)
 	CheckError(err)
 
@@ -14,7 +17,7 @@ func GetDiskUsage() []DrcDiskStats {
 		u, err := disk.Usage(part.Mountpoint)
 		CheckError(err)
 
-		if !CustomContains(u.Path, "/snap/", "/etc/") {
+		if (len(include) == 0 || CustomContains(u.Path, include...)) && !(len(exclude) > 0 && CustomContains(u.Path, exclude...)) {
 			tmpUsage := DrcDiskStats{
 				Device: part.Device,
 				//SerialNumber: disk.GetDiskSerialNumber(part.Device),
@@ -31,3 +34,38 @@ func GetDiskUsage() []DrcDiskStats {
 	}
 	return drcUsage
 }
+
+// -- COLLECTOR
+// Options: include and exclude (comma separated substrings of the mount path, exclude defaults to "/snap/,/etc/")
+type diskCollector struct {
+	mutex   sync.Mutex
+	include []string
+	exclude []string
+}
+
+func init() {
+	RegisterCollector(&diskCollector{exclude: []string{"/snap/", "/etc/"}})
+}
+
+func (c *diskCollector) Name() string {
+	return "disk"
+}
+
+func (c *diskCollector) Configure(options map[string]string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if value, ok := options["include"]; ok {
+		c.include = SplitList(value)
+	}
+	if value, ok := options["exclude"]; ok {
+		c.exclude = SplitList(value)
+	}
+	return nil
+}
+
+func (c *diskCollector) Collect(stats *DrcStats) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	stats.DiskStats = GetDiskUsage(c.include, c.exclude)
+	return nil
+}
@@ -0,0 +1,132 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const DefaultDockerHost = "unix:///var/run/docker.sock"
+
+// DockerClient talks to the Docker Engine API (or Podman's Docker compatible API) over a Unix socket or TCP.
+// Only plain HTTP is supported, TLS protected engines (DOCKER_TLS_VERIFY) should be reached through their local socket.
+type DockerClient struct {
+	Host    string
+	Timeout time.Duration
+	baseURL string
+	client  *http.Client
+}
+
+// Error returned by the engine, the API reports the reason as {"message": "..."}
+type DockerError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Message    string
+}
+
+func (e DockerError) Error() string {
+	return fmt.Sprintf("docker: %s %s returned %d: %s", e.Method, e.Path, e.StatusCode, e.Message)
+}
+
+// NewDockerClient creates a client for host, with the same format as DOCKER_HOST (unix:///path/to/socket or tcp://host:port).
+// The timeout applies to the whole request, stats requests need more than a second.
+func NewDockerClient(host string, timeout time.Duration) (*DockerClient, error) {
+	endpoint, err := url.Parse(host)
+	if err != nil {
+		return nil, fmt.Errorf("docker: invalid host %s: %v", host, err)
+	}
+
+	transport := &http.Transport{}
+	dockerClient := &DockerClient{Host: host, Timeout: timeout}
+	switch endpoint.Scheme {
+	case "unix":
+		socket := endpoint.Path
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+		}
+		// The host is ignored by the dialer, it only has to be valid
+		dockerClient.baseURL = "http://docker"
+	case "tcp", "http":
+		dockerClient.baseURL = "http://" + endpoint.Host
+	default:
+		return nil, fmt.Errorf("docker: unsupported host %s, expected unix:// or tcp://", host)
+	}
+	dockerClient.client = &http.Client{Timeout: timeout, Transport: transport}
+	return dockerClient, nil
+}
+
+// DockerHost returns DOCKER_HOST if it's set. Otherwise the first socket found between Docker's and Podman's (rootless and rootful),
+// or an empty string when there is no engine on this host.
+func DockerHost() string {
+	if host := GetEnv("DOCKER_HOST", ""); host != "" {
+		return host
+	}
+	sockets := []string{"/var/run/docker.sock", "/run/podman/podman.sock"}
+	if runtimeDir := GetEnv("XDG_RUNTIME_DIR", ""); runtimeDir != "" {
+		sockets = append(sockets, filepath.Join(runtimeDir, "podman", "podman.sock"))
+	}
+	for _, socket := range sockets {
+		if info, err := os.Stat(socket); err == nil && info.Mode()&os.ModeSocket != 0 {
+			return "unix://" + socket
+		}
+	}
+	return ""
+}
+
+// Sends a GET request and decodes the JSON response into result. Responses outside 2xx are returned as DockerError.
+func (c *DockerClient) get(path string, result interface{}) error {
+	res, err := c.client.Get(c.baseURL + path)
+	if err != nil {
+		return fmt.Errorf("docker: GET %s: %v", path, err)
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("docker: GET %s: %v", path, err)
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		dockerErr := DockerError{Method: http.MethodGet, Path: path, StatusCode: res.StatusCode, Message: strings.TrimSpace(string(body))}
+		var message struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(body, &message) == nil && message.Message != "" {
+			dockerErr.Message = message.Message
+		}
+		return dockerErr
+	}
+	if result == nil {
+		return nil
+	}
+	if err := json.Unmarshal(body, result); err != nil {
+		return fmt.Errorf("docker: GET %s: invalid response: %v", path, err)
+	}
+	return nil
+}
+
+// Ping checks that the engine is reachable
+func (c *DockerClient) Ping() error {
+	return c.get("/_ping", nil)
+}
+
+// ContainerList returns every container, running or not (GET /containers/json?all=1)
+func (c *DockerClient) ContainerList() (containers []DrcDockerSocketStats, err error) {
+	err = c.get("/containers/json?all=1", &containers)
+	return containers, err
+}
+
+// ContainerStats asks the engine for a single stats sample of the container.
+// With stream=false the engine waits for a second sample, so precpu_stats is filled and the request takes about a second.
+func (c *DockerClient) ContainerStats(id string) (containerStats DockerContainerStats, err error) {
+	err = c.get("/containers/"+url.PathEscape(id)+"/stats?stream=false", &containerStats)
+	return containerStats, err
+}
@@ -0,0 +1,111 @@
+package internal
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Response of an engine with two containers, trimmed to the fields the DRC reads
+const containerListResponse = `[
+{"Id":"8dfafdbc3a40","Names":["/web"],"Image":"nginx:1.23","ImageID":"sha256:a99a39d070bf","Command":"/docker-entrypoint.sh nginx -g 'daemon off;'","Created":1678789267,"Ports":[{"IP":"0.0.0.0","PrivatePort":80,"PublicPort":8080,"Type":"tcp"}],"Labels":{"com.docker.compose.service":"web"},"State":"running","Status":"Up 2 hours","HostConfig":{"NetworkMode":"bridge"},"NetworkSettings":{"Networks":{"bridge":{"IPAddress":"172.17.0.2"}}},"Mounts":[]},
+{"Id":"9cd87474be90","Names":["/worker"],"Image":"python:3.11","ImageID":"sha256:1ce8a4c1b6d3","Command":"python worker.py","Created":1678789001,"Ports":[],"Labels":{},"State":"exited","Status":"Exited (1) 5 minutes ago","HostConfig":{"NetworkMode":"bridge"},"NetworkSettings":{"Networks":{}},"Mounts":[]}
+]`
+
+// Serves handler on a Unix socket in a temporary directory and returns its DOCKER_HOST
+func serveDockerSocket(t *testing.T, handler http.Handler) string {
+	t.Helper()
+	socket := filepath.Join(t.TempDir(), "docker.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: handler}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+	return "unix://" + socket
+}
+
+func TestDockerClientContainerList(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks []string
+	}{
+		{name: "content length", chunks: []string{containerListResponse}},
+		// Without Content-Length and flushed in pieces the response is sent with Transfer-Encoding: chunked, like the engine does
+		{name: "chunked", chunks: []string{containerListResponse[:40], containerListResponse[40:300], containerListResponse[300:]}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			host := serveDockerSocket(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/containers/json" || r.URL.Query().Get("all") != "1" {
+					http.NotFound(w, r)
+					return
+				}
+				w.Header().Set("Content-Type", "application/json")
+				if len(test.chunks) == 1 {
+					w.Write([]byte(test.chunks[0]))
+					return
+				}
+				for _, chunk := range test.chunks {
+					w.Write([]byte(chunk))
+					w.(http.Flusher).Flush()
+				}
+			}))
+
+			client, err := NewDockerClient(host, time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res, err := client.client.Get(client.baseURL + "/containers/json?all=1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			if chunked := len(res.TransferEncoding) > 0 && res.TransferEncoding[0] == "chunked"; chunked != (len(test.chunks) > 1) {
+				t.Fatalf("Transfer-Encoding = %v, test needs chunked %v", res.TransferEncoding, len(test.chunks) > 1)
+			}
+
+			containers, err := client.ContainerList()
+			if err != nil {
+				t.Fatalf("ContainerList() error = %v", err)
+			}
+			if len(containers) != 2 {
+				t.Fatalf("ContainerList() returned %d containers, want 2", len(containers))
+			}
+			web := containers[0]
+			if web.ID != "8dfafdbc3a40" || containerName(web) != "web" || web.State != "running" || web.Labels["com.docker.compose.service"] != "web" {
+				t.Errorf("ContainerList()[0] = %+v", web)
+			}
+			if len(web.Ports) != 1 || web.Ports[0].PublicPort != 8080 {
+				t.Errorf("ContainerList()[0].Ports = %+v", web.Ports)
+			}
+			if containers[1].State != "exited" {
+				t.Errorf("ContainerList()[1].State = %s, want exited", containers[1].State)
+			}
+		})
+	}
+}
+
+func TestDockerClientError(t *testing.T) {
+	host := serveDockerSocket(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"message":"client version 1.43 is too new"}`))
+	}))
+	client, err := NewDockerClient(host, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.ContainerList()
+	var dockerErr DockerError
+	if !errors.As(err, &dockerErr) {
+		t.Fatalf("ContainerList() error = %v, want a DockerError", err)
+	}
+	if dockerErr.StatusCode != http.StatusInternalServerError || dockerErr.Message != "client version 1.43 is too new" {
+		t.Errorf("ContainerList() error = %+v", dockerErr)
+	}
+}
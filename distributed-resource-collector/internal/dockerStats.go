@@ -1,12 +1,11 @@
 package internal
 
 import (
-	"bufio"
 	"encoding/json"
-	"fmt"
-	"net"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/shirou/gopsutil/docker"
 )
@@ -14,25 +13,25 @@ import (
 // -- DOCKER INTERNAL STRUCTS
 type DockerPort struct {
 	IP          string `json:"IP"`
-	PrivatePort int16  `json:"PrivatePort"`
-	PublicPort  int16  `json:"PublicPort"`
+	PrivatePort uint16 `json:"PrivatePort"`
+	PublicPort  uint16 `json:"PublicPort"`
 	Type        string `json:"Type"`
 }
 
 type DockerNetworkConfig struct {
-	IPAMConfig          []string `json:"IPAMConfig"`
-	Links               []string `json:"Links"`
-	Aliases             []string `json:"Aliases"`
-	NetworkID           string   `json:"NetworkID"`
-	EndpointID          string   `json:"EndpointID"`
-	Gateway             string   `json:"Gateway"`
-	IPAddress           string   `json:"IPAddress"`
-	IPPrefixLen         int16    `json:"IPPrefixLen"`
-	IPv6Gateway         string   `json:"IPv6Gateway"`
-	GlobalIPv6Address   string   `json:"GlobalIPv6Address"`
-	GlobalIPv6PrefixLen string   `json:"GlobalIPv6PrefixLen"`
-	MacAddress          string   `json:"MacAddress"`
-	DriverOpts          []string `json:"DriverOpts"`
+	IPAMConfig          map[string]interface{} `json:"IPAMConfig"`
+	Links               []string               `json:"Links"`
+	Aliases             []string               `json:"Aliases"`
+	NetworkID           string                 `json:"NetworkID"`
+	EndpointID          string                 `json:"EndpointID"`
+	Gateway             string                 `json:"Gateway"`
+	IPAddress           string                 `json:"IPAddress"`
+	IPPrefixLen         int16                  `json:"IPPrefixLen"`
+	IPv6Gateway         string                 `json:"IPv6Gateway"`
+	GlobalIPv6Address   string                 `json:"GlobalIPv6Address"`
+	GlobalIPv6PrefixLen int16                  `json:"GlobalIPv6PrefixLen"`
+	MacAddress          string                 `json:"MacAddress"`
+	DriverOpts          map[string]string      `json:"DriverOpts"`
 }
 
 type DockerNetwork struct {
@@ -49,24 +48,24 @@ type DockerMount struct {
 }
 
 type DrcDockerSocketStats struct {
-	ID              string            `json:"ID"`
-	Names           []string          `json:"Names"`
-	Image           string            `json:"Image"`
-	ImageID         string            `json:"ImageID"`
-	Command         string            `json:"Command"`
-	Created         int64             `json:"Created"`
-	Ports           []DockerPort      `json:"Ports"`
-	Labels          map[string]string `json:"Labels"`
-	State           string            `json:"State"`
-	Status          string            `json:"Status"`
-	HostConfig      map[string]string `json:"HostConfig"`
-	NetworkSettings DockerNetwork     `json:"NetworkSettings"`
-	Mounts          []DockerMount     `json:"Mounts"`
-}
-
-func DrcSocketJsonToStruct(v string) (socketStats []DrcDockerSocketStats) {
-	json.Unmarshal([]byte(v), &socketStats)
-	return socketStats
+	ID              string                 `json:"ID"`
+	Names           []string               `json:"Names"`
+	Image           string                 `json:"Image"`
+	ImageID         string                 `json:"ImageID"`
+	Command         string                 `json:"Command"`
+	Created         int64                  `json:"Created"`
+	Ports           []DockerPort           `json:"Ports"`
+	Labels          map[string]string      `json:"Labels"`
+	State           string                 `json:"State"`
+	Status          string                 `json:"Status"`
+	HostConfig      map[string]interface{} `json:"HostConfig"`
+	NetworkSettings DockerNetwork          `json:"NetworkSettings"`
+	Mounts          []DockerMount          `json:"Mounts"`
+}
+
+func DrcSocketJsonToStruct(v string) (socketStats []DrcDockerSocketStats, err error) {
+	err = json.Unmarshal([]byte(v), &socketStats)
+	return socketStats, err
 }
 
 func (d DrcDockerSocketStats) String() string {
@@ -74,37 +73,141 @@ func (d DrcDockerSocketStats) String() string {
 	return string(s)
 }
 
-func GetDockerSocketStats() (result string) {
-	conn, _ := net.Dial("unix", "/var/run/docker.sock")
-	fmt.Fprintf(conn, "GET /containers/json HTTP/1.0\r\n\r\n")
-	status, _ := bufio.NewReader(conn).ReadString('\t')
-	//fmt.Println("Message from server: " + status)
-	splitStatus := strings.Split(status, "\n")
-	result = splitStatus[len(splitStatus)-2]
-	return result
+// -- DOCKER CONTAINER STATS (GET /containers/{id}/stats?stream=false)
+// Only the fields used to calculate the usage of the container are mapped. Memory stats keys depend on the cgroup version,
+// "total_inactive_file" is used by cgroup v1 and "inactive_file" by cgroup v2, older engines only report "cache".
+type DockerCPUStats struct {
+	CPUUsage struct {
+		TotalUsage  uint64   `json:"total_usage"`
+		PercpuUsage []uint64 `json:"percpu_usage"`
+	} `json:"cpu_usage"`
+	SystemCPUUsage uint64 `json:"system_cpu_usage"`
+	OnlineCPUs     uint32 `json:"online_cpus"`
 }
 
-// There are basically two ways to get Docker stats. If this program is running inside a container, we must connect to the Docker Unix Socket.
-// The Unix Socket has much more information than psUtil. At this point in time, I've configured so that we get the same information from both implementations.
-// In the future it might be worth it to remove the usage of psUtil's docker function, but it's not guaranteed that all palces where this application
-// is running will be using docker at all.
+type DockerMemoryStats struct {
+	Usage uint64            `json:"usage"`
+	Limit uint64            `json:"limit"`
+	Stats map[string]uint64 `json:"stats"`
+}
 
-func GetDockerStats() (dockerStats []DrcDockerStats) {
-	if InDockerContainer() {
-		tmpStats := DrcSocketJsonToStruct(GetDockerSocketStats())
-		for _, container := range tmpStats {
-			//fmt.Println(container.String())
-			tmp := DrcDockerStats{
-				ContainerID: container.ID,
-				Name:        container.Names[0],
-				Image:       container.Image,
-				Status:      container.Status,
-				State:       container.State,
+type DockerNetworkStats struct {
+	RxBytes uint64 `json:"rx_bytes"`
+	TxBytes uint64 `json:"tx_bytes"`
+}
+
+type DockerBlkioEntry struct {
+	Major uint64 `json:"major"`
+	Minor uint64 `json:"minor"`
+	Op    string `json:"op"`
+	Value uint64 `json:"value"`
+}
+
+type DockerContainerStats struct {
+	CPUStats    DockerCPUStats                `json:"cpu_stats"`
+	PreCPUStats DockerCPUStats                `json:"precpu_stats"`
+	MemoryStats DockerMemoryStats             `json:"memory_stats"`
+	Networks    map[string]DockerNetworkStats `json:"networks"`
+	BlkioStats  struct {
+		IoServiceBytesRecursive []DockerBlkioEntry `json:"io_service_bytes_recursive"`
+	} `json:"blkio_stats"`
+}
+
+// Same calculation as "docker stats": the share of the host CPU time used by the container between the two samples of the response,
+// multiplied by the number of CPUs. 100% is one full core.
+func (d DockerContainerStats) CPUPercent() float64 {
+	cpuDelta := float64(d.CPUStats.CPUUsage.TotalUsage) - float64(d.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(d.CPUStats.SystemCPUUsage) - float64(d.PreCPUStats.SystemCPUUsage)
+	onlineCPUs := float64(d.CPUStats.OnlineCPUs)
+	if onlineCPUs == 0 {
+		onlineCPUs = float64(len(d.CPUStats.CPUUsage.PercpuUsage))
+	}
+	if cpuDelta <= 0 || systemDelta <= 0 {
+		return 0
+	}
+	return cpuDelta / systemDelta * onlineCPUs * 100
+}
+
+// Same calculation as "docker stats": the page cache can be reclaimed, so it isn't counted as used memory
+func (d DockerContainerStats) MemoryUsage() uint64 {
+	var cache uint64
+	for _, key := range []string{"total_inactive_file", "inactive_file", "cache"} {
+		if value, ok := d.MemoryStats.Stats[key]; ok {
+			cache = value
+			break
+		}
+	}
+	if cache > d.MemoryStats.Usage {
+		return d.MemoryStats.Usage
+	}
+	return d.MemoryStats.Usage - cache
+}
+
+func (d DockerContainerStats) NetworkIO() (rx uint64, tx uint64) {
+	for _, network := range d.Networks {
+		rx += network.RxBytes
+		tx += network.TxBytes
+	}
+	return rx, tx
+}
+
+// Docker reports the operation as "Read"/"Write", Podman uses lowercase
+func (d DockerContainerStats) BlockIO() (read uint64, write uint64) {
+	for _, entry := range d.BlkioStats.IoServiceBytesRecursive {
+		if strings.EqualFold(entry.Op, "read") {
+			read += entry.Value
+		} else if strings.EqualFold(entry.Op, "write") {
+			write += entry.Value
+		}
+	}
+	return read, write
+}
+
+// Adds the usage of the container to the DRC struct
+func (d DockerContainerStats) apply(dockerStats *DrcDockerStats) {
+	dockerStats.CPUPercent = d.CPUPercent()
+	dockerStats.MemoryUsage = d.MemoryUsage()
+	dockerStats.MemoryLimit = d.MemoryStats.Limit
+	if dockerStats.MemoryLimit > 0 {
+		dockerStats.MemoryPercent = float64(dockerStats.MemoryUsage) / float64(dockerStats.MemoryLimit) * 100
+	}
+	dockerStats.NetworkRx, dockerStats.NetworkTx = d.NetworkIO()
+	dockerStats.BlockRead, dockerStats.BlockWrite = d.BlockIO()
+}
+
+// Requests the stats of every running container at the same time, each request takes about a second
+func addDockerContainerStats(client *DockerClient, dockerStats []DrcDockerStats) {
+	waitGroup := new(sync.WaitGroup)
+	for i := range dockerStats {
+		if !dockerStats[i].Running() {
+			continue
+		}
+		waitGroup.Add(1)
+		go func(container *DrcDockerStats) {
+			defer waitGroup.Done()
+			containerStats, err := client.ContainerStats(container.ContainerID)
+			if err != nil {
+				CheckError(err)
+				return
 			}
-			dockerStats = append(dockerStats, tmp)
+			containerStats.apply(container)
+		}(&dockerStats[i])
+	}
+	waitGroup.Wait()
+}
+
+// There are basically two ways to get Docker stats. If the Docker Engine API is reachable (DOCKER_HOST, or the Docker or Podman socket),
+// the containers are listed through the API, which has much more information than psUtil and works from inside a container.
+// Otherwise GO-PsUtil is used. At this point in time, both implementations report the same information, except for the usage of the containers
+// that is only available through the API.
+
+// With client set to nil GO-PsUtil is used. With containerStats, the usage of every running container is requested from the engine.
+func GetDockerStats(client *DockerClient, containerStats bool) (dockerStats []DrcDockerStats, err error) {
+	if client == nil {
+		tmpStats, err := docker.GetDockerStat()
+		if err != nil && err != docker.ErrDockerNotAvailable {
+			return nil, err
 		}
-	} else {
-		tmpStats, _ := docker.GetDockerStat()
 		for _, docker := range tmpStats {
 			tmp := DrcDockerStats{
 				ContainerID: docker.ContainerID,
@@ -115,7 +218,97 @@ func GetDockerStats() (dockerStats []DrcDockerStats) {
 			}
 			dockerStats = append(dockerStats, tmp)
 		}
+		return dockerStats, nil
+	}
+
+	containers, err := client.ContainerList()
+	if err != nil {
+		return nil, err
+	}
+	for _, container := range containers {
+		tmp := DrcDockerStats{
+			ContainerID: container.ID,
+			Image:       container.Image,
+			Status:      container.Status,
+			State:       container.State,
+		}
+		if len(container.Names) > 0 {
+			tmp.Name = container.Names[0]
+		}
+		dockerStats = append(dockerStats, tmp)
+	}
+	if containerStats {
+		addDockerContainerStats(client, dockerStats)
+	}
+	return dockerStats, nil
+}
+
+// -- COLLECTOR
+// Options: stats (request the usage of every running container, true by default), host (same format as DOCKER_HOST, "none" to use GO-PsUtil),
+// timeout (of every request to the engine, 10s by default)
+type dockerCollector struct {
+	mutex          sync.Mutex
+	containerStats bool
+	host           string
+	timeout        time.Duration
+	client         *DockerClient
+}
+
+func init() {
+	RegisterCollector(&dockerCollector{containerStats: true, timeout: 10 * time.Second})
+}
+
+func (c *dockerCollector) Name() string {
+	return "docker"
+}
+
+func (c *dockerCollector) Configure(options map[string]string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if value, ok := options["stats"]; ok {
+		containerStats, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		c.containerStats = containerStats
+	}
+	if value, ok := options["host"]; ok {
+		c.host = value
+	}
+	if value, ok := options["timeout"]; ok {
+		timeout, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		c.timeout = timeout
+	}
+	// The client is created again with the new options on the next Collect
+	c.client = nil
+	return nil
+}
+
+func (c *dockerCollector) Collect(stats *DrcStats) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	host := c.host
+	if host == "" {
+		host = DockerHost()
+	}
+	if host == "" || host == "none" {
+		c.client = nil
+	} else if c.client == nil || c.client.Host != host {
+		client, err := NewDockerClient(host, c.timeout)
+		if err != nil {
+			return err
+		}
+		c.client = client
+	}
 
+	dockerStats, err := GetDockerStats(c.client, c.containerStats)
+	if err != nil {
+		return err
 	}
-	return dockerStats
+	stats.DockerSats = dockerStats
+	return nil
 }
@@ -0,0 +1,234 @@
+package internal
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shirou/gopsutil/cpu"
+	"github.com/shirou/gopsutil/mem"
+)
+
+// -- EVENTS
+// EventWatcher samples the node between the scheduled heartbeats (event job, EVENT_SCHEDULE) and returns the thresholds crossed since
+// the last sample, the DRC sends them right away in an out-of-band heartbeat. The samples are light: CPU usage since the last sample,
+// memory, the disks of the disk collector and the state of the containers (one request to the engine).
+// A threshold fires once when it's crossed and again only after the value went back under it, and event heartbeats are at least
+// Cooldown apart. Crossings during the cooldown are sent after it, when they still hold.
+type EventWatcher struct {
+	CPU        float64 // average usage, percent, 0 disables it
+	Memory     float64 // used memory, percent, 0 disables it
+	Disk       float64 // used space of every disk, percent, 0 disables it
+	Containers bool    // containers leaving the running state
+	Cooldown   time.Duration
+	mu         sync.Mutex
+	fired      map[string]bool   // crossed thresholds that were already sent
+	running    map[string]string // running containers of the last sample, id to name, nil before the first sample
+	pending    []DrcEvent        // containers that stopped during the cooldown
+	lastEvent  time.Time
+	docker     *DockerClient
+}
+
+func NewEventWatcher(cpuThreshold float64, memoryThreshold float64, diskThreshold float64, containers bool, cooldown time.Duration) (*EventWatcher, error) {
+	for _, threshold := range []float64{cpuThreshold, memoryThreshold, diskThreshold} {
+		if threshold < 0 || threshold > 100 {
+			return nil, fmt.Errorf("event: thresholds are percents between 0 and 100, got %g", threshold)
+		}
+	}
+	if cooldown < 0 {
+		return nil, fmt.Errorf("event: cooldown can't be negative")
+	}
+	return &EventWatcher{
+		CPU:        cpuThreshold,
+		Memory:     memoryThreshold,
+		Disk:       diskThreshold,
+		Containers: containers,
+		Cooldown:   cooldown,
+		fired:      map[string]bool{},
+	}, nil
+}
+
+// NewEventWatcherFromEnv reads EVENT_CPU (90), EVENT_MEMORY (90), EVENT_DISK (95), EVENT_CONTAINERS (true) and EVENT_COOLDOWN (30s)
+func NewEventWatcherFromEnv() (*EventWatcher, error) {
+	return NewEventWatcherFromConfig(CurrentConfig())
+}
+
+// NewEventWatcherFromConfig is NewEventWatcherFromEnv with the values of file, a config that was read but isn't used yet
+func NewEventWatcherFromConfig(file *ConfigFile) (*EventWatcher, error) {
+	thresholds := []float64{}
+	for _, setting := range []struct{ key, fallback string }{{"EVENT_CPU", "90"}, {"EVENT_MEMORY", "90"}, {"EVENT_DISK", "95"}} {
+		threshold, err := strconv.ParseFloat(file.Get(setting.key, setting.fallback), 64)
+		if err != nil {
+			return nil, fmt.Errorf("event: %s: %v", setting.key, err)
+		}
+		thresholds = append(thresholds, threshold)
+	}
+	containers, err := strconv.ParseBool(file.Get("EVENT_CONTAINERS", "true"))
+	if err != nil {
+		return nil, fmt.Errorf("event: EVENT_CONTAINERS: %v", err)
+	}
+	cooldown, err := time.ParseDuration(file.Get("EVENT_COOLDOWN", "30s"))
+	if err != nil {
+		return nil, fmt.Errorf("event: EVENT_COOLDOWN: %v", err)
+	}
+	return NewEventWatcher(thresholds[0], thresholds[1], thresholds[2], containers, cooldown)
+}
+
+// Configure takes the thresholds and the cooldown of settings (a watcher built from a reloaded config). The state of the watcher is kept:
+// the thresholds already sent, the running containers and the time of the last event.
+func (w *EventWatcher) Configure(settings *EventWatcher) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.CPU = settings.CPU
+	w.Memory = settings.Memory
+	w.Disk = settings.Disk
+	w.Cooldown = settings.Cooldown
+	if !settings.Containers {
+		// Containers that stop while they aren't watched aren't reported when they are watched again
+		w.running = nil
+		w.pending = nil
+	}
+	w.Containers = settings.Containers
+}
+
+// Check samples the node and returns the events to send, nil when no threshold was crossed or during the cooldown
+func (w *EventWatcher) Check() []DrcEvent {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	crossed := w.sampleThresholds()
+	w.pending = append(w.pending, w.sampleContainers()...)
+
+	// Thresholds back under their value fire again on the next crossing
+	crossedKeys := map[string]bool{}
+	events := []DrcEvent{}
+	for _, event := range crossed {
+		key := event.Type + ":" + event.Subject
+		crossedKeys[key] = true
+		if !w.fired[key] {
+			events = append(events, event)
+		}
+	}
+	for key := range w.fired {
+		if !crossedKeys[key] {
+			delete(w.fired, key)
+		}
+	}
+
+	if len(events)+len(w.pending) == 0 || time.Since(w.lastEvent) < w.Cooldown {
+		return nil
+	}
+	for _, event := range events {
+		w.fired[event.Type+":"+event.Subject] = true
+	}
+	events = append(events, w.pending...)
+	w.pending = nil
+	w.lastEvent = time.Now()
+	observeEvents(events)
+	return events
+}
+
+// Returns every threshold that is crossed now
+func (w *EventWatcher) sampleThresholds() []DrcEvent {
+	crossed := []DrcEvent{}
+	if w.CPU > 0 {
+		// Usage since the last call with no interval, the sample of this job
+		if percent, err := cpu.Percent(0, false); err == nil && len(percent) > 0 && percent[0] >= w.CPU {
+			crossed = append(crossed, DrcEvent{Type: "cpu", Value: percent[0], Threshold: w.CPU})
+		}
+	}
+	if w.Memory > 0 {
+		if memory, err := mem.VirtualMemory(); err == nil && memory.UsedPercent >= w.Memory {
+			crossed = append(crossed, DrcEvent{Type: "memory", Value: memory.UsedPercent, Threshold: w.Memory})
+		}
+	}
+	if w.Disk > 0 {
+		stats := DrcStats{}
+		if err := CollectOnce("disk", &stats); err != nil {
+			CheckError(fmt.Errorf("event: %v", err))
+		}
+		for _, disk := range stats.DiskStats {
+			if disk.UsedPercent >= w.Disk {
+				crossed = append(crossed, DrcEvent{Type: "disk", Subject: disk.Path, Value: disk.UsedPercent, Threshold: w.Disk})
+			}
+		}
+	}
+	return crossed
+}
+
+// Returns the containers that were running on the last sample and aren't anymore. The engine is found like the docker collector
+// does by default (DOCKER_HOST, or the Docker and Podman sockets), without an engine there are no container events.
+func (w *EventWatcher) sampleContainers() []DrcEvent {
+	if !w.Containers {
+		return nil
+	}
+	host := DockerHost()
+	if host == "" {
+		return nil
+	}
+	if w.docker == nil || w.docker.Host != host {
+		client, err := NewDockerClient(host, 5*time.Second)
+		if err != nil {
+			CheckError(fmt.Errorf("event: %v", err))
+			return nil
+		}
+		w.docker = client
+	}
+	containers, err := w.docker.ContainerList()
+	if err != nil {
+		// A failed sample doesn't count as every container stopping
+		CheckError(fmt.Errorf("event: %v", err))
+		return nil
+	}
+
+	states := map[string]string{}
+	running := map[string]string{}
+	for _, container := range containers {
+		states[container.ID] = container.State
+		if container.State == "running" {
+			running[container.ID] = containerName(container)
+		}
+	}
+	events := []DrcEvent{}
+	if w.running != nil {
+		for id, name := range w.running {
+			if _, ok := running[id]; ok {
+				continue
+			}
+			state, ok := states[id]
+			if !ok {
+				state = "removed"
+			}
+			events = append(events, DrcEvent{Type: "container", Subject: name, State: state})
+		}
+	}
+	w.running = running
+	sort.Slice(events, func(i, j int) bool { return events[i].Subject < events[j].Subject })
+	return events
+}
+
+func containerName(container DrcDockerSocketStats) string {
+	if len(container.Names) > 0 {
+		return strings.TrimPrefix(container.Names[0], "/")
+	}
+	return container.ID
+}
+
+// EventSummary describes the events in one line: "cpu 97.2% >= 90%, container web exited"
+func EventSummary(events []DrcEvent) string {
+	summary := []string{}
+	for _, event := range events {
+		switch {
+		case event.Type == "container":
+			summary = append(summary, fmt.Sprintf("container %s %s", event.Subject, event.State))
+		case event.Subject != "":
+			summary = append(summary, fmt.Sprintf("%s %s %.1f%% >= %g%%", event.Type, event.Subject, event.Value, event.Threshold))
+		default:
+			summary = append(summary, fmt.Sprintf("%s %.1f%% >= %g%%", event.Type, event.Value, event.Threshold))
+		}
+	}
+	return strings.Join(summary, ", ")
+}
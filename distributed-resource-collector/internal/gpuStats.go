@@ -0,0 +1,201 @@
+package internal
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Query used with nvidia-smi, the order of the fields is the order expected by ParseNvidiaSmi
+const nvidiaSmiQuery = "index,name,utilization.gpu,memory.used,memory.total,temperature.gpu"
+
+// ParseNvidiaSmi reads the output of "nvidia-smi --query-gpu=index,name,utilization.gpu,memory.used,memory.total,temperature.gpu --format=csv,noheader,nounits".
+// Memory is reported by nvidia-smi in MiB and converted to bytes. Fields that the device doesn't support ("[N/A]", "[Not Supported]") are left at 0.
+func ParseNvidiaSmi(output string) ([]DrcGPUStats, error) {
+	reader := csv.NewReader(strings.NewReader(output))
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("nvidia-smi: %v", err)
+	}
+
+	var gpuStats []DrcGPUStats
+	for _, record := range records {
+		if len(record) != 6 {
+			return nil, fmt.Errorf("nvidia-smi: expected 6 fields, got %d in %q", len(record), strings.Join(record, ","))
+		}
+		index, err := strconv.Atoi(record[0])
+		if err != nil {
+			return nil, fmt.Errorf("nvidia-smi: invalid index %q", record[0])
+		}
+		gpuStats = append(gpuStats, DrcGPUStats{
+			Index:       index,
+			Name:        record[1],
+			Vendor:      "nvidia",
+			Utilization: parseGPUFloat(record[2]),
+			MemoryUsed:  uint64(parseGPUFloat(record[3]) * 1024 * 1024),
+			MemoryTotal: uint64(parseGPUFloat(record[4]) * 1024 * 1024),
+			Temperature: parseGPUFloat(record[5]),
+		})
+	}
+	return gpuStats, nil
+}
+
+func parseGPUFloat(value string) float64 {
+	parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		return 0
+	}
+	return parsed
+}
+
+var (
+	tegraRAM  = regexp.MustCompile(`\bRAM (\d+)/(\d+)MB`)
+	tegraGR3D = regexp.MustCompile(`\bGR3D_FREQ (\d+)%`)
+	tegraTemp = regexp.MustCompile(`(?i)\bGPU@(-?[\d.]+)C`)
+)
+
+// ParseTegrastats reads a single line of tegrastats output (Jetson boards). The integrated GPU shares the RAM with the CPU,
+// so the memory reported is the system memory. The load of the GPU is the GR3D_FREQ percentage.
+func ParseTegrastats(line string) (DrcGPUStats, error) {
+	load := tegraGR3D.FindStringSubmatch(line)
+	if load == nil {
+		return DrcGPUStats{}, fmt.Errorf("tegrastats: GR3D_FREQ not found in %q", line)
+	}
+
+	gpuStats := DrcGPUStats{
+		Index:       0,
+		Name:        "tegra",
+		Vendor:      "nvidia",
+		Utilization: parseGPUFloat(load[1]),
+	}
+	if ram := tegraRAM.FindStringSubmatch(line); ram != nil {
+		gpuStats.MemoryUsed = uint64(parseGPUFloat(ram[1]) * 1024 * 1024)
+		gpuStats.MemoryTotal = uint64(parseGPUFloat(ram[2]) * 1024 * 1024)
+	}
+	if temp := tegraTemp.FindStringSubmatch(line); temp != nil {
+		gpuStats.Temperature = parseGPUFloat(temp[1])
+	}
+	return gpuStats, nil
+}
+
+// Runs nvidia-smi once and parses its output
+func readNvidiaSmi(ctx context.Context, path string) ([]DrcGPUStats, error) {
+	output, err := exec.CommandContext(ctx, path, "--query-gpu="+nvidiaSmiQuery, "--format=csv,noheader,nounits").Output()
+	if err != nil {
+		return nil, fmt.Errorf("nvidia-smi: %v", err)
+	}
+	return ParseNvidiaSmi(string(output))
+}
+
+// tegrastats never exits on its own, the first line is read and the process is stopped
+func readTegrastats(ctx context.Context, path string) ([]DrcGPUStats, error) {
+	cmd := exec.CommandContext(ctx, path, "--interval", "100")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("tegrastats: %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("tegrastats: %v", err)
+	}
+	line, readErr := bufio.NewReader(stdout).ReadString('\n')
+	cmd.Process.Kill()
+	cmd.Wait()
+	if readErr != nil && line == "" {
+		return nil, fmt.Errorf("tegrastats: %v", readErr)
+	}
+
+	gpuStats, err := ParseTegrastats(line)
+	if err != nil {
+		return nil, err
+	}
+	// The board model is a better name than "tegra", e.g. "NVIDIA Jetson Nano Developer Kit"
+	if model, err := ioutil.ReadFile("/proc/device-tree/model"); err == nil {
+		gpuStats.Name = strings.TrimRight(string(model), "\x00\n")
+	}
+	return []DrcGPUStats{gpuStats}, nil
+}
+
+// -- COLLECTOR
+// Options: source (auto, nvidia-smi, tegrastats or none, auto uses the first tool found in PATH),
+// nvidia_smi and tegrastats (path of the tools), timeout (5s by default)
+type gpuCollector struct {
+	mutex      sync.Mutex
+	source     string
+	nvidiaSmi  string
+	tegrastats string
+	timeout    time.Duration
+}
+
+func init() {
+	RegisterCollector(&gpuCollector{source: "auto", nvidiaSmi: "nvidia-smi", tegrastats: "tegrastats", timeout: 5 * time.Second})
+}
+
+func (c *gpuCollector) Name() string {
+	return "gpu"
+}
+
+func (c *gpuCollector) Configure(options map[string]string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if value, ok := options["source"]; ok {
+		if !StringInSlice(value, []string{"auto", "nvidia-smi", "tegrastats", "none"}) {
+			return fmt.Errorf("unknown source %s, expected auto, nvidia-smi, tegrastats or none", value)
+		}
+		c.source = value
+	}
+	if value, ok := options["nvidia_smi"]; ok {
+		c.nvidiaSmi = value
+	}
+	if value, ok := options["tegrastats"]; ok {
+		c.tegrastats = value
+	}
+	if value, ok := options["timeout"]; ok {
+		timeout, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		c.timeout = timeout
+	}
+	return nil
+}
+
+func (c *gpuCollector) Collect(stats *DrcStats) error {
+	c.mutex.Lock()
+	source, nvidiaSmi, tegrastats, timeout := c.source, c.nvidiaSmi, c.tegrastats, c.timeout
+	c.mutex.Unlock()
+
+	if source == "auto" {
+		source = "none"
+		if _, err := exec.LookPath(nvidiaSmi); err == nil {
+			source = "nvidia-smi"
+		} else if _, err := exec.LookPath(tegrastats); err == nil {
+			source = "tegrastats"
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var gpuStats []DrcGPUStats
+	var err error
+	switch source {
+	case "nvidia-smi":
+		gpuStats, err = readNvidiaSmi(ctx, nvidiaSmi)
+	case "tegrastats":
+		gpuStats, err = readTegrastats(ctx, tegrastats)
+	}
+	if err != nil {
+		return err
+	}
+	stats.GPUStats = gpuStats
+	return nil
+}
@@ -0,0 +1,99 @@
+package internal
+
+import (
+	"reflect"
+	"testing"
+)
+
+const mib = 1024 * 1024
+
+func TestParseNvidiaSmi(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  string
+		want    []DrcGPUStats
+		wantErr bool
+	}{
+		{
+			name:   "two gpus",
+			output: "0, NVIDIA GeForce RTX 3090, 35, 1523, 24576, 54\n1, NVIDIA GeForce RTX 3090, 0, 1, 24576, 32\n",
+			want: []DrcGPUStats{
+				{Index: 0, Name: "NVIDIA GeForce RTX 3090", Vendor: "nvidia", Utilization: 35, MemoryUsed: 1523 * mib, MemoryTotal: 24576 * mib, Temperature: 54},
+				{Index: 1, Name: "NVIDIA GeForce RTX 3090", Vendor: "nvidia", Utilization: 0, MemoryUsed: 1 * mib, MemoryTotal: 24576 * mib, Temperature: 32},
+			},
+		},
+		{
+			name:   "unsupported fields",
+			output: "0, Tesla K80, [N/A], [Not Supported], [Not Supported], 41\n",
+			want: []DrcGPUStats{
+				{Index: 0, Name: "Tesla K80", Vendor: "nvidia", Temperature: 41},
+			},
+		},
+		{
+			name:   "no gpus",
+			output: "",
+		},
+		{
+			name:    "missing fields",
+			output:  "0, NVIDIA GeForce RTX 3090, 35\n",
+			wantErr: true,
+		},
+		{
+			name:    "invalid index",
+			output:  "GPU-5f3c, NVIDIA GeForce RTX 3090, 35, 1523, 24576, 54\n",
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ParseNvidiaSmi(test.output)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("ParseNvidiaSmi() error = %v, wantErr %v", err, test.wantErr)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("ParseNvidiaSmi() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseTegrastats(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		want    DrcGPUStats
+		wantErr bool
+	}{
+		{
+			name: "jetson nano",
+			line: "RAM 1346/3964MB (lfb 125x4MB) SWAP 0/1982MB (cached 0MB) IRAM 0/252kB(lfb 252kB) CPU [6%@102,4%@102,1%@102,0%@102] EMC_FREQ 0%@1600 GR3D_FREQ 0%@76 APE 25 PLL@31C CPU@33.5C PMIC@100C GPU@32C AO@39.5C thermal@33C POM_5V_IN 1949/1949 POM_5V_GPU 0/0 POM_5V_CPU 161/161",
+			want: DrcGPUStats{Name: "tegra", Vendor: "nvidia", Utilization: 0, MemoryUsed: 1346 * mib, MemoryTotal: 3964 * mib, Temperature: 32},
+		},
+		{
+			name: "jetson xavier nx",
+			line: "RAM 2448/7764MB (lfb 880x4MB) SWAP 0/3882MB (cached 0MB) CPU [10%@1190,6%@1190,2%@1190,1%@1190,off,off] EMC_FREQ 0% GR3D_FREQ 12% AO@37C GPU@36.5C PMIC@100C AUX@36.5C CPU@38C thermal@37C VDD_IN 4584/4584 VDD_CPU_GPU_CV 537/537 VDD_SOC 1418/1418",
+			want: DrcGPUStats{Name: "tegra", Vendor: "nvidia", Utilization: 12, MemoryUsed: 2448 * mib, MemoryTotal: 7764 * mib, Temperature: 36.5},
+		},
+		{
+			name: "jetson agx orin",
+			line: "03-14-2023 10:21:07 RAM 2373/30536MB (lfb 6591x4MB) SWAP 0/15268MB (cached 0MB) CPU [1%@729,0%@729,0%@729,0%@729,0%@729,0%@729,0%@729,0%@729] EMC_FREQ 0%@2133 GR3D_FREQ 45%@[305,305] VIC_FREQ 729 APE 174 cpu@41.468C soc2@38.062C soc0@38.968C gpu@38.593C tj@41.468C soc1@38.843C VDD_GPU_SOC 2781mW/2781mW VDD_CPU_CV 397mW/397mW VIN_SYS_5V0 3826mW/3826mW",
+			want: DrcGPUStats{Name: "tegra", Vendor: "nvidia", Utilization: 45, MemoryUsed: 2373 * mib, MemoryTotal: 30536 * mib, Temperature: 38.593},
+		},
+		{
+			name:    "no gpu load",
+			line:    "RAM 1346/3964MB (lfb 125x4MB) SWAP 0/1982MB (cached 0MB) CPU [6%@102,4%@102,1%@102,0%@102]",
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ParseTegrastats(test.line)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("ParseTegrastats() error = %v, wantErr %v", err, test.wantErr)
+			}
+			if got != test.want {
+				t.Errorf("ParseTegrastats() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,385 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Resolutions of the rollups kept by the history
+var historySteps = map[string]time.Duration{"1m": time.Minute, "5m": 5 * time.Minute}
+
+// -- HISTORY
+// History keeps the last heartbeats and probe rounds of the DRC in memory, with 1 minute and 5 minute rollups that cover a longer time.
+// Every buffer drops its oldest entry when it's full. With a directory the history is saved there and loaded on start,
+// so it survives restarts; it's saved at most once per SaveInterval.
+type History struct {
+	Size         int // raw heartbeats and probe rounds kept
+	RollupSize   int // rollups kept for every step
+	SaveInterval time.Duration
+	dir          string
+	mu           sync.RWMutex
+	lastSave     time.Time
+	state        historyState
+}
+
+// Everything that is saved to disk
+type historyState struct {
+	Stats   []DrcStats               `json:"stats"`
+	Latency []LatencyResults         `json:"latency"`
+	Rollups map[string][]Rollup      `json:"rollups"`
+	Open    map[string]*rollupBucket `json:"open"` // rollups that are still receiving samples
+}
+
+func NewHistory(size int, rollupSize int, dir string) (*History, error) {
+	if size < 1 || rollupSize < 1 {
+		return nil, fmt.Errorf("history: the sizes must be positive numbers")
+	}
+	history := &History{
+		Size:         size,
+		RollupSize:   rollupSize,
+		SaveInterval: time.Minute,
+		dir:          dir,
+		state:        historyState{Rollups: map[string][]Rollup{}, Open: map[string]*rollupBucket{}},
+	}
+	if dir == "" {
+		return history, nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("history: %v", err)
+	}
+	content, err := ioutil.ReadFile(history.file())
+	if os.IsNotExist(err) {
+		return history, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("history: %v", err)
+	}
+	// A damaged file only costs the old history
+	if err := json.Unmarshal(content, &history.state); err != nil {
+		fmt.Println("history: ignoring", history.file(), err)
+		history.state = historyState{}
+	}
+	if history.state.Rollups == nil {
+		history.state.Rollups = map[string][]Rollup{}
+	}
+	if history.state.Open == nil {
+		history.state.Open = map[string]*rollupBucket{}
+	}
+	history.trim()
+	return history, nil
+}
+
+// NewHistoryFromEnv creates the history from HISTORY_SIZE (240 heartbeats and probe rounds), HISTORY_ROLLUP_SIZE (2016 rollups of every step,
+// one week of 5m rollups) and HISTORY_DIR (empty by default, the history is only kept in memory)
+func NewHistoryFromEnv() (*History, error) {
+	size, rollupSize, err := historySizesFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return NewHistory(size, rollupSize, GetEnv("HISTORY_DIR", ""))
+}
+
+// CheckHistoryFromEnv validates the settings of NewHistoryFromEnv without creating HISTORY_DIR
+func CheckHistoryFromEnv() error {
+	size, rollupSize, err := historySizesFromEnv()
+	if err != nil {
+		return err
+	}
+	if _, err := NewHistory(size, rollupSize, ""); err != nil {
+		return err
+	}
+	dir := GetEnv("HISTORY_DIR", "")
+	if dir == "" {
+		return nil
+	}
+	if info, err := os.Stat(dir); err == nil && !info.IsDir() {
+		return fmt.Errorf("history: %s is not a directory", dir)
+	} else if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("history: %v", err)
+	}
+	return nil
+}
+
+func historySizesFromEnv() (int, int, error) {
+	size, err := strconv.Atoi(GetEnv("HISTORY_SIZE", "240"))
+	if err != nil {
+		return 0, 0, fmt.Errorf("history: HISTORY_SIZE: %v", err)
+	}
+	rollupSize, err := strconv.Atoi(GetEnv("HISTORY_ROLLUP_SIZE", "2016"))
+	if err != nil {
+		return 0, 0, fmt.Errorf("history: HISTORY_ROLLUP_SIZE: %v", err)
+	}
+	return size, rollupSize, nil
+}
+
+func (h *History) file() string {
+	return filepath.Join(h.dir, "history.json")
+}
+
+// AddStats records a heartbeat
+func (h *History) AddStats(stats DrcStats) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	stats.Queue = nil
+	h.state.Stats = append(h.state.Stats, stats)
+	for step := range historySteps {
+		h.bucket(step, stats.Timestamp.TimeLocal).addStats(stats)
+	}
+	h.trim()
+	h.save()
+}
+
+// AddLatency records a probe round
+func (h *History) AddLatency(results LatencyResults) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.state.Latency = append(h.state.Latency, results)
+	for step := range historySteps {
+		h.bucket(step, results.Timestamp.TimeLocal).addLatency(results)
+	}
+	h.trim()
+	h.save()
+}
+
+// Returns the open rollup of the step that holds t, the previous one is closed when t is past its end.
+// Late samples (a probe round that started before the last heartbeat) go to the open rollup.
+func (h *History) bucket(step string, t time.Time) *rollupBucket {
+	start := t.Truncate(historySteps[step])
+	open := h.state.Open[step]
+	if open != nil && !start.After(open.Start) {
+		return open
+	}
+	if open != nil {
+		h.state.Rollups[step] = append(h.state.Rollups[step], open.rollup())
+	}
+	open = newRollupBucket(start, start.Add(historySteps[step]))
+	h.state.Open[step] = open
+	return open
+}
+
+// Drops the oldest entries of the full buffers
+func (h *History) trim() {
+	if extra := len(h.state.Stats) - h.Size; extra > 0 {
+		h.state.Stats = append([]DrcStats{}, h.state.Stats[extra:]...)
+	}
+	if extra := len(h.state.Latency) - h.Size; extra > 0 {
+		h.state.Latency = append([]LatencyResults{}, h.state.Latency[extra:]...)
+	}
+	for step, rollups := range h.state.Rollups {
+		if extra := len(rollups) - h.RollupSize; extra > 0 {
+			h.state.Rollups[step] = append([]Rollup{}, rollups[extra:]...)
+		}
+	}
+}
+
+// Writes the history to its directory, at most once per SaveInterval. Errors are reported, the history in memory is still valid.
+func (h *History) save() {
+	if h.dir == "" || time.Since(h.lastSave) < h.SaveInterval {
+		return
+	}
+	h.lastSave = time.Now()
+	content, err := json.Marshal(h.state)
+	if err == nil {
+		tmp := h.file() + ".tmp"
+		if err = ioutil.WriteFile(tmp, content, 0644); err == nil {
+			err = os.Rename(tmp, h.file())
+		}
+	}
+	if err != nil {
+		fmt.Println("history:", err)
+	}
+}
+
+// Query returns the history between from and to. Step is raw (the heartbeats and probe rounds), 1m or 5m (the rollups that overlap the range,
+// the last one can still be open).
+func (h *History) Query(from time.Time, to time.Time, step string) (HistoryResponse, error) {
+	response := HistoryResponse{From: from, To: to, Step: step}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if step == "raw" {
+		for _, stats := range h.state.Stats {
+			if inRange(stats.Timestamp.TimeLocal, from, to) {
+				response.Stats = append(response.Stats, stats)
+			}
+		}
+		for _, results := range h.state.Latency {
+			if inRange(results.Timestamp.TimeLocal, from, to) {
+				response.Latency = append(response.Latency, results)
+			}
+		}
+		return response, nil
+	}
+
+	if _, ok := historySteps[step]; !ok {
+		return response, fmt.Errorf("history: unknown step %s, expected raw, 1m or 5m", step)
+	}
+	rollups := h.state.Rollups[step]
+	if open := h.state.Open[step]; open != nil {
+		rollups = append(append([]Rollup{}, rollups...), open.rollup())
+	}
+	for _, rollup := range rollups {
+		if rollup.End.After(from) && !rollup.Start.After(to) {
+			response.Rollups = append(response.Rollups, rollup)
+		}
+	}
+	return response, nil
+}
+
+func inRange(t time.Time, from time.Time, to time.Time) bool {
+	return !t.Before(from) && !t.After(to)
+}
+
+// Adds the history to the gin context, used by the history endpoint
+func HistoryMiddleware(history *History) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("HISTORY", history)
+	}
+}
+
+// -- ROLLUPS
+// Running sum, minimum and maximum of a value
+type rollupAccumulator struct {
+	Count int     `json:"count"`
+	Sum   float64 `json:"sum"`
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+}
+
+func (a *rollupAccumulator) add(value float64) {
+	if a.Count == 0 {
+		a.Min, a.Max = value, value
+	}
+	a.Count++
+	a.Sum += value
+	a.Min = math.Min(a.Min, value)
+	a.Max = math.Max(a.Max, value)
+}
+
+func (a *rollupAccumulator) value() RollupValue {
+	if a == nil || a.Count == 0 {
+		return RollupValue{}
+	}
+	return RollupValue{Average: a.Sum / float64(a.Count), Min: a.Min, Max: a.Max}
+}
+
+type latencyAccumulator struct {
+	Rounds  int               `json:"rounds"`
+	Latency rollupAccumulator `json:"latency"`
+	Jitter  rollupAccumulator `json:"jitter"`
+	Loss    rollupAccumulator `json:"loss"`
+}
+
+// Rollup that is still receiving samples
+type rollupBucket struct {
+	Start          time.Time                      `json:"start"`
+	End            time.Time                      `json:"end"`
+	Samples        int                            `json:"samples"`
+	CPUUsage       rollupAccumulator              `json:"cpuUsage"`
+	MemoryUsed     rollupAccumulator              `json:"memoryUsed"`
+	Temperature    rollupAccumulator              `json:"temperature"`
+	RunningDockers rollupAccumulator              `json:"runningDockers"`
+	DiskUsed       map[string]*rollupAccumulator  `json:"diskUsed"`
+	NetworkRx      map[string]*rollupAccumulator  `json:"networkRx"`
+	NetworkTx      map[string]*rollupAccumulator  `json:"networkTx"`
+	Latency        map[string]*latencyAccumulator `json:"latency"`
+}
+
+func newRollupBucket(start time.Time, end time.Time) *rollupBucket {
+	return &rollupBucket{
+		Start:     start,
+		End:       end,
+		DiskUsed:  map[string]*rollupAccumulator{},
+		NetworkRx: map[string]*rollupAccumulator{},
+		NetworkTx: map[string]*rollupAccumulator{},
+		Latency:   map[string]*latencyAccumulator{},
+	}
+}
+
+// Returns the accumulator of key, created on first use
+func accumulator(accumulators map[string]*rollupAccumulator, key string) *rollupAccumulator {
+	if accumulators[key] == nil {
+		accumulators[key] = &rollupAccumulator{}
+	}
+	return accumulators[key]
+}
+
+func (b *rollupBucket) addStats(stats DrcStats) {
+	b.Samples++
+	b.CPUUsage.add(stats.CPUStats.AverageUsage)
+	b.MemoryUsed.add(stats.MemStats.Used)
+	b.Temperature.add(stats.ThermalStats.MaxTemperature)
+	running := 0
+	for _, docker := range stats.DockerSats {
+		if docker.Running() {
+			running++
+		}
+	}
+	b.RunningDockers.add(float64(running))
+	for _, disk := range stats.DiskStats {
+		accumulator(b.DiskUsed, disk.Path).add(disk.UsedPercent)
+	}
+	for _, net := range stats.NetStats {
+		accumulator(b.NetworkRx, net.Interface).add(net.BytesRecvRate)
+		accumulator(b.NetworkTx, net.Interface).add(net.BytesSentRate)
+	}
+}
+
+func (b *rollupBucket) addLatency(results LatencyResults) {
+	for _, result := range results.Results {
+		target := b.Latency[result.Hostname]
+		if target == nil {
+			target = &latencyAccumulator{}
+			b.Latency[result.Hostname] = target
+		}
+		target.Rounds++
+		target.Loss.add(result.Loss)
+		if result.Latency >= 0 {
+			target.Latency.add(result.Average)
+			target.Jitter.add(result.StdDev)
+		}
+	}
+}
+
+func (b *rollupBucket) rollup() Rollup {
+	rollup := Rollup{
+		Start:          b.Start,
+		End:            b.End,
+		Samples:        b.Samples,
+		CPUUsage:       b.CPUUsage.value(),
+		MemoryUsed:     b.MemoryUsed.value(),
+		Temperature:    b.Temperature.value(),
+		RunningDockers: b.RunningDockers.value(),
+		DiskUsed:       map[string]RollupValue{},
+		NetworkRx:      map[string]RollupValue{},
+		NetworkTx:      map[string]RollupValue{},
+		Latency:        map[string]LatencyRollup{},
+	}
+	for path, disk := range b.DiskUsed {
+		rollup.DiskUsed[path] = disk.value()
+	}
+	for name, rx := range b.NetworkRx {
+		rollup.NetworkRx[name] = rx.value()
+	}
+	for name, tx := range b.NetworkTx {
+		rollup.NetworkTx[name] = tx.value()
+	}
+	for target, latency := range b.Latency {
+		rollup.Latency[target] = LatencyRollup{
+			Rounds:  latency.Rounds,
+			Latency: latency.Latency.value(),
+			Jitter:  latency.Jitter.value(),
+			Loss:    latency.Loss.value(),
+		}
+	}
+	return rollup
+}
@@ -0,0 +1,58 @@
+package internal
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// -- History Rollups
+// Average, minimum and maximum of a value over the samples of a rollup
+type RollupValue struct {
+	Average float64 `json:"average"`
+	Min     float64 `json:"min"`
+	Max     float64 `json:"max"`
+}
+
+// Latency to a target over the probe rounds of a rollup, in ms. Unreachable rounds only count in the loss.
+type LatencyRollup struct {
+	Rounds  int         `json:"rounds"`
+	Latency RollupValue `json:"latency"`
+	Jitter  RollupValue `json:"jitter"`
+	Loss    RollupValue `json:"loss"`
+}
+
+// Rollup summarizes the heartbeats and probe rounds between Start and End. Disks are indexed by path, network interfaces by name
+// (rates in bytes per second) and latency by target hostname.
+type Rollup struct {
+	Start          time.Time                `json:"start"`
+	End            time.Time                `json:"end"`
+	Samples        int                      `json:"samples"`
+	CPUUsage       RollupValue              `json:"cpuUsage"`
+	MemoryUsed     RollupValue              `json:"memoryUsed"`
+	Temperature    RollupValue              `json:"temperature"`
+	RunningDockers RollupValue              `json:"runningDockers"`
+	DiskUsed       map[string]RollupValue   `json:"diskUsed"`
+	NetworkRx      map[string]RollupValue   `json:"networkRx"`
+	NetworkTx      map[string]RollupValue   `json:"networkTx"`
+	Latency        map[string]LatencyRollup `json:"latency"`
+}
+
+func (r Rollup) String() string {
+	s, _ := json.Marshal(r)
+	return string(s)
+}
+
+// Answer of the history endpoint. With the raw step Stats and Latency hold the samples, otherwise Rollups holds the 1m or 5m rollups.
+type HistoryResponse struct {
+	From    time.Time        `json:"from"`
+	To      time.Time        `json:"to"`
+	Step    string           `json:"step"`
+	Stats   []DrcStats       `json:"stats,omitempty"`
+	Latency []LatencyResults `json:"latency,omitempty"`
+	Rollups []Rollup         `json:"rollups,omitempty"`
+}
+
+func (r HistoryResponse) String() string {
+	s, _ := json.Marshal(r)
+	return string(s)
+}
@@ -16,3 +16,23 @@ func GetHostStats() (drcHost DrcHost) {
 		HostID:               tmpHost.HostID,
 	}
 }
+
+// -- COLLECTOR
+type hostCollector struct{}
+
+func init() {
+	RegisterCollector(&hostCollector{})
+}
+
+func (c *hostCollector) Name() string {
+	return "host"
+}
+
+func (c *hostCollector) Configure(options map[string]string) error {
+	return nil
+}
+
+func (c *hostCollector) Collect(stats *DrcStats) error {
+	stats.DrcHost = GetHostStats()
+	return nil
+}
@@ -0,0 +1,161 @@
+package internal
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/shirou/gopsutil/host"
+)
+
+// -- LABELS
+// Capabilities of the node, registered as labels of the inventory asset (properties.labels) so workloads can be matched to the nodes
+// (arch=arm64, cuda=true). Values are strings, the boolean labels are "true" or "false". Labels of REGISTER_LABELS are added to the
+// discovered ones and override them.
+
+// Device nodes of the accelerators. USB Coral sticks don't have a device node, they're found by their USB ids.
+var (
+	edgeTPUDevices = []string{"/dev/apex_*"}
+	edgeTPUUSBIds  = []string{"1a6e:089a", "18d1:9302"}
+	npuDevices     = []string{"/dev/rknpu", "/dev/accel/accel*", "/dev/hailo*", "/dev/galcore", "/dev/vipcore"}
+)
+
+// Container runtimes, found by their socket or their binary in PATH
+var containerRuntimes = []struct {
+	name    string
+	sockets []string
+	binary  string
+}{
+	{"docker", []string{"/var/run/docker.sock"}, "dockerd"},
+	{"containerd", []string{"/run/containerd/containerd.sock"}, "containerd"},
+	{"podman", []string{"/run/podman/podman.sock"}, "podman"},
+	{"nvidia", nil, "nvidia-container-runtime"},
+}
+
+var labelKey = regexp.MustCompile(`^[a-z0-9]([a-z0-9._/-]*[a-z0-9])?$`)
+
+// ParseLabels reads labels written as "key=value,key=value". Keys are lowercase letters, digits and . _ / -
+func ParseLabels(list string) (map[string]string, error) {
+	labels := map[string]string{}
+	for _, item := range SplitList(list) {
+		pair := strings.SplitN(item, "=", 2)
+		if len(pair) != 2 || strings.TrimSpace(pair[1]) == "" {
+			return nil, fmt.Errorf("labels: %s has no value, expected key=value", item)
+		}
+		key := strings.TrimSpace(pair[0])
+		if !labelKey.MatchString(key) {
+			return nil, fmt.Errorf("labels: invalid key %s", key)
+		}
+		labels[key] = strings.TrimSpace(pair[1])
+	}
+	return labels, nil
+}
+
+// DiscoverLabels returns the capabilities of the node: architecture and OS, cores and memory (GB), the GPUs found by the gpu collector,
+// video devices, EdgeTPU and NPU device nodes and the container runtimes
+func DiscoverLabels(gpus []DrcGPUStats, cores int, memory uint64) map[string]string {
+	labels := map[string]string{
+		"arch":      kernelArch(),
+		"os":        runtime.GOOS,
+		"cpu-cores": strconv.Itoa(cores),
+		"memory-gb": strconv.Itoa(int(math.Round(float64(memory) / (1 << 30)))),
+		"gpu":       strconv.FormatBool(len(gpus) > 0),
+	}
+	// Every key is always sent, the inventory keeps the labels of earlier registrations that aren't sent again
+	labels["gpu-vendor"], labels["gpu-model"] = "none", "none"
+	labels["gpu-count"] = strconv.Itoa(len(gpus))
+	if len(gpus) > 0 {
+		labels["gpu-vendor"] = gpus[0].Vendor
+		labels["gpu-model"] = gpus[0].Name
+	}
+	// Every GPU read by nvidia-smi or tegrastats (Jetson) runs CUDA
+	labels["cuda"] = strconv.FormatBool(len(gpus) > 0 && gpus[0].Vendor == "nvidia")
+
+	video := globDevices([]string{"/dev/video*"})
+	labels["video"] = strconv.FormatBool(len(video) > 0)
+	labels["video-devices"] = strconv.Itoa(len(video))
+	labels["edgetpu"] = strconv.FormatBool(len(globDevices(edgeTPUDevices)) > 0 || hasUSBDevice(edgeTPUUSBIds))
+	npu := globDevices(npuDevices)
+	labels["npu"] = strconv.FormatBool(len(npu) > 0)
+	labels["npu-device"] = "none"
+	if len(npu) > 0 {
+		labels["npu-device"] = filepath.Base(npu[0])
+	}
+
+	for _, containerRuntime := range containerRuntimes {
+		labels["runtime-"+containerRuntime.name] = strconv.FormatBool(hasContainerRuntime(containerRuntime.sockets, containerRuntime.binary))
+	}
+	return labels
+}
+
+// Architectures of uname, named like GOARCH
+var kernelArchs = map[string]string{
+	"x86_64":  "amd64",
+	"aarch64": "arm64",
+	"armv8l":  "arm",
+	"armv7l":  "arm",
+	"armv6l":  "arm",
+	"i386":    "386",
+	"i686":    "386",
+}
+
+// Architecture of the kernel, a 32 bit DRC on a 64 bit kernel (armhf builds on a Raspberry Pi OS 64 bit) reports the kernel architecture.
+// GOARCH is used when the kernel architecture can't be read.
+func kernelArch() string {
+	arch, err := host.KernelArch()
+	if err != nil || arch == "" {
+		return runtime.GOARCH
+	}
+	if goarch, ok := kernelArchs[arch]; ok {
+		return goarch
+	}
+	return arch
+}
+
+// Returns the paths that match the patterns, sorted
+func globDevices(patterns []string) []string {
+	devices := []string{}
+	for _, pattern := range patterns {
+		matches, _ := filepath.Glob(pattern)
+		devices = append(devices, matches...)
+	}
+	sort.Strings(devices)
+	return devices
+}
+
+// Checks the vendor:product ids of the USB devices in sysfs
+func hasUSBDevice(ids []string) bool {
+	devices, _ := filepath.Glob("/sys/bus/usb/devices/*")
+	for _, device := range devices {
+		vendor, err := ioutil.ReadFile(filepath.Join(device, "idVendor"))
+		if err != nil {
+			continue
+		}
+		product, err := ioutil.ReadFile(filepath.Join(device, "idProduct"))
+		if err != nil {
+			continue
+		}
+		if StringInSlice(strings.TrimSpace(string(vendor))+":"+strings.TrimSpace(string(product)), ids) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasContainerRuntime(sockets []string, binary string) bool {
+	for _, socket := range sockets {
+		if info, err := os.Stat(socket); err == nil && info.Mode()&os.ModeSocket != 0 {
+			return true
+		}
+	}
+	_, err := exec.LookPath(binary)
+	return err == nil
+}
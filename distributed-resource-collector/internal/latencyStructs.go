@@ -2,6 +2,8 @@ package internal
 
 import (
 	"encoding/json"
+	"math"
+	"sort"
 	"time"
 
 	"github.com/wI2L/jettison"
@@ -15,10 +17,15 @@ type LatencyTargets struct {
 }
 
 type LatencyTarget struct {
-	Hostname     string `json:"hostname"`
-	Hostport     string `json:"hostPort"`
-	HostUser     string `json:"hostUser"`
-	HostPassword string `json:"hostPassword"`
+	Hostname      string `json:"hostname"`
+	Hostport      string `json:"hostPort"`
+	HostUser      string `json:"hostUser"`
+	HostPassword  string `json:"hostPassword"`
+	HostKey       string `json:"hostKey"`       // SHA256 fingerprint or public key of the SSH server, verified by the probes
+	Method        string `json:"method"`        // tcp, udp, http or ssh, empty for the default method of the DRC
+	ProbePort     string `json:"probePort"`     // port of the tcp, udp and http probes, hostPort is used when empty
+	Responder     bool   `json:"responder"`     // the target runs the DRC responder, tcp probes measure the echo of a token instead of the connection
+	BandwidthPort string `json:"bandwidthPort"` // port of the DRC bandwidth server of the target, empty when it can't be tested
 }
 
 func LatencyTargetsJsonToStruct(v string) (targets LatencyTargets, err error) {
@@ -55,9 +62,19 @@ func (r LatencyResults) String() string {
 	return string(s)
 }
 
+// Every probe round sends Samples probes to the target. Latency is the average (ms) of the samples that got an answer, -1 when every sample was lost.
+// The rest of the statistics are in milliseconds with sub-millisecond precision, StdDev is the jitter of the round and Loss the ratio of lost samples (0-1).
 type LatencyResult struct {
-	Hostname string `json:"hostname"`
-	Latency  int64  `json:"latency"`
+	Hostname string  `json:"hostname"`
+	Latency  int64   `json:"latency"`
+	Method   string  `json:"method"` // probe method that measured the latency
+	Samples  int     `json:"samples"`
+	Average  float64 `json:"average"`
+	Min      float64 `json:"min"`
+	Max      float64 `json:"max"`
+	Median   float64 `json:"median"`
+	StdDev   float64 `json:"stdDev"`
+	Loss     float64 `json:"loss"`
 }
 
 func (r LatencyResult) String() string {
@@ -65,6 +82,41 @@ func (r LatencyResult) String() string {
 	return string(s)
 }
 
+// SummarizeSamples builds the result of a probe round, samples are the round trip times of the probes that got an answer out of sent probes
+func SummarizeSamples(hostname string, method string, samples []time.Duration, sent int) LatencyResult {
+	result := LatencyResult{Hostname: hostname, Method: method, Samples: sent, Latency: -1}
+	if sent > 0 {
+		result.Loss = float64(sent-len(samples)) / float64(sent)
+	}
+	if len(samples) == 0 {
+		return result
+	}
+
+	values := make([]float64, len(samples))
+	total := float64(0)
+	for i, sample := range samples {
+		values[i] = float64(sample.Microseconds()) / 1000
+		total += values[i]
+	}
+	sort.Float64s(values)
+
+	result.Average = total / float64(len(values))
+	result.Min = values[0]
+	result.Max = values[len(values)-1]
+	if middle := len(values) / 2; len(values)%2 == 0 {
+		result.Median = (values[middle-1] + values[middle]) / 2
+	} else {
+		result.Median = values[middle]
+	}
+	variance := float64(0)
+	for _, value := range values {
+		variance += (value - result.Average) * (value - result.Average)
+	}
+	result.StdDev = math.Sqrt(variance / float64(len(values)))
+	result.Latency = int64(math.Round(result.Average))
+	return result
+}
+
 func LatencyJsonToStruct(v string) (targets LatencyTargets, err error) {
 	err = json.Unmarshal([]byte(v), &targets)
 	return targets, err
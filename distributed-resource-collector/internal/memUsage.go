@@ -12,3 +12,23 @@ func GetMemoryUsage() (MemStats DrcMemStats) {
 		Used:      tmpMem.UsedPercent,
 	}
 }
+
+// -- COLLECTOR
+type memoryCollector struct{}
+
+func init() {
+	RegisterCollector(&memoryCollector{})
+}
+
+func (c *memoryCollector) Name() string {
+	return "memory"
+}
+
+func (c *memoryCollector) Configure(options map[string]string) error {
+	return nil
+}
+
+func (c *memoryCollector) Collect(stats *DrcStats) error {
+	stats.MemStats = GetMemoryUsage()
+	return nil
+}
@@ -0,0 +1,447 @@
+package internal
+
+import (
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// -- METRICS
+// Prometheus metrics of the DRC, served by the /metrics endpoint. The server stats are the sample of the last heartbeat, the latency
+// and bandwidth metrics are the results of the last round and the drc_* counters and histograms describe the DRC itself.
+var MetricsRegistry = prometheus.NewRegistry()
+
+// MetricsHandler serves MetricsRegistry in the Prometheus text format
+var MetricsHandler = promhttp.HandlerFor(MetricsRegistry, promhttp.HandlerOpts{})
+
+var (
+	gatewayPosts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "drc_gateway_posts_total",
+		Help: "Posts to the gateway by endpoint (URL path) and result (success or failure)",
+	}, []string{"endpoint", "result"})
+	queuedPosts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "drc_queued_posts_total",
+		Help: "Posts stored in the forward queue to be replayed later, by endpoint",
+	}, []string{"endpoint"})
+	sinkSends = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "drc_sink_sends_total",
+		Help: "Payloads sent to every sink by job and result (success or failure), queued gateway posts count as a success",
+	}, []string{"sink", "job", "result"})
+	probeDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "drc_probe_duration_seconds",
+		Help:    "Duration of every latency probe sample, failed samples included, by method and result",
+		Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+	}, []string{"method", "result"})
+	events = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "drc_events_total",
+		Help: "Thresholds crossed between the scheduled heartbeats and sent in an event heartbeat, by type (cpu, memory, disk or container)",
+	}, []string{"type"})
+	jobRuns = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "drc_job_runs_total",
+		Help: "Runs of the scheduled jobs",
+	}, []string{"job"})
+	jobDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "drc_job_duration_seconds",
+		Help:    "Duration of the runs of the scheduled jobs",
+		Buckets: []float64{.1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120},
+	}, []string{"job"})
+
+	latencyGauges  = map[string]*prometheus.GaugeVec{}
+	bandwidthGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "drc_bandwidth_mbps",
+		Help: "Bandwidth to the target in the last bandwidth round, Mbit/s (-1 when the test failed)",
+	}, []string{"target", "direction"})
+	roundMutex sync.Mutex
+
+	// Last sample of the heartbeat jobs, see ObserveStats
+	lastStats      *DrcStats
+	lastStatsMutex sync.Mutex
+)
+
+func init() {
+	latencyLabels := []string{"target", "method"}
+	for name, help := range map[string]string{
+		"drc_latency_ms":         "Latency to the target in the last probe round, rounded average in ms (-1 when unreachable)",
+		"drc_latency_average_ms": "Average of the samples of the last probe round, ms",
+		"drc_latency_min_ms":     "Fastest sample of the last probe round, ms",
+		"drc_latency_max_ms":     "Slowest sample of the last probe round, ms",
+		"drc_latency_median_ms":  "Median of the samples of the last probe round, ms",
+		"drc_latency_jitter_ms":  "Standard deviation of the samples of the last probe round, ms",
+		"drc_latency_loss_ratio": "Share of the samples of the last probe round that failed",
+		"drc_latency_samples":    "Samples sent in the last probe round",
+	} {
+		latencyGauges[name] = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: name, Help: help}, latencyLabels)
+		MetricsRegistry.MustRegister(latencyGauges[name])
+	}
+	MetricsRegistry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		gatewayPosts, queuedPosts, sinkSends, events, probeDuration, jobRuns, jobDuration, bandwidthGauge,
+		&statsCollector{},
+	)
+}
+
+// Path of the gateway URL, used as the endpoint label
+func metricsEndpoint(rawUrl string) string {
+	if parsed, err := url.Parse(rawUrl); err == nil && parsed.Path != "" {
+		return parsed.Path
+	}
+	return rawUrl
+}
+
+func observePost(rawUrl string, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	gatewayPosts.WithLabelValues(metricsEndpoint(rawUrl), result).Inc()
+}
+
+func observeQueued(rawUrl string) {
+	queuedPosts.WithLabelValues(metricsEndpoint(rawUrl)).Inc()
+}
+
+func observeSink(sink string, job string, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	sinkSends.WithLabelValues(sink, job, result).Inc()
+}
+
+func observeEvents(sent []DrcEvent) {
+	for _, event := range sent {
+		events.WithLabelValues(event.Type).Inc()
+	}
+}
+
+func observeProbe(method string, elapsed time.Duration, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	probeDuration.WithLabelValues(method, result).Observe(elapsed.Seconds())
+}
+
+func observeJob(name string, elapsed time.Duration) {
+	jobRuns.WithLabelValues(name).Inc()
+	jobDuration.WithLabelValues(name).Observe(elapsed.Seconds())
+}
+
+// ObserveLatencyRound replaces the latency metrics with the results of a probe round, targets that weren't probed disappear
+func ObserveLatencyRound(results LatencyResults) {
+	roundMutex.Lock()
+	defer roundMutex.Unlock()
+	for _, gauge := range latencyGauges {
+		gauge.Reset()
+	}
+	for _, r := range results.Results {
+		values := map[string]float64{
+			"drc_latency_ms":         float64(r.Latency),
+			"drc_latency_average_ms": r.Average,
+			"drc_latency_min_ms":     r.Min,
+			"drc_latency_max_ms":     r.Max,
+			"drc_latency_median_ms":  r.Median,
+			"drc_latency_jitter_ms":  r.StdDev,
+			"drc_latency_loss_ratio": r.Loss,
+			"drc_latency_samples":    float64(r.Samples),
+		}
+		for name, value := range values {
+			latencyGauges[name].WithLabelValues(r.Hostname, r.Method).Set(value)
+		}
+	}
+}
+
+// ObserveBandwidthRound replaces the bandwidth metrics with the results of a bandwidth round
+func ObserveBandwidthRound(results BandwidthResults) {
+	roundMutex.Lock()
+	defer roundMutex.Unlock()
+	bandwidthGauge.Reset()
+	for _, r := range results.Results {
+		bandwidthGauge.WithLabelValues(r.Hostname, r.Direction).Set(r.Bandwidth)
+	}
+}
+
+// RegisterQueueMetrics exports the depth and the oldest entry of the forward queue
+func RegisterQueueMetrics(queue *ForwardQueue) {
+	MetricsRegistry.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "drc_queue_depth",
+			Help: "Posts waiting in the forward queue",
+		}, func() float64 { return float64(queue.Status().Depth) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "drc_queue_oldest_age_seconds",
+			Help: "Age of the oldest sample in the forward queue",
+		}, func() float64 { return queue.Status().OldestAge }),
+	)
+}
+
+// -- SERVER STATS
+// statsCollector exports every section of DrcStats from the sample of the last heartbeat. Collecting on every scrape would take the
+// deltas of the collectors (CPU usage, throttling, network rates) from the heartbeats. Without heartbeats every scrape collects.
+type statsCollector struct{}
+
+// ObserveStats keeps the sample of a heartbeat for the stats metrics
+func ObserveStats(stats DrcStats) {
+	lastStatsMutex.Lock()
+	defer lastStatsMutex.Unlock()
+	lastStats = &stats
+}
+
+func metricsStats() DrcStats {
+	lastStatsMutex.Lock()
+	defer lastStatsMutex.Unlock()
+	if lastStats != nil {
+		return *lastStats
+	}
+	return GetServerStats()
+}
+
+type statsMetric struct {
+	desc      *prometheus.Desc
+	valueType prometheus.ValueType
+}
+
+func newStatsMetric(name string, help string, valueType prometheus.ValueType, labels ...string) statsMetric {
+	return statsMetric{desc: prometheus.NewDesc(name, help, labels, nil), valueType: valueType}
+}
+
+var statsMetrics = map[string]statsMetric{
+	"host_info":           newStatsMetric("drc_host_info", "Host of the DRC, always 1", prometheus.GaugeValue, "hostname", "hostid", "platform", "virtualization_system", "virtualization_role"),
+	"host_uptime":         newStatsMetric("drc_host_uptime_seconds", "Uptime of the host", prometheus.GaugeValue),
+	"host_boot_time":      newStatsMetric("drc_host_boot_time_seconds", "Boot time of the host, unix time", prometheus.GaugeValue),
+	"cpu_info":            newStatsMetric("drc_cpu_info", "CPU model, always 1", prometheus.GaugeValue, "model", "vendor"),
+	"cpu_usage":           newStatsMetric("drc_cpu_usage_percent", "Usage of every core", prometheus.GaugeValue, "core"),
+	"cpu_average":         newStatsMetric("drc_cpu_average_usage_percent", "Average usage of the cores", prometheus.GaugeValue),
+	"mem_total":           newStatsMetric("drc_memory_total_bytes", "Total memory", prometheus.GaugeValue),
+	"mem_available":       newStatsMetric("drc_memory_available_bytes", "Available memory", prometheus.GaugeValue),
+	"mem_used":            newStatsMetric("drc_memory_used_percent", "Used memory", prometheus.GaugeValue),
+	"disk_total":          newStatsMetric("drc_disk_total_bytes", "Size of the mount", prometheus.GaugeValue, "device", "path", "label", "fstype"),
+	"disk_used":           newStatsMetric("drc_disk_used_bytes", "Used space of the mount", prometheus.GaugeValue, "device", "path", "label", "fstype"),
+	"disk_used_percent":   newStatsMetric("drc_disk_used_percent", "Used space of the mount", prometheus.GaugeValue, "device", "path", "label", "fstype"),
+	"procs_total":         newStatsMetric("drc_procs_total", "Processes of the host", prometheus.GaugeValue),
+	"procs_created":       newStatsMetric("drc_procs_created_total", "Processes created since boot", prometheus.CounterValue),
+	"procs_running":       newStatsMetric("drc_procs_running", "Running processes", prometheus.GaugeValue),
+	"procs_blocked":       newStatsMetric("drc_procs_blocked", "Processes blocked on IO", prometheus.GaugeValue),
+	"container_info":      newStatsMetric("drc_container_info", "Container state, always 1", prometheus.GaugeValue, "id", "name", "image", "state", "status"),
+	"container_running":   newStatsMetric("drc_container_running", "1 when the container is running", prometheus.GaugeValue, "id", "name"),
+	"container_cpu":       newStatsMetric("drc_container_cpu_percent", "CPU usage of the container, 100 is one core", prometheus.GaugeValue, "id", "name"),
+	"container_mem":       newStatsMetric("drc_container_memory_usage_bytes", "Memory used by the container, without the page cache", prometheus.GaugeValue, "id", "name"),
+	"container_mem_limit": newStatsMetric("drc_container_memory_limit_bytes", "Memory limit of the container", prometheus.GaugeValue, "id", "name"),
+	"container_mem_pct":   newStatsMetric("drc_container_memory_percent", "Memory used by the container, percentage of the limit", prometheus.GaugeValue, "id", "name"),
+	"container_rx":        newStatsMetric("drc_container_network_receive_bytes_total", "Bytes received by the container", prometheus.CounterValue, "id", "name"),
+	"container_tx":        newStatsMetric("drc_container_network_transmit_bytes_total", "Bytes sent by the container", prometheus.CounterValue, "id", "name"),
+	"container_read":      newStatsMetric("drc_container_block_read_bytes_total", "Bytes read by the container", prometheus.CounterValue, "id", "name"),
+	"container_write":     newStatsMetric("drc_container_block_write_bytes_total", "Bytes written by the container", prometheus.CounterValue, "id", "name"),
+	"net_speed":           newStatsMetric("drc_network_speed_mbps", "Link speed of the interface, 0 if unknown", prometheus.GaugeValue, "interface"),
+	"net_sent":            newStatsMetric("drc_network_transmit_bytes_total", "Bytes sent by the interface", prometheus.CounterValue, "interface"),
+	"net_recv":            newStatsMetric("drc_network_receive_bytes_total", "Bytes received by the interface", prometheus.CounterValue, "interface"),
+	"net_packets_sent":    newStatsMetric("drc_network_transmit_packets_total", "Packets sent by the interface", prometheus.CounterValue, "interface"),
+	"net_packets_recv":    newStatsMetric("drc_network_receive_packets_total", "Packets received by the interface", prometheus.CounterValue, "interface"),
+	"net_errors_in":       newStatsMetric("drc_network_receive_errors_total", "Receive errors of the interface", prometheus.CounterValue, "interface"),
+	"net_errors_out":      newStatsMetric("drc_network_transmit_errors_total", "Transmit errors of the interface", prometheus.CounterValue, "interface"),
+	"net_drops_in":        newStatsMetric("drc_network_receive_drops_total", "Dropped incoming packets of the interface", prometheus.CounterValue, "interface"),
+	"net_drops_out":       newStatsMetric("drc_network_transmit_drops_total", "Dropped outgoing packets of the interface", prometheus.CounterValue, "interface"),
+	"net_sent_rate":       newStatsMetric("drc_network_transmit_bytes_per_second", "Bytes sent per second since the previous sample", prometheus.GaugeValue, "interface"),
+	"net_recv_rate":       newStatsMetric("drc_network_receive_bytes_per_second", "Bytes received per second since the previous sample", prometheus.GaugeValue, "interface"),
+	"net_psent_rate":      newStatsMetric("drc_network_transmit_packets_per_second", "Packets sent per second since the previous sample", prometheus.GaugeValue, "interface"),
+	"net_precv_rate":      newStatsMetric("drc_network_receive_packets_per_second", "Packets received per second since the previous sample", prometheus.GaugeValue, "interface"),
+	"net_error_rate":      newStatsMetric("drc_network_errors_per_second", "Errors per second since the previous sample", prometheus.GaugeValue, "interface"),
+	"net_drop_rate":       newStatsMetric("drc_network_drops_per_second", "Drops per second since the previous sample", prometheus.GaugeValue, "interface"),
+	"net_utilization":     newStatsMetric("drc_network_utilization_percent", "Busiest direction as a percentage of the link speed", prometheus.GaugeValue, "interface"),
+	"gpu_utilization":     newStatsMetric("drc_gpu_utilization_percent", "Utilization of the GPU", prometheus.GaugeValue, "index", "name", "vendor"),
+	"gpu_mem_used":        newStatsMetric("drc_gpu_memory_used_bytes", "Memory used on the GPU", prometheus.GaugeValue, "index", "name", "vendor"),
+	"gpu_mem_total":       newStatsMetric("drc_gpu_memory_total_bytes", "Memory of the GPU", prometheus.GaugeValue, "index", "name", "vendor"),
+	"gpu_temperature":     newStatsMetric("drc_gpu_temperature_celsius", "Temperature of the GPU", prometheus.GaugeValue, "index", "name", "vendor"),
+	"thermal_sensor":      newStatsMetric("drc_thermal_temperature_celsius", "Temperature of the sensor", prometheus.GaugeValue, "sensor"),
+	"thermal_critical":    newStatsMetric("drc_thermal_critical_celsius", "Critical temperature of the sensor", prometheus.GaugeValue, "sensor"),
+	"thermal_max":         newStatsMetric("drc_thermal_max_temperature_celsius", "Hottest sensor", prometheus.GaugeValue),
+	"thermal_frequency":   newStatsMetric("drc_cpu_frequency_mhz", "Current frequency of every core", prometheus.GaugeValue, "core"),
+	"thermal_max_freq":    newStatsMetric("drc_cpu_max_frequency_mhz", "Maximum frequency of the cores", prometheus.GaugeValue),
+	"thermal_throttled":   newStatsMetric("drc_thermal_throttled", "1 when the host is throttled", prometheus.GaugeValue),
+	"thermal_flag":        newStatsMetric("drc_thermal_throttled_flag", "Active throttling flags, always 1", prometheus.GaugeValue, "flag"),
+	"sample_time":         newStatsMetric("drc_stats_timestamp_seconds", "Time of the heartbeat sample of the server stats, unix time", prometheus.GaugeValue),
+	"extra":               newStatsMetric("drc_extra", "Numeric results of the collectors without a dedicated section", prometheus.GaugeValue, "collector", "field"),
+}
+
+func (c *statsCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, metric := range statsMetrics {
+		ch <- metric.desc
+	}
+}
+
+func (c *statsCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := metricsStats()
+	// Sections of disabled collectors are left out instead of reporting zeros
+	enabledCollectors := EnabledCollectors()
+	enabled := func(name string) bool {
+		return StringInSlice(name, enabledCollectors)
+	}
+	emit := func(key string, value float64, labels ...string) {
+		metric := statsMetrics[key]
+		ch <- prometheus.MustNewConstMetric(metric.desc, metric.valueType, value, labels...)
+	}
+
+	emit("sample_time", float64(stats.Timestamp.TimeNano)/1e9)
+
+	if enabled("host") {
+		host := stats.DrcHost
+		emit("host_info", 1, host.Hostname, host.HostID, host.Platform, host.VirtualizationSystem, host.VirtualizationRole)
+		emit("host_uptime", float64(host.Uptime))
+		emit("host_boot_time", float64(host.BootTime))
+	}
+
+	if enabled("cpu") {
+		emit("cpu_info", 1, stats.CPUStats.ModelName, stats.CPUStats.VendorID)
+		emit("cpu_average", stats.CPUStats.AverageUsage)
+		for core, usage := range stats.CPUStats.CoreUsage {
+			emit("cpu_usage", usage, strconv.Itoa(core))
+		}
+	}
+
+	if enabled("memory") {
+		emit("mem_total", float64(stats.MemStats.Total))
+		emit("mem_available", float64(stats.MemStats.Available))
+		emit("mem_used", stats.MemStats.Used)
+	}
+
+	if enabled("disk") {
+		for _, disk := range stats.DiskStats {
+			labels := []string{disk.Device, disk.Path, disk.Label, disk.Fstype}
+			emit("disk_total", float64(disk.Total), labels...)
+			emit("disk_used", float64(disk.Used), labels...)
+			emit("disk_used_percent", disk.UsedPercent, labels...)
+		}
+	}
+
+	if enabled("proc") {
+		emit("procs_total", float64(stats.ProcStats.TotalProcs))
+		emit("procs_created", float64(stats.ProcStats.CreatedProcs))
+		emit("procs_running", float64(stats.ProcStats.RunningProcs))
+		emit("procs_blocked", float64(stats.ProcStats.BlockedProcs))
+	}
+
+	if enabled("docker") {
+		for _, container := range stats.DockerSats {
+			id := []string{container.ContainerID, container.Name}
+			running := 0.0
+			if container.Running() {
+				running = 1
+			}
+			emit("container_info", 1, container.ContainerID, container.Name, container.Image, container.State, container.Status)
+			emit("container_running", running, id...)
+			emit("container_cpu", container.CPUPercent, id...)
+			emit("container_mem", float64(container.MemoryUsage), id...)
+			emit("container_mem_limit", float64(container.MemoryLimit), id...)
+			emit("container_mem_pct", container.MemoryPercent, id...)
+			emit("container_rx", float64(container.NetworkRx), id...)
+			emit("container_tx", float64(container.NetworkTx), id...)
+			emit("container_read", float64(container.BlockRead), id...)
+			emit("container_write", float64(container.BlockWrite), id...)
+		}
+	}
+
+	if enabled("network") {
+		for _, net := range stats.NetStats {
+			emit("net_speed", float64(net.Speed), net.Interface)
+			emit("net_sent", float64(net.BytesSent), net.Interface)
+			emit("net_recv", float64(net.BytesRecv), net.Interface)
+			emit("net_packets_sent", float64(net.PacketsSent), net.Interface)
+			emit("net_packets_recv", float64(net.PacketsRecv), net.Interface)
+			emit("net_errors_in", float64(net.ErrorsIn), net.Interface)
+			emit("net_errors_out", float64(net.ErrorsOut), net.Interface)
+			emit("net_drops_in", float64(net.DropsIn), net.Interface)
+			emit("net_drops_out", float64(net.DropsOut), net.Interface)
+			emit("net_sent_rate", net.BytesSentRate, net.Interface)
+			emit("net_recv_rate", net.BytesRecvRate, net.Interface)
+			emit("net_psent_rate", net.PacketsSentRate, net.Interface)
+			emit("net_precv_rate", net.PacketsRecvRate, net.Interface)
+			emit("net_error_rate", net.ErrorRate, net.Interface)
+			emit("net_drop_rate", net.DropRate, net.Interface)
+			emit("net_utilization", net.Utilization, net.Interface)
+		}
+	}
+
+	if enabled("gpu") {
+		for _, gpu := range stats.GPUStats {
+			labels := []string{strconv.Itoa(gpu.Index), gpu.Name, gpu.Vendor}
+			emit("gpu_utilization", gpu.Utilization, labels...)
+			emit("gpu_mem_used", float64(gpu.MemoryUsed), labels...)
+			emit("gpu_mem_total", float64(gpu.MemoryTotal), labels...)
+			emit("gpu_temperature", gpu.Temperature, labels...)
+		}
+	}
+
+	if enabled("thermal") {
+		thermal := stats.ThermalStats
+		for _, sensor := range thermal.Sensors {
+			emit("thermal_sensor", sensor.Temperature, sensor.Name)
+			if sensor.Critical > 0 {
+				emit("thermal_critical", sensor.Critical, sensor.Name)
+			}
+		}
+		emit("thermal_max", thermal.MaxTemperature)
+		for core, frequency := range thermal.CoreFrequency {
+			emit("thermal_frequency", frequency, strconv.Itoa(core))
+		}
+		emit("thermal_max_freq", thermal.MaxFrequency)
+		throttled := 0.0
+		if thermal.Throttled {
+			throttled = 1
+		}
+		emit("thermal_throttled", throttled)
+		for _, flag := range thermal.ThrottledFlags {
+			emit("thermal_flag", 1, flag)
+		}
+	}
+
+	collectors := make([]string, 0, len(stats.Extra))
+	for collector := range stats.Extra {
+		collectors = append(collectors, collector)
+	}
+	sort.Strings(collectors)
+	for _, collector := range collectors {
+		for field, value := range numericFields("", stats.Extra[collector]) {
+			emit("extra", value, collector, field)
+		}
+	}
+}
+
+// Returns the numbers found in value (maps and numbers, the keys of nested maps are joined with "."), an empty field is value itself
+func numericFields(prefix string, value interface{}) map[string]float64 {
+	fields := map[string]float64{}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			field := key
+			if prefix != "" {
+				field = prefix + "." + key
+			}
+			for subField, number := range numericFields(field, item) {
+				fields[subField] = number
+			}
+		}
+	case float64:
+		fields[prefix] = v
+	case float32:
+		fields[prefix] = float64(v)
+	case int:
+		fields[prefix] = float64(v)
+	case int64:
+		fields[prefix] = float64(v)
+	case uint64:
+		fields[prefix] = float64(v)
+	case bool:
+		if v {
+			fields[prefix] = 1
+		} else {
+			fields[prefix] = 0
+		}
+	}
+	return fields
+}
@@ -0,0 +1,166 @@
+package internal
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shirou/gopsutil/net"
+)
+
+// GetNetStats uses GO-PsUtil to read the counters of every network interface. Counters are cumulative since boot,
+// the rates are calculated against the previous sample, so the first sample of an interface has all its rates set to 0.
+func GetNetStats(previous map[string]net.IOCountersStat, elapsed time.Duration, include []string, exclude []string) ([]DrcNetStats, map[string]net.IOCountersStat, error) {
+	counters, err := net.IOCounters(true)
+	if err != nil {
+		return nil, previous, err
+	}
+
+	var netStats []DrcNetStats
+	current := map[string]net.IOCountersStat{}
+	for _, counter := range counters {
+		if !interfaceSelected(counter.Name, include, exclude) {
+			continue
+		}
+		current[counter.Name] = counter
+
+		tmpStats := DrcNetStats{
+			Interface:   counter.Name,
+			Speed:       interfaceSpeed(counter.Name),
+			BytesSent:   counter.BytesSent,
+			BytesRecv:   counter.BytesRecv,
+			PacketsSent: counter.PacketsSent,
+			PacketsRecv: counter.PacketsRecv,
+			ErrorsIn:    counter.Errin,
+			ErrorsOut:   counter.Errout,
+			DropsIn:     counter.Dropin,
+			DropsOut:    counter.Dropout,
+		}
+		if last, ok := previous[counter.Name]; ok && elapsed > 0 {
+			seconds := elapsed.Seconds()
+			tmpStats.BytesSentRate = counterRate(last.BytesSent, counter.BytesSent, seconds)
+			tmpStats.BytesRecvRate = counterRate(last.BytesRecv, counter.BytesRecv, seconds)
+			tmpStats.PacketsSentRate = counterRate(last.PacketsSent, counter.PacketsSent, seconds)
+			tmpStats.PacketsRecvRate = counterRate(last.PacketsRecv, counter.PacketsRecv, seconds)
+			tmpStats.ErrorRate = counterRate(last.Errin+last.Errout, counter.Errin+counter.Errout, seconds)
+			tmpStats.DropRate = counterRate(last.Dropin+last.Dropout, counter.Dropin+counter.Dropout, seconds)
+			if tmpStats.Speed > 0 {
+				// Speed is in Mbit/s, rates are in bytes/s. A link is as saturated as its busiest direction.
+				busiest := tmpStats.BytesSentRate
+				if tmpStats.BytesRecvRate > busiest {
+					busiest = tmpStats.BytesRecvRate
+				}
+				tmpStats.Utilization = busiest * 8 / (float64(tmpStats.Speed) * 1000000) * 100
+			}
+		}
+		netStats = append(netStats, tmpStats)
+	}
+	return netStats, current, nil
+}
+
+// Counters can go backwards when an interface is recreated or the counter wraps, in that case the rate is unknown
+func counterRate(last uint64, current uint64, seconds float64) float64 {
+	if current < last {
+		return 0
+	}
+	return float64(current-last) / seconds
+}
+
+// Interfaces are selected by name prefix. An empty include list selects every interface that isn't excluded.
+func interfaceSelected(name string, include []string, exclude []string) bool {
+	for _, prefix := range exclude {
+		if strings.HasPrefix(name, prefix) {
+			return false
+		}
+	}
+	if len(include) == 0 {
+		return true
+	}
+	for _, prefix := range include {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Link speed in Mbit/s as reported by the kernel. Virtual and wireless interfaces usually don't report it, 0 means unknown.
+func interfaceSpeed(name string) uint64 {
+	content, err := ioutil.ReadFile("/sys/class/net/" + name + "/speed")
+	if err != nil {
+		return 0
+	}
+	speed, err := strconv.ParseInt(strings.TrimSpace(string(content)), 10, 64)
+	if err != nil || speed < 0 {
+		return 0
+	}
+	return uint64(speed)
+}
+
+// -- COLLECTOR
+// Options: include and exclude (comma separated interface name prefixes, exclude defaults to "lo"),
+// interval (when there is no previous sample, wait this long and sample again so the first heartbeat has rates, 200ms by default)
+type netCollector struct {
+	mutex      sync.Mutex
+	include    []string
+	exclude    []string
+	interval   time.Duration
+	previous   map[string]net.IOCountersStat
+	previousAt time.Time
+}
+
+func init() {
+	RegisterCollector(&netCollector{exclude: []string{"lo"}, interval: time.Second / 5})
+}
+
+func (c *netCollector) Name() string {
+	return "network"
+}
+
+func (c *netCollector) Configure(options map[string]string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if value, ok := options["include"]; ok {
+		c.include = SplitList(value)
+	}
+	if value, ok := options["exclude"]; ok {
+		c.exclude = SplitList(value)
+	}
+	if value, ok := options["interval"]; ok {
+		interval, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		if interval < 0 {
+			return fmt.Errorf("interval can't be negative, got %s", value)
+		}
+		c.interval = interval
+	}
+	return nil
+}
+
+func (c *netCollector) Collect(stats *DrcStats) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.previous == nil && c.interval > 0 {
+		_, first, err := GetNetStats(nil, 0, c.include, c.exclude)
+		if err != nil {
+			return err
+		}
+		c.previous, c.previousAt = first, time.Now()
+		time.Sleep(c.interval)
+	}
+
+	now := time.Now()
+	netStats, current, err := GetNetStats(c.previous, now.Sub(c.previousAt), c.include, c.exclude)
+	if err != nil {
+		return err
+	}
+	c.previous, c.previousAt = current, now
+	stats.NetStats = netStats
+	return nil
+}
@@ -0,0 +1,438 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/ssh"
+)
+
+// Prober measures the latency to a target with a single method (tcp, udp, http, ssh). The method of every target comes from
+// the inventory (Properties.ProbeMethod), targets without one are probed with the default method.
+type Prober interface {
+	// Method is the name used in LatencyTarget.Method and in the results
+	Method() string
+	// Probe returns the time it took to reach the target, the error explains why the target couldn't be reached.
+	// The probe must give up when ctx is done, its deadline is the timeout of the probe.
+	Probe(ctx context.Context, target LatencyTarget) (time.Duration, error)
+}
+
+// Probers selects the prober of every target. Every probe round sends Samples probes to the target, Interval apart, each one limited by Timeout.
+// Workers is the number of targets probed at the same time and RoundTimeout limits the whole round, targets that weren't probed in time are left out.
+type Probers struct {
+	Default      string
+	Samples      int
+	Interval     time.Duration
+	Timeout      time.Duration
+	Workers      int
+	RoundTimeout time.Duration
+	probers      map[string]Prober
+}
+
+// NewProbers creates the set of probers, defaultMethod is used for targets without a method and must be one of them.
+// A round takes a single sample of every target, 5 seconds at most, with 8 workers and no round limit.
+func NewProbers(defaultMethod string, probers ...Prober) (*Probers, error) {
+	p := &Probers{Default: defaultMethod, Samples: 1, Timeout: 5 * time.Second, Workers: 8, probers: map[string]Prober{}}
+	for _, prober := range probers {
+		p.probers[prober.Method()] = prober
+	}
+	if _, ok := p.probers[defaultMethod]; !ok {
+		return nil, fmt.Errorf("probe: unknown method %s, available methods are %s", defaultMethod, strings.Join(p.Methods(), ", "))
+	}
+	return p, nil
+}
+
+// NewProbersFromEnv creates the tcp, udp, http and ssh probers. LATENCY_METHOD is the default method (ssh), LATENCY_TIMEOUT
+// limits every probe (5s), LATENCY_SAMPLES is the number of probes of every round (5) and LATENCY_SAMPLE_INTERVAL the time between them (100ms).
+// LATENCY_WORKERS targets are probed at the same time (8) and LATENCY_ROUND_TIMEOUT limits the whole round (60s).
+func NewProbersFromEnv(sshOptions *SSHOptions) (*Probers, error) {
+	timeout, err := time.ParseDuration(GetEnv("LATENCY_TIMEOUT", "5s"))
+	if err != nil {
+		return nil, fmt.Errorf("probe: LATENCY_TIMEOUT: %v", err)
+	}
+	samples, err := strconv.Atoi(GetEnv("LATENCY_SAMPLES", "5"))
+	if err != nil || samples < 1 {
+		return nil, fmt.Errorf("probe: LATENCY_SAMPLES must be a positive number")
+	}
+	interval, err := time.ParseDuration(GetEnv("LATENCY_SAMPLE_INTERVAL", "100ms"))
+	if err != nil {
+		return nil, fmt.Errorf("probe: LATENCY_SAMPLE_INTERVAL: %v", err)
+	}
+	workers, err := strconv.Atoi(GetEnv("LATENCY_WORKERS", "8"))
+	if err != nil || workers < 1 {
+		return nil, fmt.Errorf("probe: LATENCY_WORKERS must be a positive number")
+	}
+	roundTimeout, err := time.ParseDuration(GetEnv("LATENCY_ROUND_TIMEOUT", "60s"))
+	if err != nil {
+		return nil, fmt.Errorf("probe: LATENCY_ROUND_TIMEOUT: %v", err)
+	}
+	probers, err := NewProbers(GetEnv("LATENCY_METHOD", "ssh"),
+		&TCPProber{},
+		&UDPProber{},
+		NewHTTPProber(),
+		&SSHProber{Options: sshOptions},
+	)
+	if err != nil {
+		return nil, err
+	}
+	probers.Samples = samples
+	probers.Interval = interval
+	probers.Timeout = timeout
+	probers.Workers = workers
+	probers.RoundTimeout = roundTimeout
+	return probers, nil
+}
+
+// Methods returns the name of every available method
+func (p *Probers) Methods() (methods []string) {
+	for _, method := range []string{"tcp", "udp", "http", "ssh"} {
+		if _, ok := p.probers[method]; ok {
+			methods = append(methods, method)
+		}
+	}
+	for method := range p.probers {
+		if !StringInSlice(method, methods) {
+			methods = append(methods, method)
+		}
+	}
+	return methods
+}
+
+// Probe sends a round of Samples probes to the target with its method and summarizes them. The error is the last failed probe,
+// it's only returned when every probe failed (the latency is -1).
+// When ctx is done the round stops: the samples taken so far are summarized, without any the error is the error of ctx.
+func (p *Probers) Probe(ctx context.Context, target LatencyTarget) (LatencyResult, error) {
+	method := target.Method
+	if method == "" {
+		method = p.Default
+	}
+	prober, ok := p.probers[method]
+	if !ok {
+		return SummarizeSamples(target.Hostname, method, nil, 0), fmt.Errorf("probe: unknown method %s for %s", method, target.Hostname)
+	}
+
+	samples := []time.Duration{}
+	sent := 0
+	var lastErr error
+	for i := 0; i < p.Samples; i++ {
+		if i > 0 && p.Interval > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(p.Interval):
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		probeCtx, cancel := context.WithTimeout(ctx, p.Timeout)
+		start := time.Now()
+		elapsed, err := prober.Probe(probeCtx, target)
+		cancel()
+		// A probe interrupted by the end of the round isn't a lost sample
+		if ctx.Err() != nil {
+			break
+		}
+		observeProbe(method, time.Since(start), err)
+		sent++
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		samples = append(samples, elapsed)
+	}
+
+	result := SummarizeSamples(target.Hostname, method, samples, sent)
+	if sent == 0 && ctx.Err() != nil {
+		return result, ctx.Err()
+	}
+	if len(samples) == 0 {
+		return result, lastErr
+	}
+	return result, nil
+}
+
+// Returns the address of the non-SSH probes of the target, the probe port takes precedence over the SSH port (Hostport), fallback is used when both are empty
+func probeAddress(target LatencyTarget, fallback string) string {
+	port := target.ProbePort
+	if port == "" {
+		port = target.Hostport
+	}
+	if port == "" {
+		port = fallback
+	}
+	return net.JoinHostPort(target.Hostname, port)
+}
+
+// Returns the address of the SSH server of the target, the SSH port (Hostport) or 22. The probe port is the port of the tcp, udp and
+// http probes, an SSH connection or a known_hosts lookup to it would reach another service.
+func sshAddress(target LatencyTarget) string {
+	port := target.Hostport
+	if port == "" {
+		port = "22"
+	}
+	return net.JoinHostPort(target.Hostname, port)
+}
+
+// Returns the address of the echo service of the target, the probe port (the responder port of the DRC responder) or the echo port (7).
+// Echo probes never fall back to the SSH port, the token would be sent to the SSH server.
+func echoAddress(target LatencyTarget) string {
+	port := target.ProbePort
+	if port == "" {
+		port = "7"
+	}
+	return net.JoinHostPort(target.Hostname, port)
+}
+
+// Applies the deadline of ctx to the connection and interrupts it when ctx is cancelled, the returned function stops watching ctx
+func watchContext(ctx context.Context, conn net.Conn) func() {
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+	return func() { close(done) }
+}
+
+// -- TCP
+// TCPProber measures the time to open a TCP connection (SYN, SYN-ACK), nothing is sent to the target.
+// Targets running the DRC responder answer with an echo, there the probe measures the round trip of a token over the open connection.
+type TCPProber struct{}
+
+func (p *TCPProber) Method() string {
+	return "tcp"
+}
+
+func (p *TCPProber) Probe(ctx context.Context, target LatencyTarget) (time.Duration, error) {
+	address := probeAddress(target, "22")
+	if target.Responder {
+		address = echoAddress(target)
+	}
+	var dialer net.Dialer
+	start := time.Now()
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return 0, fmt.Errorf("probe: %v", err)
+	}
+	elapsed := time.Since(start)
+	defer conn.Close()
+	if !target.Responder {
+		return elapsed, nil
+	}
+
+	token, err := probeToken()
+	if err != nil {
+		return 0, err
+	}
+	defer watchContext(ctx, conn)()
+	start = time.Now()
+	if _, err := conn.Write(token); err != nil {
+		return 0, fmt.Errorf("probe: %v", err)
+	}
+	echo := make([]byte, len(token))
+	if _, err := io.ReadFull(conn, echo); err != nil {
+		return 0, fmt.Errorf("probe: no echo from %s: %v", target.Hostname, err)
+	}
+	if !bytes.Equal(echo, token) {
+		return 0, fmt.Errorf("probe: wrong echo from %s", target.Hostname)
+	}
+	return time.Since(start), nil
+}
+
+// Random bytes of the probe tokens, they are sent hex encoded
+const probeTokenBytes = 16
+
+// Random payload of the echo probes, the answer must be the same token
+func probeToken() ([]byte, error) {
+	random := make([]byte, probeTokenBytes)
+	if _, err := rand.Read(random); err != nil {
+		return nil, fmt.Errorf("probe: %v", err)
+	}
+	return []byte(hex.EncodeToString(random)), nil
+}
+
+// Returns true when the payload has the format of the probe tokens: probeTokenBytes, lowercase hex encoded
+func isProbeToken(payload []byte) bool {
+	if len(payload) != hex.EncodedLen(probeTokenBytes) {
+		return false
+	}
+	for _, c := range payload {
+		if !(c >= '0' && c <= '9') && !(c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// -- UDP
+// UDPProber sends a random token to an echo service (the DRC responder, or port 7 by default) and measures the time until the same token comes back
+type UDPProber struct{}
+
+func (p *UDPProber) Method() string {
+	return "udp"
+}
+
+func (p *UDPProber) Probe(ctx context.Context, target LatencyTarget) (time.Duration, error) {
+	token, err := probeToken()
+	if err != nil {
+		return 0, err
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", echoAddress(target))
+	if err != nil {
+		return 0, fmt.Errorf("probe: %v", err)
+	}
+	defer conn.Close()
+	defer watchContext(ctx, conn)()
+
+	start := time.Now()
+	if _, err := conn.Write(token); err != nil {
+		return 0, fmt.Errorf("probe: %v", err)
+	}
+	buf := make([]byte, 512)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return 0, fmt.Errorf("probe: no echo from %s: %v", target.Hostname, err)
+		}
+		// Late echoes of previous probes are ignored
+		if bytes.Equal(buf[:n], token) {
+			return time.Since(start), nil
+		}
+	}
+}
+
+// -- HTTP
+// HTTPProber measures the time until the response headers of a GET request arrive. Any response counts, the status code only tells
+// that the server is reachable. Hostnames that start with http:// or https:// are requested as they are.
+type HTTPProber struct {
+	client *http.Client
+}
+
+func NewHTTPProber() *HTTPProber {
+	// Every probe opens a new connection, otherwise only the first probe would pay the TCP handshake
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DisableKeepAlives = true
+	return &HTTPProber{
+		client: &http.Client{
+			Transport: transport,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+func (p *HTTPProber) Method() string {
+	return "http"
+}
+
+func (p *HTTPProber) Probe(ctx context.Context, target LatencyTarget) (time.Duration, error) {
+	url := target.Hostname
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		port := target.ProbePort
+		if port == "" {
+			port = "80"
+		}
+		url = "http://" + net.JoinHostPort(target.Hostname, port) + "/"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, fmt.Errorf("probe: %v", err)
+	}
+
+	start := time.Now()
+	res, err := p.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("probe: %v", err)
+	}
+	elapsed := time.Since(start)
+	io.Copy(ioutil.Discard, res.Body)
+	res.Body.Close()
+	return elapsed, nil
+}
+
+// -- SSH
+// SSHProber logs into the target, runs echo and compares the output. It measures the whole login, so it includes the SSH handshake and authentication.
+type SSHProber struct {
+	Options *SSHOptions
+}
+
+func (p *SSHProber) Method() string {
+	return "ssh"
+}
+
+func (p *SSHProber) Probe(ctx context.Context, target LatencyTarget) (time.Duration, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	expected := timestamp[len(timestamp)-1:]
+	start := time.Now()
+	if err := p.run(ctx, target, "echo "+expected, expected); err != nil {
+		return 0, err
+	}
+	return time.Since(start), nil
+}
+
+// Creates an SSH connection to the target, runs a command and compares the result of the command to the expected value.
+// The host key is verified and the authentication comes from the options (private keys, ssh-agent and, only if allowed, the password of the target).
+func (p *SSHProber) run(ctx context.Context, target LatencyTarget, cmd string, expected string) error {
+	config, closeAgent, err := p.Options.ClientConfig(target)
+	if err != nil {
+		return err
+	}
+	defer closeAgent()
+
+	address := sshAddress(target)
+	dialer := net.Dialer{Timeout: config.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	defer watchContext(ctx, conn)()
+
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, address, config)
+	if err != nil {
+		return err
+	}
+	client := ssh.NewClient(sshConn, chans, reqs)
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	output, err := session.Output(cmd)
+	if err != nil {
+		return fmt.Errorf("unable to execute remote command: %s", err)
+	}
+	if !strings.Contains(string(output), expected) {
+		return fmt.Errorf("FALSE RESULT, expected %s and got %s", expected, output)
+	}
+	return nil
+}
+
+// Adds the probers to the gin context, used by the latency endpoints
+func ProberMiddleware(probers *Probers) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("PROBERS", probers)
+	}
+}
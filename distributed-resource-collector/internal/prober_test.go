@@ -0,0 +1,27 @@
+package internal
+
+import "testing"
+
+func TestProbeAddresses(t *testing.T) {
+	tests := []struct {
+		name      string
+		target    LatencyTarget
+		wantProbe string
+		wantSSH   string
+	}{
+		{name: "no ports", target: LatencyTarget{Hostname: "10.0.0.5"}, wantProbe: "10.0.0.5:22", wantSSH: "10.0.0.5:22"},
+		{name: "ssh port", target: LatencyTarget{Hostname: "10.0.0.5", Hostport: "2222"}, wantProbe: "10.0.0.5:2222", wantSSH: "10.0.0.5:2222"},
+		{name: "probe port", target: LatencyTarget{Hostname: "10.0.0.5", ProbePort: "7007"}, wantProbe: "10.0.0.5:7007", wantSSH: "10.0.0.5:22"},
+		{name: "both ports", target: LatencyTarget{Hostname: "fd00::5", Hostport: "2222", ProbePort: "7007"}, wantProbe: "[fd00::5]:7007", wantSSH: "[fd00::5]:2222"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := probeAddress(test.target, "22"); got != test.wantProbe {
+				t.Errorf("probeAddress() = %s, want %s", got, test.wantProbe)
+			}
+			if got := sshAddress(test.target); got != test.wantSSH {
+				t.Errorf("sshAddress() = %s, want %s", got, test.wantSSH)
+			}
+		})
+	}
+}
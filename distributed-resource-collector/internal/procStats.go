@@ -13,3 +13,23 @@ func GetProcStats() (procStats DrcProcStats) {
 		BlockedProcs: tmpProcs.ProcsBlocked,
 	}
 }
+
+// -- COLLECTOR
+type procCollector struct{}
+
+func init() {
+	RegisterCollector(&procCollector{})
+}
+
+func (c *procCollector) Name() string {
+	return "proc"
+}
+
+func (c *procCollector) Configure(options map[string]string) error {
+	return nil
+}
+
+func (c *procCollector) Collect(stats *DrcStats) error {
+	stats.ProcStats = GetProcStats()
+	return nil
+}
@@ -0,0 +1,512 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wI2L/jettison"
+)
+
+// GatewayClient is used for every request to the Fabric Gateway Application
+var GatewayClient = &http.Client{Timeout: 10 * time.Second}
+
+// PostJson sends a JSON body to the gateway, responses outside 2xx are returned as errors
+func PostJson(url string, body []byte) error {
+	_, _, err := postGateway(url, body)
+	return err
+}
+
+// PostJsonResponse sends a JSON body to the gateway like PostJson and returns the body of the response
+func PostJsonResponse(url string, body []byte) ([]byte, error) {
+	_, response, err := postGateway(url, body)
+	return response, err
+}
+
+// Returns the status code and the body of the response, the status code is 0 when the gateway couldn't be reached.
+// Every post is signed with SigningKey, replays from the queue get a new nonce and timestamp.
+func postGateway(url string, body []byte) (status int, response []byte, err error) {
+	defer func() { observePost(url, err) }()
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if SigningKey != nil {
+		if err := SignPayload(SigningKey, req.Header, body); err != nil {
+			return 0, nil, err
+		}
+	}
+	res, err := GatewayClient.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer res.Body.Close()
+	response, err = ioutil.ReadAll(res.Body)
+	if err != nil {
+		return res.StatusCode, nil, err
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, response, fmt.Errorf("POST %s returned %s: %s", url, res.Status, strings.TrimSpace(string(response)))
+	}
+	return res.StatusCode, response, nil
+}
+
+// Result of every item of a batch post (POST <url>/batch), in the same order as the items were sent
+type BatchResult struct {
+	ID      string `json:"id"`
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+}
+
+// -- QUEUE
+// A queued POST. Body is the payload exactly as it would have been sent, so it keeps the original timestamp of the sample.
+// Timestamp is the time of the sample, used to drop entries older than the maximum age.
+type QueueEntry struct {
+	URL       string          `json:"url"`
+	Timestamp time.Time       `json:"timestamp"`
+	Attempts  int             `json:"attempts"`
+	Body      json.RawMessage `json:"body"`
+}
+
+type QueueStatus struct {
+	Depth      int       `json:"depth"`
+	OldestAge  float64   `json:"oldestAge"` // seconds since the oldest queued sample was taken
+	Oldest     time.Time `json:"oldest"`
+	LastError  string    `json:"lastError"`
+	RetryAfter time.Time `json:"retryAfter"`
+}
+
+func (d QueueStatus) String() string {
+	s, _ := jettison.MarshalOpts(d, jettison.NilMapEmpty(), jettison.NilSliceEmpty())
+	return string(s)
+}
+
+// ForwardQueue keeps the heartbeats and latency results that couldn't be posted to the gateway and replays them in order once it's back.
+// Every entry is a file in the queue directory, named after its sequence number, so the queue survives a restart of the DRC.
+// While the queue isn't empty new samples are queued behind the older ones, the gateway always receives them in order.
+// Consecutive entries for the same url are replayed together through <url>/batch, up to BatchSize entries per post. A gateway without
+// batch endpoints gets the entries one by one, batches are tried again after the longest backoff.
+type ForwardQueue struct {
+	dir        string
+	maxEntries int
+	maxAge     time.Duration
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	BatchSize  int // 1 disables batch posts
+
+	sendMutex sync.Mutex // Only one goroutine posts at a time, otherwise the order is lost
+
+	mutex     sync.Mutex
+	files     []string // Entry files, oldest first
+	oldest    time.Time
+	sequence  int64
+	backoff   time.Duration
+	nextTry   time.Time
+	lastError string
+
+	batchMutex    sync.Mutex
+	batchFallback time.Time // Until then entries are posted one by one, the gateway answered 404 to a batch
+}
+
+func NewForwardQueue(dir string, maxEntries int, maxAge time.Duration, minBackoff time.Duration, maxBackoff time.Duration) (*ForwardQueue, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("queue: %v", err)
+	}
+	queue := &ForwardQueue{dir: dir, maxEntries: maxEntries, maxAge: maxAge, minBackoff: minBackoff, maxBackoff: maxBackoff, BatchSize: 1}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("queue: %v", err)
+	}
+	// Names are zero padded sequence numbers, the lexical order is the order of arrival
+	sort.Strings(files)
+	queue.files = files
+	if len(files) > 0 {
+		fmt.Sscanf(filepath.Base(files[len(files)-1]), "%d.json", &queue.sequence)
+		if entry, err := readQueueEntry(files[0]); err == nil {
+			queue.oldest = entry.Timestamp
+		}
+	}
+	queue.mutex.Lock()
+	queue.prune()
+	queue.mutex.Unlock()
+	return queue, nil
+}
+
+// NewForwardQueueFromEnv creates the queue from QUEUE_DIR ("queue"), QUEUE_MAX_ENTRIES (10000), QUEUE_MAX_AGE (24h),
+// QUEUE_MIN_BACKOFF (5s), QUEUE_MAX_BACKOFF (5m) and QUEUE_BATCH_SIZE (50)
+func NewForwardQueueFromEnv() (*ForwardQueue, error) {
+	settings, err := forwardQueueSettingsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	queue, err := NewForwardQueue(settings.dir, settings.maxEntries, settings.maxAge, settings.minBackoff, settings.maxBackoff)
+	if err != nil {
+		return nil, err
+	}
+	queue.BatchSize = settings.batchSize
+	return queue, nil
+}
+
+// CheckForwardQueueFromEnv validates the settings of NewForwardQueueFromEnv and returns the number of queued posts. The queue directory
+// isn't created and the old posts aren't pruned, a directory that doesn't exist yet holds no posts.
+func CheckForwardQueueFromEnv() (int, error) {
+	settings, err := forwardQueueSettingsFromEnv()
+	if err != nil {
+		return 0, err
+	}
+	info, err := os.Stat(settings.dir)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("queue: %v", err)
+	}
+	if !info.IsDir() {
+		return 0, fmt.Errorf("queue: %s is not a directory", settings.dir)
+	}
+	files, err := filepath.Glob(filepath.Join(settings.dir, "*.json"))
+	if err != nil {
+		return 0, fmt.Errorf("queue: %v", err)
+	}
+	return len(files), nil
+}
+
+type forwardQueueSettings struct {
+	dir                            string
+	maxEntries, batchSize          int
+	maxAge, minBackoff, maxBackoff time.Duration
+}
+
+func forwardQueueSettingsFromEnv() (forwardQueueSettings, error) {
+	settings := forwardQueueSettings{dir: GetEnv("QUEUE_DIR", "queue")}
+	if _, err := fmt.Sscan(GetEnv("QUEUE_MAX_ENTRIES", "10000"), &settings.maxEntries); err != nil {
+		return settings, fmt.Errorf("queue: invalid QUEUE_MAX_ENTRIES: %v", err)
+	}
+	if _, err := fmt.Sscan(GetEnv("QUEUE_BATCH_SIZE", "50"), &settings.batchSize); err != nil || settings.batchSize < 1 {
+		return settings, fmt.Errorf("queue: invalid QUEUE_BATCH_SIZE: %s", GetEnv("QUEUE_BATCH_SIZE", "50"))
+	}
+	durations := map[string]time.Duration{}
+	for key, fallback := range map[string]string{"QUEUE_MAX_AGE": "24h", "QUEUE_MIN_BACKOFF": "5s", "QUEUE_MAX_BACKOFF": "5m"} {
+		duration, err := time.ParseDuration(GetEnv(key, fallback))
+		if err != nil {
+			return settings, fmt.Errorf("queue: invalid %s: %v", key, err)
+		}
+		durations[key] = duration
+	}
+	settings.maxAge, settings.minBackoff, settings.maxBackoff = durations["QUEUE_MAX_AGE"], durations["QUEUE_MIN_BACKOFF"], durations["QUEUE_MAX_BACKOFF"]
+	return settings, nil
+}
+
+// Send posts the body to the url. If the queue isn't empty, or the post fails, the body is queued and replayed later.
+// Bodies rejected by the gateway (see retryable) aren't queued, they would be rejected again.
+// The error is only returned when the body couldn't be posted nor queued.
+func (q *ForwardQueue) Send(url string, timestamp time.Time, body []byte) error {
+	q.sendMutex.Lock()
+	defer q.sendMutex.Unlock()
+
+	if q.Status().Depth == 0 {
+		status, _, err := postGateway(url, body)
+		if err == nil {
+			return nil
+		}
+		if !retryable(status) {
+			return err
+		}
+		q.failed(err)
+	}
+	if err := q.push(QueueEntry{URL: url, Timestamp: timestamp, Body: body}); err != nil {
+		return err
+	}
+	observeQueued(url)
+	q.flush()
+	return nil
+}
+
+// Flush replays the queued entries in order if the backoff time has passed
+func (q *ForwardQueue) Flush() {
+	q.sendMutex.Lock()
+	defer q.sendMutex.Unlock()
+	q.flush()
+}
+
+// Start replays the queue in the background, checking every interval if the backoff time has passed
+func (q *ForwardQueue) Start(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			q.Flush()
+		}
+	}()
+}
+
+func (q *ForwardQueue) Status() QueueStatus {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	status := QueueStatus{Depth: len(q.files), LastError: q.lastError, RetryAfter: q.nextTry}
+	if len(q.files) > 0 && !q.oldest.IsZero() {
+		status.Oldest = q.oldest
+		status.OldestAge = time.Since(q.oldest).Seconds()
+	}
+	return status
+}
+
+// Must be called with sendMutex locked. Stops at the first failure, the rest of the entries wait for the next attempt.
+func (q *ForwardQueue) flush() {
+	q.mutex.Lock()
+	q.prune()
+	waiting := time.Now().Before(q.nextTry)
+	q.mutex.Unlock()
+	if waiting {
+		return
+	}
+
+	for {
+		files, entries := q.next()
+		if len(files) == 0 {
+			return
+		}
+		sent, err := 1, error(nil)
+		if len(files) == 1 {
+			err = postEntry(entries[0])
+		} else {
+			sent, err = q.postBatch(entries)
+		}
+		if err != nil {
+			for i := 0; i < sent; i++ {
+				entries[i].Attempts += 1
+				writeQueueEntry(files[i], entries[i])
+			}
+			q.failed(err)
+			return
+		}
+		for _, file := range files[:sent] {
+			q.pop(file)
+		}
+		q.succeeded()
+	}
+}
+
+// Returns the oldest entries that can be posted together: consecutive entries with the same url, up to BatchSize.
+// Entries that can't be read are dropped.
+func (q *ForwardQueue) next() (files []string, entries []QueueEntry) {
+	q.mutex.Lock()
+	candidates := append([]string{}, q.files...)
+	q.mutex.Unlock()
+
+	batchSize := q.batchSize()
+	for _, file := range candidates {
+		if len(files) >= batchSize {
+			break
+		}
+		entry, err := readQueueEntry(file)
+		if err != nil {
+			if len(files) > 0 {
+				break
+			}
+			CheckError(fmt.Errorf("queue: dropping unreadable entry %s: %v", file, err))
+			q.pop(file)
+			continue
+		}
+		if len(entries) > 0 && entry.URL != entries[0].URL {
+			break
+		}
+		files = append(files, file)
+		entries = append(entries, entry)
+	}
+	return files, entries
+}
+
+// Returns BatchSize, or 1 while the gateway has no batch endpoints
+func (q *ForwardQueue) batchSize() int {
+	q.batchMutex.Lock()
+	defer q.batchMutex.Unlock()
+	if time.Now().Before(q.batchFallback) {
+		return 1
+	}
+	return q.BatchSize
+}
+
+// Posts the entries one by one until maxBackoff has passed, the gateway may be updated in the meantime
+func (q *ForwardQueue) fallBackFromBatch() {
+	q.batchMutex.Lock()
+	defer q.batchMutex.Unlock()
+	q.batchFallback = time.Now().Add(q.maxBackoff)
+}
+
+// Network errors, 5xx, 408 and 429 are retried with backoff. Any other status is a rejection of the body that would happen again
+// (an ID that already exists, an invalid signature), the body is dropped instead of blocking the queue.
+func retryable(status int) bool {
+	return status == 0 || status >= 500 || status == http.StatusRequestTimeout || status == http.StatusTooManyRequests
+}
+
+// Posts a queued entry, an entry rejected by the gateway is reported and dropped
+func postEntry(entry QueueEntry) error {
+	status, _, err := postGateway(entry.URL, entry.Body)
+	if err != nil && !retryable(status) {
+		CheckError(fmt.Errorf("queue: dropping entry for %s, rejected by the gateway: %v", entry.URL, err))
+		return nil
+	}
+	return err
+}
+
+// Posts the entries as a JSON array to <url>/batch and returns how many entries were handled, starting from the first one.
+// The results are in the order of the entries, only the leading entries with a successful result are handled. An entry with a failed
+// result is posted alone on the next call, where a rejection drops it (see postEntry), and entries without a result stay in the queue.
+// Gateways without batch endpoints answer 404, only the first entry is posted and the rest are posted one by one for a while.
+// A batch rejected as a whole is split: only the first entry is posted, so the entry that was rejected is found and dropped.
+func (q *ForwardQueue) postBatch(entries []QueueEntry) (int, error) {
+	bodies := []json.RawMessage{}
+	for _, entry := range entries {
+		bodies = append(bodies, entry.Body)
+	}
+	payload, err := json.Marshal(bodies)
+	if err != nil {
+		return len(entries), err
+	}
+
+	status, response, err := postGateway(strings.TrimSuffix(entries[0].URL, "/")+"/batch", payload)
+	if status == http.StatusNotFound {
+		CheckError(fmt.Errorf("queue: the gateway has no batch endpoint for %s, sending entries one by one for %s", entries[0].URL, q.maxBackoff))
+		q.fallBackFromBatch()
+		return 1, postEntry(entries[0])
+	}
+	if err != nil && !retryable(status) {
+		return 1, postEntry(entries[0])
+	}
+	if err != nil {
+		return len(entries), err
+	}
+
+	var results []BatchResult
+	if err := json.Unmarshal(response, &results); err != nil {
+		return len(entries), fmt.Errorf("queue: invalid batch response: %v", err)
+	}
+	if len(results) == 0 || len(results) > len(entries) {
+		return len(entries), fmt.Errorf("queue: the batch response has %d results for %d entries", len(results), len(entries))
+	}
+	if !results[0].Success {
+		CheckError(fmt.Errorf("queue: %s failed in a batch: %s, posting it alone", results[0].ID, results[0].Error))
+		return 1, postEntry(entries[0])
+	}
+	handled := 0
+	for handled < len(results) && results[handled].Success {
+		handled += 1
+	}
+	return handled, nil
+}
+
+func (q *ForwardQueue) push(entry QueueEntry) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.sequence += 1
+	file := filepath.Join(q.dir, fmt.Sprintf("%020d.json", q.sequence))
+	if err := writeQueueEntry(file, entry); err != nil {
+		return fmt.Errorf("queue: %v", err)
+	}
+	if len(q.files) == 0 {
+		q.oldest = entry.Timestamp
+	}
+	q.files = append(q.files, file)
+	q.prune()
+	return nil
+}
+
+// Removes the entry that was just sent (or couldn't be read), it's always the first one
+func (q *ForwardQueue) pop(file string) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	os.Remove(file)
+	if len(q.files) > 0 && q.files[0] == file {
+		q.files = q.files[1:]
+	}
+	q.updateOldest()
+}
+
+// Drops the oldest entries when the queue is over its size, or when they are older than the maximum age. Must be called with mutex locked.
+func (q *ForwardQueue) prune() {
+	dropped := 0
+	for q.maxEntries > 0 && len(q.files) > q.maxEntries {
+		os.Remove(q.files[0])
+		q.files = q.files[1:]
+		dropped += 1
+	}
+	q.updateOldest()
+	for q.maxAge > 0 && len(q.files) > 0 && !q.oldest.IsZero() && time.Since(q.oldest) > q.maxAge {
+		os.Remove(q.files[0])
+		q.files = q.files[1:]
+		dropped += 1
+		q.updateOldest()
+	}
+	if dropped > 0 {
+		CheckError(fmt.Errorf("queue: dropped %d entries over the queue limits", dropped))
+	}
+}
+
+func (q *ForwardQueue) updateOldest() {
+	q.oldest = time.Time{}
+	if len(q.files) > 0 {
+		if entry, err := readQueueEntry(q.files[0]); err == nil {
+			q.oldest = entry.Timestamp
+		}
+	}
+}
+
+// Exponential backoff between replays, from minBackoff up to maxBackoff
+func (q *ForwardQueue) failed(err error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if q.backoff == 0 {
+		q.backoff = q.minBackoff
+	} else if q.backoff *= 2; q.backoff > q.maxBackoff {
+		q.backoff = q.maxBackoff
+	}
+	q.nextTry = time.Now().Add(q.backoff)
+	q.lastError = err.Error()
+	CheckError(fmt.Errorf("queue: %v, retrying in %s", err, q.backoff))
+}
+
+func (q *ForwardQueue) succeeded() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.backoff = 0
+	q.nextTry = time.Time{}
+	q.lastError = ""
+}
+
+func readQueueEntry(file string) (entry QueueEntry, err error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return entry, err
+	}
+	err = json.Unmarshal(content, &entry)
+	return entry, err
+}
+
+// The entry is written to a temporary file and renamed, a crash never leaves a half written entry in the queue
+func writeQueueEntry(file string, entry QueueEntry) error {
+	content, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	tmp := file + ".tmp"
+	if err := ioutil.WriteFile(tmp, content, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+func QueueMiddleware(queue *ForwardQueue) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("QUEUE", queue)
+	}
+}
@@ -0,0 +1,132 @@
+package internal
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Gateway that records the posts and answers the batches with batchResponse, called with the number of items of the batch
+type testGateway struct {
+	mutex   sync.Mutex
+	batches []int
+	singles []string
+}
+
+func (g *testGateway) serve(t *testing.T, batchResponse func(items int) (int, []BatchResult)) string {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		g.mutex.Lock()
+		defer g.mutex.Unlock()
+		if r.URL.Path == "/resource/batch" {
+			var items []json.RawMessage
+			json.Unmarshal(body, &items)
+			g.batches = append(g.batches, len(items))
+			status, results := batchResponse(len(items))
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(results)
+			return
+		}
+		g.singles = append(g.singles, string(body))
+	}))
+	t.Cleanup(server.Close)
+	return server.URL + "/resource"
+}
+
+func newTestQueue(t *testing.T, url string, bodies ...string) *ForwardQueue {
+	t.Helper()
+	queue, err := NewForwardQueue(t.TempDir(), 100, time.Hour, time.Minute, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queue.BatchSize = 10
+	for _, body := range bodies {
+		if err := queue.push(QueueEntry{URL: url, Timestamp: time.Now(), Body: json.RawMessage(body)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return queue
+}
+
+func TestForwardQueueBatchResults(t *testing.T) {
+	tests := []struct {
+		name        string
+		results     []BatchResult
+		wantBatches []int
+		wantSingles []string
+	}{
+		{
+			name:        "all succeeded",
+			results:     []BatchResult{{ID: "1", Success: true}, {ID: "2", Success: true}, {ID: "3", Success: true}},
+			wantBatches: []int{3},
+			wantSingles: []string{},
+		},
+		{
+			// The entry without a result is posted again
+			name:        "missing result",
+			results:     []BatchResult{{ID: "1", Success: true}, {ID: "2", Success: true}},
+			wantBatches: []int{3},
+			wantSingles: []string{`{"id":3}`},
+		},
+		{
+			// The failed entry is posted alone, then the rest are batched again
+			name:        "failed result",
+			results:     []BatchResult{{ID: "1", Success: false, Error: "failed to put to world state"}, {ID: "2", Success: true}, {ID: "3", Success: true}},
+			wantBatches: []int{3, 2},
+			wantSingles: []string{`{"id":1}`},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gateway := &testGateway{singles: []string{}}
+			url := gateway.serve(t, func(items int) (int, []BatchResult) {
+				if items < len(test.results) {
+					return http.StatusOK, test.results[len(test.results)-items:]
+				}
+				return http.StatusOK, test.results
+			})
+			queue := newTestQueue(t, url, `{"id":1}`, `{"id":2}`, `{"id":3}`)
+			queue.Flush()
+
+			if depth := queue.Status().Depth; depth != 0 {
+				t.Errorf("Depth = %d, want 0", depth)
+			}
+			if !reflect.DeepEqual(gateway.batches, test.wantBatches) {
+				t.Errorf("batches = %v, want %v", gateway.batches, test.wantBatches)
+			}
+			if !reflect.DeepEqual(gateway.singles, test.wantSingles) {
+				t.Errorf("singles = %v, want %v", gateway.singles, test.wantSingles)
+			}
+		})
+	}
+}
+
+func TestForwardQueueBatchFallback(t *testing.T) {
+	gateway := &testGateway{}
+	url := gateway.serve(t, func(items int) (int, []BatchResult) {
+		return http.StatusNotFound, nil
+	})
+	queue := newTestQueue(t, url, `{"id":1}`, `{"id":2}`, `{"id":3}`)
+	queue.Flush()
+
+	if depth := queue.Status().Depth; depth != 0 {
+		t.Errorf("Depth = %d, want 0", depth)
+	}
+	if len(gateway.batches) != 1 || len(gateway.singles) != 3 {
+		t.Errorf("%d batches and %d single posts, want 1 batch and 3 single posts", len(gateway.batches), len(gateway.singles))
+	}
+	if size := queue.batchSize(); size != 1 {
+		t.Errorf("batchSize() = %d after a 404, want 1", size)
+	}
+	// Batches are tried again once the fallback is over, BatchSize itself was never changed
+	queue.batchFallback = time.Now().Add(-time.Second)
+	if size := queue.batchSize(); size != 10 {
+		t.Errorf("batchSize() = %d after the fallback, want 10", size)
+	}
+}
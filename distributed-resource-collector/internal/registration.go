@@ -0,0 +1,167 @@
+package internal
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/shirou/gopsutil/cpu"
+	"github.com/shirou/gopsutil/host"
+	"github.com/shirou/gopsutil/mem"
+)
+
+// -- SELF-REGISTRATION
+// Registration creates or updates the inventory asset of the node through the gateway (REGISTER_APP). The hardware is discovered on
+// every run, so later runs refresh the properties that changed (the addresses after a DHCP renewal). The gateway uses the address
+// it sees as the asset id and inventory-sc matches the node by hostId, the asset moves to the new id when the address changes.
+type Registration struct {
+	Type      int               // 0: server, 1: robot
+	Owner     string            // kept by the inventory when empty
+	Address   string            // address probed by the other nodes (properties.hostname), empty for the address the gateway sees
+	GPU       string            // auto (runs the gpu collector), true or false
+	Labels    map[string]string // added to the discovered labels, overriding them
+	responder *Responder
+	bandwidth *BandwidthServer
+}
+
+var registrationTypes = map[string]int{"server": 0, "robot": 1}
+
+// NewRegistration describes the node as assetType (server or robot). The ports of responder and bandwidthServer (nil when they're
+// disabled) are registered so other DRCs probe them.
+func NewRegistration(assetType string, owner string, address string, gpu string, labels map[string]string, responder *Responder, bandwidthServer *BandwidthServer) (*Registration, error) {
+	registrationType, ok := registrationTypes[assetType]
+	if !ok {
+		return nil, fmt.Errorf("registration: unknown type %s, expected server or robot", assetType)
+	}
+	if gpu != "auto" {
+		if _, err := strconv.ParseBool(gpu); err != nil {
+			return nil, fmt.Errorf("registration: gpu must be auto, true or false")
+		}
+	}
+	return &Registration{Type: registrationType, Owner: owner, Address: address, GPU: gpu, Labels: labels, responder: responder, bandwidth: bandwidthServer}, nil
+}
+
+// NewRegistrationFromEnv reads REGISTER_TYPE (server), REGISTER_OWNER, REGISTER_ADDRESS, REGISTER_GPU (auto) and REGISTER_LABELS (key=value,key=value)
+func NewRegistrationFromEnv(responder *Responder, bandwidthServer *BandwidthServer) (*Registration, error) {
+	labels, err := ParseLabels(GetEnv("REGISTER_LABELS", ""))
+	if err != nil {
+		return nil, fmt.Errorf("registration: %v", err)
+	}
+	return NewRegistration(GetEnv("REGISTER_TYPE", "server"), GetEnv("REGISTER_OWNER", ""), GetEnv("REGISTER_ADDRESS", ""), GetEnv("REGISTER_GPU", "auto"), labels, responder, bandwidthServer)
+}
+
+// Asset discovers the hardware of the node and returns the asset to register, the gateway sets the id
+func (r *Registration) Asset() (Asset, error) {
+	info, err := host.Info()
+	if err != nil {
+		return Asset{}, fmt.Errorf("registration: %v", err)
+	}
+	if info.HostID == "" {
+		return Asset{}, fmt.Errorf("registration: the host id of the node is unknown")
+	}
+	addresses, err := hostAddresses()
+	if err != nil {
+		return Asset{}, fmt.Errorf("registration: %v", err)
+	}
+	models := []string{}
+	if cpus, err := cpu.Info(); err == nil {
+		for _, c := range cpus {
+			models = append(models, strings.TrimSpace(c.ModelName))
+		}
+	}
+	cores, err := cpu.Counts(true)
+	if err != nil {
+		return Asset{}, fmt.Errorf("registration: %v", err)
+	}
+	memory, err := mem.VirtualMemory()
+	if err != nil {
+		return Asset{}, fmt.Errorf("registration: %v", err)
+	}
+	gpus, err := r.gpus()
+	if err != nil {
+		return Asset{}, fmt.Errorf("registration: %v", err)
+	}
+	labels := DiscoverLabels(gpus, cores, memory.Total)
+	for key, value := range r.Labels {
+		labels[key] = value
+	}
+
+	asset := Asset{
+		Name:  info.Hostname,
+		Owner: r.Owner,
+		Type:  r.Type,
+		State: 1,
+		Properties: Properties{
+			Hostname:  r.Address,
+			HostID:    info.HostID,
+			Addresses: strings.Join(addresses, ","),
+			CPUModel:  strings.Join(UniqueString(models), ","),
+			CPUCores:  cores,
+			Memory:    memory.Total,
+			Labels:    labels,
+		},
+	}
+	if len(gpus) > 0 {
+		asset.Properties.GPU = 1
+	}
+	if SigningKey != nil {
+		asset.Properties.PublicKey = PublicKeyString(SigningKey)
+	}
+	if r.responder != nil {
+		// Both protocols are answered, udp is the cheaper probe
+		asset.Properties.ResponderProtocol = r.responder.Protocol
+		if r.responder.Protocol == "both" {
+			asset.Properties.ResponderProtocol = "udp"
+		}
+		asset.Properties.ResponderPort = r.responder.Port
+	}
+	if r.bandwidth != nil {
+		asset.Properties.BandwidthPort = r.bandwidth.Port
+	}
+	return asset, nil
+}
+
+// Returns the GPUs found by the gpu collector. With REGISTER_GPU true the node has one GPU of an unknown vendor when the collector
+// doesn't find it, with false it has none.
+func (r *Registration) gpus() ([]DrcGPUStats, error) {
+	if r.GPU == "false" {
+		return nil, nil
+	}
+	stats := DrcStats{}
+	if err := CollectOnce("gpu", &stats); err != nil && r.GPU == "auto" {
+		return nil, err
+	}
+	if len(stats.GPUStats) == 0 && r.GPU != "auto" {
+		return []DrcGPUStats{{Vendor: "unknown", Name: "unknown"}}, nil
+	}
+	return stats.GPUStats, nil
+}
+
+// Returns the IP addresses of the interfaces that are up, without loopback and link-local addresses
+func hostAddresses() ([]string, error) {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	addresses := []string{}
+	for _, i := range interfaces {
+		if i.Flags&net.FlagUp == 0 || i.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := i.Addrs()
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			ip, _, err := net.ParseCIDR(addr.String())
+			if err != nil || ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+				continue
+			}
+			addresses = append(addresses, ip.String())
+		}
+	}
+	sort.Strings(addresses)
+	return addresses, nil
+}
@@ -0,0 +1,127 @@
+package internal
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+// Largest payload echoed back by the responder, the probes send 32 bytes
+const responderMaxPayload = 512
+
+// Responder answers the udp and tcp latency probes of other DRCs, it sends back the payloads it receives. UDP datagrams are only
+// answered when they are probe tokens sent from an unprivileged port other than the responder port.
+// Nodes running the responder can be probed without an SSH account (inventory properties responderProtocol and responderPort).
+type Responder struct {
+	Protocol string // udp, tcp or both
+	Port     string
+	Timeout  time.Duration // TCP connections are closed after this time
+	udp      net.PacketConn
+	tcp      net.Listener
+}
+
+func NewResponder(protocol string, port string, timeout time.Duration) (*Responder, error) {
+	if protocol != "udp" && protocol != "tcp" && protocol != "both" {
+		return nil, fmt.Errorf("responder: unknown protocol %s, expected udp, tcp or both", protocol)
+	}
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return nil, fmt.Errorf("responder: invalid port %s", port)
+	}
+	return &Responder{Protocol: protocol, Port: port, Timeout: timeout}, nil
+}
+
+// NewResponderFromEnv creates the responder from RESPONDER_PROTOCOL (both) and RESPONDER_PORT (7007),
+// it's nil unless RESPONDER_ENABLED is true
+func NewResponderFromEnv() (*Responder, error) {
+	enabled, err := strconv.ParseBool(GetEnv("RESPONDER_ENABLED", "false"))
+	if err != nil {
+		return nil, fmt.Errorf("responder: RESPONDER_ENABLED: %v", err)
+	}
+	if !enabled {
+		return nil, nil
+	}
+	return NewResponder(GetEnv("RESPONDER_PROTOCOL", "both"), GetEnv("RESPONDER_PORT", "7007"), 10*time.Second)
+}
+
+// Start listens on the responder port and answers in the background
+func (r *Responder) Start() error {
+	address := ":" + r.Port
+	if r.Protocol == "udp" || r.Protocol == "both" {
+		conn, err := net.ListenPacket("udp", address)
+		if err != nil {
+			return fmt.Errorf("responder: %v", err)
+		}
+		r.udp = conn
+		go r.serveUDP()
+	}
+	if r.Protocol == "tcp" || r.Protocol == "both" {
+		listener, err := net.Listen("tcp", address)
+		if err != nil {
+			r.Close()
+			return fmt.Errorf("responder: %v", err)
+		}
+		r.tcp = listener
+		go r.serveTCP()
+	}
+	return nil
+}
+
+// Close stops the responder
+func (r *Responder) Close() {
+	if r.udp != nil {
+		r.udp.Close()
+	}
+	if r.tcp != nil {
+		r.tcp.Close()
+	}
+}
+
+func (r *Responder) serveUDP() {
+	buf := make([]byte, responderMaxPayload)
+	for {
+		n, addr, err := r.udp.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if !r.answers(addr, buf[:n]) {
+			continue
+		}
+		r.udp.WriteTo(buf[:n], addr)
+	}
+}
+
+// UDP answers can be spoofed towards other services, only probe tokens are echoed and never to a well-known port (echo, chargen...)
+// or to the responder port, that could bounce the datagram back forever
+func (r *Responder) answers(addr net.Addr, payload []byte) bool {
+	udpAddr, ok := addr.(*net.UDPAddr)
+	if !ok || udpAddr.Port < 1024 || strconv.Itoa(udpAddr.Port) == r.Port {
+		return false
+	}
+	return isProbeToken(payload)
+}
+
+func (r *Responder) serveTCP() {
+	for {
+		conn, err := r.tcp.Accept()
+		if err != nil {
+			return
+		}
+		go func(conn net.Conn) {
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(r.Timeout))
+			buf := make([]byte, responderMaxPayload)
+			for {
+				n, err := conn.Read(buf)
+				if n > 0 {
+					if _, err := conn.Write(buf[:n]); err != nil {
+						return
+					}
+				}
+				if err != nil {
+					return
+				}
+			}
+		}(conn)
+	}
+}
@@ -0,0 +1,242 @@
+package internal
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-co-op/gocron"
+	"github.com/wI2L/jettison"
+)
+
+// -- SCHEDULE
+// Schedule of a periodic job of the DRC (heartbeat, latency, bandwidth). Jobs run every Interval or on the Cron expression.
+// Offset is the longest random delay of the first run (intervals) or of every run (cron expressions, the delay is picked once so
+// the node keeps its slot), Jitter the longest random delay added to every run. Nodes started together are spread instead of
+// posting to the gateway at the same instant.
+type Schedule struct {
+	Name     string
+	Interval time.Duration
+	Cron     string
+	Offset   time.Duration
+	Jitter   time.Duration
+}
+
+// ParseSchedule reads the schedule of a job from spec: a number of seconds ("30"), a duration ("5m") or a cron expression
+// ("*/5 * * * *", six fields include the seconds, descriptors like "@hourly" are accepted).
+// "0", "off" ("false" in YAML) and "" disable the job, the schedule is nil.
+func ParseSchedule(name string, spec string, offset time.Duration, jitter time.Duration) (*Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" || spec == "0" || spec == "off" || spec == "false" {
+		return nil, nil
+	}
+	schedule := &Schedule{Name: name, Offset: offset, Jitter: jitter}
+	if offset < 0 || jitter < 0 {
+		return nil, fmt.Errorf("schedule: %s offset and jitter can't be negative", name)
+	}
+	if seconds, err := strconv.Atoi(spec); err == nil {
+		schedule.Interval = time.Duration(seconds) * time.Second
+	} else if interval, err := time.ParseDuration(spec); err == nil {
+		schedule.Interval = interval
+	} else if strings.HasPrefix(spec, "@") || len(strings.Fields(spec)) == 5 || len(strings.Fields(spec)) == 6 {
+		schedule.Cron = spec
+	} else {
+		return nil, fmt.Errorf("schedule: %s has an invalid schedule %q, expected seconds, a duration or a cron expression", name, spec)
+	}
+	if schedule.Cron == "" && schedule.Interval <= 0 {
+		return nil, fmt.Errorf("schedule: %s interval must be positive", name)
+	}
+	return schedule, nil
+}
+
+// NewScheduleFromEnv reads the schedule of the job from <NAME>_SCHEDULE (fallback when it's empty), <NAME>_OFFSET and <NAME>_JITTER.
+// The offset and jitter of every job default to SCHEDULE_OFFSET (10s) and SCHEDULE_JITTER (1s).
+func NewScheduleFromEnv(name string, fallback string) (*Schedule, error) {
+	return NewScheduleFromConfig(CurrentConfig(), name, fallback)
+}
+
+// NewScheduleFromConfig is NewScheduleFromEnv with the values of file, a config that was read but isn't used yet
+func NewScheduleFromConfig(file *ConfigFile, name string, fallback string) (*Schedule, error) {
+	prefix := strings.ToUpper(name)
+	offset, err := time.ParseDuration(file.Get(prefix+"_OFFSET", file.Get("SCHEDULE_OFFSET", "10s")))
+	if err != nil {
+		return nil, fmt.Errorf("schedule: %s_OFFSET: %v", prefix, err)
+	}
+	jitter, err := time.ParseDuration(file.Get(prefix+"_JITTER", file.Get("SCHEDULE_JITTER", "1s")))
+	if err != nil {
+		return nil, fmt.Errorf("schedule: %s_JITTER: %v", prefix, err)
+	}
+	return ParseSchedule(name, file.Get(prefix+"_SCHEDULE", fallback), offset, jitter)
+}
+
+func (s Schedule) String() string {
+	if s.Cron != "" {
+		return s.Cron
+	}
+	return "every " + s.Interval.String()
+}
+
+// Random duration in [0, max), crypto/rand so nodes started together don't pick the same delays
+func randomDuration(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(max)))
+	if err != nil {
+		return 0
+	}
+	return time.Duration(n.Int64())
+}
+
+// -- SCHEDULER
+// Scheduler runs the periodic jobs of the DRC and keeps the status of every job, served by the status endpoint
+type Scheduler struct {
+	cron *gocron.Scheduler
+	jobs []*scheduledJob
+	mu   sync.Mutex
+}
+
+type scheduledJob struct {
+	schedule     Schedule
+	job          *gocron.Job
+	delay        time.Duration // fixed delay of the cron expressions, picked from the offset
+	mu           sync.Mutex
+	running      bool
+	runs         int
+	lastRun      time.Time
+	lastDuration time.Duration
+}
+
+// Status of a job. NextRun is the scheduled time, the run starts up to the jitter later.
+type JobStatus struct {
+	Name         string    `json:"name"`
+	Schedule     string    `json:"schedule"`
+	Offset       float64   `json:"offset"` // seconds
+	Jitter       float64   `json:"jitter"` // seconds
+	Running      bool      `json:"running"`
+	Runs         int       `json:"runs"`
+	LastRun      time.Time `json:"lastRun"`
+	LastDuration float64   `json:"lastDuration"` // ms
+	NextRun      time.Time `json:"nextRun"`
+}
+
+type SchedulerStatus struct {
+	Timestamp time.Time   `json:"timestamp"`
+	Jobs      []JobStatus `json:"jobs"`
+}
+
+func (s SchedulerStatus) String() string {
+	b, _ := jettison.MarshalOpts(s, jettison.NilMapEmpty(), jettison.NilSliceEmpty())
+	return string(b)
+}
+
+func NewScheduler() *Scheduler {
+	return &Scheduler{cron: gocron.NewScheduler(time.Local)}
+}
+
+// Add schedules task, a run doesn't start while the previous one is still running
+func (s *Scheduler) Add(schedule Schedule, task func()) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sj := &scheduledJob{schedule: schedule}
+	if schedule.Cron != "" {
+		sj.delay = randomDuration(schedule.Offset)
+		fields := len(strings.Fields(schedule.Cron))
+		if fields == 6 {
+			s.cron.CronWithSeconds(schedule.Cron)
+		} else {
+			s.cron.Cron(schedule.Cron)
+		}
+	} else if schedule.Offset > 0 {
+		s.cron.Every(schedule.Interval).StartAt(time.Now().Add(randomDuration(schedule.Offset)))
+	} else {
+		// Without an offset the first run starts right away
+		s.cron.Every(schedule.Interval)
+	}
+	job, err := s.cron.SingletonMode().Do(sj.run, task)
+	if err != nil {
+		return fmt.Errorf("schedule: %s: %v", schedule.Name, err)
+	}
+	sj.job = job
+	s.jobs = append(s.jobs, sj)
+	return nil
+}
+
+// Start runs the jobs in the background
+func (s *Scheduler) Start() {
+	s.cron.StartAsync()
+}
+
+// Stop stops the jobs, a running job finishes its run
+func (s *Scheduler) Stop() {
+	s.cron.Stop()
+}
+
+// Clear removes every job, used to reschedule the jobs after a config reload. A running job finishes its run.
+func (s *Scheduler) Clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cron.Clear()
+	s.jobs = nil
+}
+
+// Status returns the status of every job, in the order they were added
+func (s *Scheduler) Status() SchedulerStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := SchedulerStatus{Timestamp: time.Now(), Jobs: []JobStatus{}}
+	for _, sj := range s.jobs {
+		status.Jobs = append(status.Jobs, sj.status())
+	}
+	return status
+}
+
+func (sj *scheduledJob) run(task func()) {
+	time.Sleep(sj.delay + randomDuration(sj.schedule.Jitter))
+	start := time.Now()
+	sj.mu.Lock()
+	sj.running = true
+	sj.lastRun = start
+	sj.mu.Unlock()
+
+	defer func() {
+		sj.mu.Lock()
+		sj.running = false
+		sj.runs++
+		sj.lastDuration = time.Since(start)
+		sj.mu.Unlock()
+		observeJob(sj.schedule.Name, sj.lastDuration)
+	}()
+	task()
+}
+
+func (sj *scheduledJob) status() JobStatus {
+	sj.mu.Lock()
+	defer sj.mu.Unlock()
+	status := JobStatus{
+		Name:         sj.schedule.Name,
+		Schedule:     sj.schedule.String(),
+		Offset:       sj.schedule.Offset.Seconds(),
+		Jitter:       sj.schedule.Jitter.Seconds(),
+		Running:      sj.running,
+		Runs:         sj.runs,
+		LastRun:      sj.lastRun,
+		LastDuration: float64(sj.lastDuration.Microseconds()) / 1000,
+	}
+	if next := sj.job.NextRun(); !next.IsZero() {
+		status.NextRun = next.Add(sj.delay)
+	}
+	return status
+}
+
+// Adds the scheduler to the gin context, used by the status endpoint
+func SchedulerMiddleware(scheduler *Scheduler) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("SCHEDULER", scheduler)
+	}
+}
@@ -4,20 +4,14 @@ import (
 	"time"
 )
 
+// GetServerStats builds the heartbeat from every enabled collector, see collector.go
 func GetServerStats() (dcrStats DrcStats) {
 	tmpTime := time.Now()
-	timestamp := DrcTimestamp{
+	dcrStats.Timestamp = DrcTimestamp{
 		TimeLocal:   tmpTime,
 		TimeSeconds: tmpTime.Unix(),
 		TimeNano:    tmpTime.UnixNano(),
 	}
-	return DrcStats{
-		Timestamp:  timestamp,
-		DrcHost:    GetHostStats(),
-		CPUStats:   GetCPUUsage(),
-		MemStats:   GetMemoryUsage(),
-		DiskStats:  GetDiskUsage(),
-		ProcStats:  GetProcStats(),
-		DockerSats: GetDockerStats(),
-	}
+	collectStats(&dcrStats)
+	return dcrStats
 }
@@ -0,0 +1,96 @@
+package internal
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// Headers sent with every signed payload, the gateway passes them to resources-sc that verifies the signature
+const (
+	PublicKeyHeader = "X-Drc-Public-Key"
+	NonceHeader     = "X-Drc-Nonce"
+	TimestampHeader = "X-Drc-Timestamp"
+	SignatureHeader = "X-Drc-Signature"
+)
+
+// SigningKey signs every post to the gateway, nil disables the signature
+var SigningKey ed25519.PrivateKey
+
+// LoadSigningKey reads an ed25519 private key (PKCS #8, PEM encoded) from path. If the file doesn't exist a new key is generated and saved,
+// its public key has to be added to the inventory asset of this node (Properties.PublicKey) before the gateway accepts the heartbeats.
+func LoadSigningKey(path string) (ed25519.PrivateKey, error) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("signing: %v", err)
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("signing: %v", err)
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return nil, fmt.Errorf("signing: %v", err)
+		}
+		if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+			return nil, fmt.Errorf("signing: %v", err)
+		}
+		return key, nil
+	}
+	return ReadSigningKey(path)
+}
+
+// ReadSigningKey reads the ed25519 private key at path like LoadSigningKey, without generating it when the file doesn't exist
+func ReadSigningKey(path string) (ed25519.PrivateKey, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("signing: %v", err)
+	}
+
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, fmt.Errorf("signing: %s is not a PEM file", path)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("signing: %v", err)
+	}
+	key, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("signing: %s is not an ed25519 key", path)
+	}
+	return key, nil
+}
+
+// PublicKeyString returns the public key as it's stored in the inventory (base64)
+func PublicKeyString(key ed25519.PrivateKey) string {
+	return base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey))
+}
+
+// SignPayload adds the signature headers to the request. The signed message is the body followed by a random nonce and the unix time:
+// payload + "\n" + nonce + "\n" + timestamp. The body must be sent exactly as it was signed.
+func SignPayload(key ed25519.PrivateKey, header http.Header, payload []byte) error {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return fmt.Errorf("signing: %v", err)
+	}
+	nonce := hex.EncodeToString(random)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	message := append(append([]byte{}, payload...), []byte("\n"+nonce+"\n"+timestamp)...)
+
+	header.Set(PublicKeyHeader, PublicKeyString(key))
+	header.Set(NonceHeader, nonce)
+	header.Set(TimestampHeader, timestamp)
+	header.Set(SignatureHeader, base64.StdEncoding.EncodeToString(ed25519.Sign(key, message)))
+	return nil
+}
@@ -0,0 +1,334 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// -- SINKS
+// Sinks receive the payloads of the jobs (heartbeats, latency and bandwidth results). Every job sends its payloads to the sinks listed
+// in <JOB>_SINKS (SINKS by default, "http"): http posts them to the gateway through the forward queue, jsonl writes them to rotating
+// files, stdout prints them and mqtt publishes them to a broker. Without http the DRC runs without a gateway.
+type Sink interface {
+	Send(payload Payload) error
+	Close() error
+}
+
+// Payload of a job. URL is the gateway endpoint of the job, only used by the http sink.
+type Payload struct {
+	Job       string
+	URL       string
+	Timestamp time.Time
+	Body      []byte
+}
+
+var sinkNames = []string{"http", "jsonl", "stdout", "mqtt"}
+
+// SinkGroup sends the payloads of a job to every sink of the group
+type SinkGroup struct {
+	names []string
+	sinks []Sink
+}
+
+// Send sends the payload to every sink, a failed sink doesn't stop the rest. The errors of every sink are returned together.
+func (g SinkGroup) Send(payload Payload) error {
+	failures := []string{}
+	for i, sink := range g.sinks {
+		err := sink.Send(payload)
+		observeSink(g.names[i], payload.Job, err)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", g.names[i], err))
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("sink: %s", strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+func (g SinkGroup) Names() []string {
+	return g.names
+}
+
+// Sinks creates every sink the first time a job uses it and shares it between the jobs. The settings of a sink are read when
+// it's created, a config reload only changes the sinks of every job.
+type Sinks struct {
+	queue *ForwardQueue
+	mu    sync.Mutex
+	sinks map[string]Sink
+}
+
+// NewSinks creates the sinks of the DRC, the http sink posts through queue
+func NewSinks(queue *ForwardQueue) *Sinks {
+	return &Sinks{queue: queue, sinks: map[string]Sink{}}
+}
+
+// Group returns the sinks named in list (comma separated), creating the ones no job used before
+func (s *Sinks) Group(list string) (SinkGroup, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	group := SinkGroup{}
+	if len(SplitList(list)) == 0 {
+		return SinkGroup{}, fmt.Errorf("sink: no sinks, disable the job with its schedule instead")
+	}
+	for _, name := range UniqueString(SplitList(list)) {
+		sink, ok := s.sinks[name]
+		if !ok {
+			var err error
+			if sink, err = s.newSinkFromEnv(name); err != nil {
+				return SinkGroup{}, err
+			}
+			s.sinks[name] = sink
+		}
+		group.names = append(group.names, name)
+		group.sinks = append(group.sinks, sink)
+	}
+	return group, nil
+}
+
+// Close closes every sink
+func (s *Sinks) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name, sink := range s.sinks {
+		if err := sink.Close(); err != nil {
+			fmt.Println("sink:", name, err)
+		}
+	}
+	s.sinks = map[string]Sink{}
+}
+
+func (s *Sinks) newSinkFromEnv(name string) (Sink, error) {
+	switch name {
+	case "http":
+		if s.queue == nil {
+			return nil, fmt.Errorf("sink: http needs the forward queue")
+		}
+		return &HTTPSink{Queue: s.queue}, nil
+	case "jsonl":
+		return NewJSONLSinkFromEnv()
+	case "stdout":
+		return &StdoutSink{}, nil
+	case "mqtt":
+		return NewMQTTSinkFromEnv()
+	default:
+		return nil, fmt.Errorf("sink: unknown sink %s, available sinks are %s", name, strings.Join(sinkNames, ", "))
+	}
+}
+
+// -- HTTP
+// HTTPSink posts the payloads to the gateway, payloads that can't be posted are queued and replayed
+type HTTPSink struct {
+	Queue *ForwardQueue
+}
+
+func (h *HTTPSink) Send(payload Payload) error {
+	return h.Queue.Send(payload.URL, payload.Timestamp, payload.Body)
+}
+
+func (h *HTTPSink) Close() error {
+	return nil
+}
+
+// -- STDOUT
+// StdoutSink prints every payload as a JSON line: {"job": ..., "payload": ...}
+type StdoutSink struct {
+	mu sync.Mutex
+}
+
+func (s *StdoutSink) Send(payload Payload) error {
+	line, err := json.Marshal(struct {
+		Job     string          `json:"job"`
+		Payload json.RawMessage `json:"payload"`
+	}{payload.Job, payload.Body})
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = fmt.Fprintln(os.Stdout, string(line))
+	return err
+}
+
+func (s *StdoutSink) Close() error {
+	return nil
+}
+
+// -- JSONL
+// JSONLSink appends the payloads of every job to Dir/<job>.jsonl, one payload per line. Dir is created by the first payload. A file that reaches MaxSize is renamed
+// to <job>-<time>.jsonl and only the last MaxFiles rotated files of every job are kept.
+type JSONLSink struct {
+	Dir      string
+	MaxSize  int64
+	MaxFiles int
+	mu       sync.Mutex
+}
+
+func NewJSONLSink(dir string, maxSize int64, maxFiles int) (*JSONLSink, error) {
+	if maxSize <= 0 || maxFiles < 0 {
+		return nil, fmt.Errorf("sink: jsonl max size must be positive and max files can't be negative")
+	}
+	if info, err := os.Stat(dir); err == nil && !info.IsDir() {
+		return nil, fmt.Errorf("sink: %s is not a directory", dir)
+	}
+	return &JSONLSink{Dir: dir, MaxSize: maxSize, MaxFiles: maxFiles}, nil
+}
+
+// NewJSONLSinkFromEnv creates the sink from SINK_JSONL_DIR ("data"), SINK_JSONL_MAX_SIZE (10, MB) and SINK_JSONL_MAX_FILES (5)
+func NewJSONLSinkFromEnv() (*JSONLSink, error) {
+	maxSize, err := strconv.ParseInt(GetEnv("SINK_JSONL_MAX_SIZE", "10"), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("sink: SINK_JSONL_MAX_SIZE: %v", err)
+	}
+	maxFiles, err := strconv.Atoi(GetEnv("SINK_JSONL_MAX_FILES", "5"))
+	if err != nil {
+		return nil, fmt.Errorf("sink: SINK_JSONL_MAX_FILES: %v", err)
+	}
+	return NewJSONLSink(GetEnv("SINK_JSONL_DIR", "data"), maxSize*1024*1024, maxFiles)
+}
+
+func (j *JSONLSink) Send(payload Payload) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	file := filepath.Join(j.Dir, payload.Job+".jsonl")
+	line := append(append([]byte{}, payload.Body...), '\n')
+	if err := os.MkdirAll(j.Dir, 0755); err != nil {
+		return err
+	}
+	if info, err := os.Stat(file); err == nil && info.Size() > 0 && info.Size()+int64(len(line)) > j.MaxSize {
+		if err := j.rotate(payload.Job, file); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(line); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Renames the full file and removes the oldest rotated files, the names sort by time. Files rotated at the same time (fast
+// writers on a coarse clock) get the next nanosecond, a rename never replaces a rotated file.
+func (j *JSONLSink) rotate(job string, file string) error {
+	at := time.Now().UTC()
+	var rotated string
+	for {
+		rotated = filepath.Join(j.Dir, job+"-"+at.Format("20060102T150405.000000000")+".jsonl")
+		if _, err := os.Stat(rotated); err != nil {
+			break
+		}
+		at = at.Add(time.Nanosecond)
+	}
+	if err := os.Rename(file, rotated); err != nil {
+		return err
+	}
+	files, err := filepath.Glob(filepath.Join(j.Dir, job+"-*.jsonl"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+	for len(files) > j.MaxFiles {
+		if err := os.Remove(files[0]); err != nil {
+			return err
+		}
+		files = files[1:]
+	}
+	return nil
+}
+
+func (j *JSONLSink) Close() error {
+	return nil
+}
+
+// -- MQTT
+// MQTTSink publishes the payloads to <Topic>/<hostname>/<job>. The client reconnects on its own, payloads published while the
+// broker is down fail after Timeout and aren't queued.
+type MQTTSink struct {
+	Topic   string
+	QoS     byte
+	Retain  bool
+	Timeout time.Duration
+	client  mqtt.Client
+}
+
+// NewMQTTSink connects to the broker (tcp://host:1883, ssl://host:8883 or ws://host/path). If the broker can't be reached the
+// client keeps trying in the background.
+func NewMQTTSink(broker string, clientID string, username string, password string, topic string, qos byte, retain bool, timeout time.Duration) (*MQTTSink, error) {
+	if qos > 2 {
+		return nil, fmt.Errorf("sink: mqtt qos must be 0, 1 or 2")
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("sink: %v", err)
+	}
+	if clientID == "" {
+		clientID = "drc-" + hostname
+	}
+	options := mqtt.NewClientOptions().
+		AddBroker(broker).
+		SetClientID(clientID).
+		SetUsername(username).
+		SetPassword(password).
+		SetConnectTimeout(timeout).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			fmt.Println("sink: mqtt connection lost:", err)
+		})
+	sink := &MQTTSink{
+		Topic:   strings.TrimSuffix(topic, "/") + "/" + hostname,
+		QoS:     qos,
+		Retain:  retain,
+		Timeout: timeout,
+		client:  mqtt.NewClient(options),
+	}
+	if token := sink.client.Connect(); token.WaitTimeout(timeout) && token.Error() != nil {
+		return nil, fmt.Errorf("sink: mqtt: %v", token.Error())
+	}
+	return sink, nil
+}
+
+// NewMQTTSinkFromEnv creates the sink from SINK_MQTT_BROKER (tcp://localhost:1883), SINK_MQTT_CLIENT_ID (drc-<hostname>),
+// SINK_MQTT_USERNAME, SINK_MQTT_PASSWORD, SINK_MQTT_TOPIC (drc), SINK_MQTT_QOS (1), SINK_MQTT_RETAIN (false) and SINK_MQTT_TIMEOUT (10s)
+func NewMQTTSinkFromEnv() (*MQTTSink, error) {
+	qos, err := strconv.ParseUint(GetEnv("SINK_MQTT_QOS", "1"), 10, 8)
+	if err != nil {
+		return nil, fmt.Errorf("sink: SINK_MQTT_QOS: %v", err)
+	}
+	retain, err := strconv.ParseBool(GetEnv("SINK_MQTT_RETAIN", "false"))
+	if err != nil {
+		return nil, fmt.Errorf("sink: SINK_MQTT_RETAIN: %v", err)
+	}
+	timeout, err := time.ParseDuration(GetEnv("SINK_MQTT_TIMEOUT", "10s"))
+	if err != nil {
+		return nil, fmt.Errorf("sink: SINK_MQTT_TIMEOUT: %v", err)
+	}
+	return NewMQTTSink(GetEnv("SINK_MQTT_BROKER", "tcp://localhost:1883"), GetEnv("SINK_MQTT_CLIENT_ID", ""),
+		GetEnv("SINK_MQTT_USERNAME", ""), GetEnv("SINK_MQTT_PASSWORD", ""), GetEnv("SINK_MQTT_TOPIC", "drc"), byte(qos), retain, timeout)
+}
+
+func (m *MQTTSink) Send(payload Payload) error {
+	token := m.client.Publish(m.Topic+"/"+payload.Job, m.QoS, m.Retain, payload.Body)
+	if !token.WaitTimeout(m.Timeout) {
+		return fmt.Errorf("publish timed out after %s", m.Timeout)
+	}
+	return token.Error()
+}
+
+func (m *MQTTSink) Close() error {
+	m.client.Disconnect(250)
+	return nil
+}
@@ -0,0 +1,166 @@
+package internal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Message received by the test broker
+type brokerMessage struct {
+	topic   string
+	payload string
+}
+
+// Starts a minimal MQTT 3.1.1 broker on a random local port and returns its URL. It accepts every connection and sends
+// every PUBLISH to messages, acknowledging QoS 1. Subscriptions aren't supported, the sink only publishes.
+func startBroker(t *testing.T, messages chan<- brokerMessage) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveBrokerConn(conn, messages)
+		}
+	}()
+	return "tcp://" + listener.Addr().String()
+}
+
+func serveBrokerConn(conn net.Conn, messages chan<- brokerMessage) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		header, err := reader.ReadByte()
+		if err != nil {
+			return
+		}
+		// Remaining length, 7 bits per byte
+		length, multiplier := 0, 1
+		for {
+			b, err := reader.ReadByte()
+			if err != nil {
+				return
+			}
+			length += int(b&127) * multiplier
+			multiplier *= 128
+			if b&128 == 0 {
+				break
+			}
+		}
+		body := make([]byte, length)
+		if _, err := io.ReadFull(reader, body); err != nil {
+			return
+		}
+
+		switch header >> 4 {
+		case 1: // CONNECT, accepted
+			conn.Write([]byte{0x20, 0x02, 0x00, 0x00})
+		case 3: // PUBLISH
+			topicLength := int(binary.BigEndian.Uint16(body))
+			topic, rest := string(body[2:2+topicLength]), body[2+topicLength:]
+			if qos := (header >> 1) & 3; qos > 0 {
+				conn.Write([]byte{0x40, 0x02, rest[0], rest[1]})
+				rest = rest[2:]
+			}
+			messages <- brokerMessage{topic: topic, payload: string(rest)}
+		case 12: // PINGREQ
+			conn.Write([]byte{0xd0, 0x00})
+		case 14: // DISCONNECT
+			return
+		}
+	}
+}
+
+func TestMQTTSink(t *testing.T) {
+	received := make(chan brokerMessage, 1)
+	broker := startBroker(t, received)
+
+	sink, err := NewMQTTSink(broker, "drc-test", "", "", "drc/", 1, false, 5*time.Second)
+	if err != nil {
+		t.Fatalf("NewMQTTSink() error = %v", err)
+	}
+	defer sink.Close()
+	body := `{"timestamp":1678789267}`
+	if err := sink.Send(Payload{Job: "heartbeat", Body: []byte(body)}); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	hostname, _ := os.Hostname()
+	select {
+	case message := <-received:
+		if message.topic != "drc/"+hostname+"/heartbeat" {
+			t.Errorf("topic = %s, want drc/%s/heartbeat", message.topic, hostname)
+		}
+		if message.payload != body {
+			t.Errorf("payload = %s, want %s", message.payload, body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the broker didn't receive the payload")
+	}
+}
+
+func TestJSONLSinkRotation(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "data")
+	// Every line is 20 bytes, 3 lines fit in a file
+	sink, err := NewJSONLSink(dir, 60, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		line := strings.Repeat(string(rune('0'+i)), 19)
+		if err := sink.Send(Payload{Job: "latency", Body: []byte(line)}); err != nil {
+			t.Fatalf("Send() error = %v", err)
+		}
+	}
+
+	rotated, err := filepath.Glob(filepath.Join(dir, "latency-*.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rotated) != 2 {
+		t.Fatalf("%d rotated files, want 2: %v", len(rotated), rotated)
+	}
+	// The oldest files were removed, the last 2 rotated files and the current file hold the last 7 lines in order
+	lines := []string{}
+	for _, file := range append(rotated, filepath.Join(dir, "latency.jsonl")) {
+		info, err := os.Stat(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() > 60 {
+			t.Errorf("%s has %d bytes, more than the max size", file, info.Size())
+		}
+		lines = append(lines, readLines(t, file)...)
+	}
+	if len(lines) != 7 || lines[0][0] != '3' || lines[6][0] != '9' {
+		t.Errorf("lines = %v, want the lines 3 to 9", lines)
+	}
+}
+
+func readLines(t *testing.T, file string) []string {
+	t.Helper()
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	lines := []string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines
+}
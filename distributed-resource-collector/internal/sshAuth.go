@@ -0,0 +1,228 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// -- SSH AUTHENTICATION
+// SSHOptions is the authentication used by the latency probes. Host keys are always verified: against the key of the target (LatencyTarget.HostKey)
+// when the inventory has one, against the known_hosts files otherwise. Targets without a known host key are not probed.
+// Password authentication (LatencyTarget.HostPassword) is only used when AllowPassword is set.
+type SSHOptions struct {
+	KeyFiles      []string
+	KnownHosts    []string
+	AgentSocket   string
+	AllowPassword bool
+	Timeout       time.Duration
+	signers       []ssh.Signer
+	knownHosts    ssh.HostKeyCallback
+}
+
+// NewSSHOptions loads the private keys (PEM or OpenSSH format, without passphrase) and the known_hosts files.
+// agentSocket is the ssh-agent socket, empty to disable the agent.
+func NewSSHOptions(keyFiles []string, knownHostsFiles []string, agentSocket string, allowPassword bool, timeout time.Duration) (*SSHOptions, error) {
+	options := &SSHOptions{
+		KeyFiles:      keyFiles,
+		KnownHosts:    knownHostsFiles,
+		AgentSocket:   agentSocket,
+		AllowPassword: allowPassword,
+		Timeout:       timeout,
+	}
+	for _, file := range keyFiles {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("ssh: %v", err)
+		}
+		signer, err := ssh.ParsePrivateKey(content)
+		if err != nil {
+			return nil, fmt.Errorf("ssh: %s: %v", file, err)
+		}
+		options.signers = append(options.signers, signer)
+	}
+	if len(knownHostsFiles) > 0 {
+		callback, err := knownhosts.New(knownHostsFiles...)
+		if err != nil {
+			return nil, fmt.Errorf("ssh: %v", err)
+		}
+		options.knownHosts = callback
+	}
+	return options, nil
+}
+
+// NewSSHOptionsFromEnv creates the options from SSH_KEY (comma separated private keys, ~/.ssh/id_ed25519 and ~/.ssh/id_rsa if they exist),
+// SSH_KNOWN_HOSTS (comma separated, ~/.ssh/known_hosts if it exists), SSH_AGENT (true, uses SSH_AUTH_SOCK), SSH_ALLOW_PASSWORD (false) and SSH_TIMEOUT (5s)
+func NewSSHOptionsFromEnv() (*SSHOptions, error) {
+	home, _ := os.UserHomeDir()
+	keyFiles := SplitList(GetEnv("SSH_KEY", ""))
+	if len(keyFiles) == 0 && home != "" {
+		keyFiles = existingFiles(filepath.Join(home, ".ssh", "id_ed25519"), filepath.Join(home, ".ssh", "id_rsa"))
+	}
+	knownHostsFiles := SplitList(GetEnv("SSH_KNOWN_HOSTS", ""))
+	if len(knownHostsFiles) == 0 && home != "" {
+		knownHostsFiles = existingFiles(filepath.Join(home, ".ssh", "known_hosts"))
+	}
+
+	useAgent, err := strconv.ParseBool(GetEnv("SSH_AGENT", "true"))
+	if err != nil {
+		return nil, fmt.Errorf("ssh: SSH_AGENT: %v", err)
+	}
+	agentSocket := ""
+	if useAgent {
+		agentSocket = os.Getenv("SSH_AUTH_SOCK")
+	}
+	allowPassword, err := strconv.ParseBool(GetEnv("SSH_ALLOW_PASSWORD", "false"))
+	if err != nil {
+		return nil, fmt.Errorf("ssh: SSH_ALLOW_PASSWORD: %v", err)
+	}
+	timeout, err := time.ParseDuration(GetEnv("SSH_TIMEOUT", "5s"))
+	if err != nil {
+		return nil, fmt.Errorf("ssh: SSH_TIMEOUT: %v", err)
+	}
+	return NewSSHOptions(keyFiles, knownHostsFiles, agentSocket, allowPassword, timeout)
+}
+
+// ClientConfig returns the SSH configuration for the target. The returned function closes the connection to the agent, it must be called
+// once the SSH connection is closed.
+func (o *SSHOptions) ClientConfig(target LatencyTarget) (*ssh.ClientConfig, func(), error) {
+	hostKeyCallback, err := o.hostKeyCallback(target)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	closer := func() {}
+	auth := []ssh.AuthMethod{}
+	if len(o.signers) > 0 {
+		auth = append(auth, ssh.PublicKeys(o.signers...))
+	}
+	if o.AgentSocket != "" {
+		conn, err := net.DialTimeout("unix", o.AgentSocket, o.Timeout)
+		if err == nil {
+			auth = append(auth, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+			closer = func() { conn.Close() }
+		} else {
+			fmt.Printf("ssh: agent not available: %v\n", err)
+		}
+	}
+	if o.AllowPassword && target.HostPassword != "" {
+		auth = append(auth, ssh.Password(target.HostPassword))
+	}
+	if len(auth) == 0 {
+		closer()
+		return nil, nil, fmt.Errorf("ssh: no authentication method for %s, configure SSH_KEY or SSH_AGENT", target.Hostname)
+	}
+
+	config := &ssh.ClientConfig{
+		User:              target.HostUser,
+		Auth:              auth,
+		HostKeyCallback:   hostKeyCallback,
+		HostKeyAlgorithms: o.hostKeyAlgorithms(target),
+		Timeout:           o.Timeout,
+	}
+	return config, closer, nil
+}
+
+// The host key of the target (SHA256 fingerprint, as printed by ssh-keygen -l, or public key in authorized_keys format) takes precedence
+// over the known_hosts files
+func (o *SSHOptions) hostKeyCallback(target LatencyTarget) (ssh.HostKeyCallback, error) {
+	if target.HostKey != "" {
+		return HostKeyCallback(target.HostKey)
+	}
+	if o.knownHosts != nil {
+		return o.knownHosts, nil
+	}
+	return nil, fmt.Errorf("ssh: no host key for %s, add it to the inventory (hostKey) or to SSH_KNOWN_HOSTS", target.Hostname)
+}
+
+// The host key algorithms offered to the server are the ones of the expected key, otherwise a server with several host keys can present
+// a key of another type and the verification fails. Nil (every algorithm) when only the fingerprint of the key is known.
+func (o *SSHOptions) hostKeyAlgorithms(target LatencyTarget) []string {
+	keys := []ssh.PublicKey{}
+	if target.HostKey != "" {
+		if key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(strings.TrimSpace(target.HostKey))); err == nil {
+			keys = append(keys, key)
+		}
+	} else if o.knownHosts != nil {
+		keys = o.knownHostKeys(sshAddress(target))
+	}
+
+	var algorithms []string
+	for _, key := range keys {
+		for _, algorithm := range keyAlgorithms(key.Type()) {
+			if !StringInSlice(algorithm, algorithms) {
+				algorithms = append(algorithms, algorithm)
+			}
+		}
+	}
+	return algorithms
+}
+
+// Returns the keys of the address in the known_hosts files. knownhosts only exposes them in the error of a key it doesn't know.
+func (o *SSHOptions) knownHostKeys(address string) []ssh.PublicKey {
+	unknown, err := ssh.NewPublicKey(ed25519.PublicKey(make([]byte, ed25519.PublicKeySize)))
+	if err != nil {
+		return nil
+	}
+	keys := []ssh.PublicKey{}
+	var keyErr *knownhosts.KeyError
+	if err := o.knownHosts(address, &net.TCPAddr{IP: net.IPv4zero}, unknown); errors.As(err, &keyErr) {
+		for _, known := range keyErr.Want {
+			keys = append(keys, known.Key)
+		}
+	}
+	return keys
+}
+
+// RSA keys sign with SHA-2 on current servers, ssh-rsa (SHA-1) is kept for older ones
+func keyAlgorithms(keyType string) []string {
+	if keyType == ssh.KeyAlgoRSA {
+		return []string{ssh.SigAlgoRSASHA2512, ssh.SigAlgoRSASHA2256, ssh.SigAlgoRSA}
+	}
+	return []string{keyType}
+}
+
+// HostKeyCallback accepts only the host key given as a SHA256 fingerprint ("SHA256:...") or as a public key in authorized_keys format
+func HostKeyCallback(hostKey string) (ssh.HostKeyCallback, error) {
+	hostKey = strings.TrimSpace(hostKey)
+	if strings.HasPrefix(hostKey, "SHA256:") {
+		return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			if fingerprint := ssh.FingerprintSHA256(key); fingerprint != hostKey {
+				return fmt.Errorf("ssh: host key mismatch for %s, expected %s and got %s", hostname, hostKey, fingerprint)
+			}
+			return nil
+		}, nil
+	}
+
+	expected, _, _, _, err := ssh.ParseAuthorizedKey([]byte(hostKey))
+	if err != nil {
+		return nil, fmt.Errorf("ssh: invalid host key %q: %v", hostKey, err)
+	}
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		if !bytes.Equal(key.Marshal(), expected.Marshal()) {
+			return fmt.Errorf("ssh: host key mismatch for %s, expected %s and got %s", hostname, ssh.FingerprintSHA256(expected), ssh.FingerprintSHA256(key))
+		}
+		return nil
+	}, nil
+}
+
+func existingFiles(files ...string) (existing []string) {
+	for _, file := range files {
+		if _, err := os.Stat(file); err == nil {
+			existing = append(existing, file)
+		}
+	}
+	return existing
+}
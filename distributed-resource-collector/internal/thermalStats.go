@@ -0,0 +1,263 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shirou/gopsutil/host"
+)
+
+// Flags reported by "vcgencmd get_throttled" on Raspberry Pi boards, only the bits for the current state are used
+var vcgencmdThrottledFlags = map[uint64]string{
+	0x1: "under-voltage",
+	0x2: "frequency-capped",
+	0x4: "throttled",
+	0x8: "soft-temperature-limit",
+}
+
+// GetThermalSensors merges the hwmon sensors reported by GO-PsUtil with the kernel thermal zones.
+// GO-PsUtil reports every hwmon file (input, max, crit...) as a separate sensor, only the inputs are kept and the crit values are used as critical temperature.
+func GetThermalSensors() []DrcThermalSensor {
+	sensors := map[string]*DrcThermalSensor{}
+	critical := map[string]float64{}
+
+	temperatures, _ := host.SensorsTemperatures()
+	for _, temperature := range temperatures {
+		if strings.HasSuffix(temperature.SensorKey, "input") {
+			name := strings.TrimSuffix(strings.TrimSuffix(temperature.SensorKey, "input"), "_")
+			sensors[name] = &DrcThermalSensor{Name: name, Temperature: temperature.Temperature}
+		} else if strings.HasSuffix(temperature.SensorKey, "crit") {
+			critical[strings.TrimSuffix(strings.TrimSuffix(temperature.SensorKey, "crit"), "_")] = temperature.Temperature
+		}
+	}
+	for name, value := range critical {
+		if sensor, ok := sensors[name]; ok {
+			sensor.Critical = value
+		}
+	}
+
+	for _, zone := range readThermalZones() {
+		if _, ok := sensors[zone.sensor.Name]; !ok {
+			sensor := zone.sensor
+			sensors[sensor.Name] = &sensor
+		}
+	}
+
+	thermalSensors := []DrcThermalSensor{}
+	for _, sensor := range sensors {
+		thermalSensors = append(thermalSensors, *sensor)
+	}
+	sort.Slice(thermalSensors, func(i, j int) bool {
+		return thermalSensors[i].Name < thermalSensors[j].Name
+	})
+	return thermalSensors
+}
+
+type thermalZone struct {
+	sensor  DrcThermalSensor
+	passive float64 // Temperature at which the kernel starts throttling the zone, 0 if the zone has no passive trip point
+}
+
+// Reads /sys/class/thermal/thermal_zone*, temperatures are in millidegrees Celsius
+func readThermalZones() (zones []thermalZone) {
+	paths, _ := filepath.Glob("/sys/class/thermal/thermal_zone*")
+	for _, path := range paths {
+		name, err := ioutil.ReadFile(filepath.Join(path, "type"))
+		if err != nil {
+			continue
+		}
+		temperature, err := readMilli(filepath.Join(path, "temp"))
+		if err != nil {
+			continue
+		}
+		zone := thermalZone{sensor: DrcThermalSensor{Name: strings.TrimSpace(string(name)), Temperature: temperature}}
+
+		trips, _ := filepath.Glob(filepath.Join(path, "trip_point_*_type"))
+		for _, trip := range trips {
+			tripType, err := ioutil.ReadFile(trip)
+			if err != nil {
+				continue
+			}
+			tripTemp, err := readMilli(strings.TrimSuffix(trip, "_type") + "_temp")
+			if err != nil || tripTemp <= 0 {
+				continue
+			}
+			switch strings.TrimSpace(string(tripType)) {
+			case "critical":
+				zone.sensor.Critical = tripTemp
+			case "passive":
+				if zone.passive == 0 || tripTemp < zone.passive {
+					zone.passive = tripTemp
+				}
+			}
+		}
+		zones = append(zones, zone)
+	}
+	return zones
+}
+
+func readMilli(path string) (float64, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	value, err := strconv.ParseFloat(strings.TrimSpace(string(content)), 64)
+	if err != nil {
+		return 0, err
+	}
+	return value / 1000, nil
+}
+
+// GetCPUFrequency returns the current frequency of every core and the highest maximum frequency, both in MHz.
+// cpufreq reports kHz. Systems without cpufreq (most VMs and containers without /sys) return no frequencies.
+func GetCPUFrequency() (coreFrequency []float64, maxFrequency float64) {
+	paths, _ := filepath.Glob("/sys/devices/system/cpu/cpu[0-9]*/cpufreq")
+	sort.Slice(paths, func(i, j int) bool {
+		return cpuNumber(paths[i]) < cpuNumber(paths[j])
+	})
+	for _, path := range paths {
+		current, err := readMilli(filepath.Join(path, "scaling_cur_freq"))
+		if err != nil {
+			continue
+		}
+		coreFrequency = append(coreFrequency, current)
+		if max, err := readMilli(filepath.Join(path, "cpuinfo_max_freq")); err == nil && max > maxFrequency {
+			maxFrequency = max
+		}
+	}
+	return coreFrequency, maxFrequency
+}
+
+// ".../cpu12/cpufreq" -> 12, used to keep the cores in numeric order
+func cpuNumber(path string) int {
+	number, _ := strconv.Atoi(strings.TrimPrefix(filepath.Base(filepath.Dir(path)), "cpu"))
+	return number
+}
+
+// ParseVcgencmdThrottled reads the output of "vcgencmd get_throttled" (e.g. "throttled=0x50005") and returns the flags that are currently set
+func ParseVcgencmdThrottled(output string) ([]string, error) {
+	value := strings.TrimSpace(output)
+	value = strings.TrimPrefix(value, "throttled=")
+	bits, err := strconv.ParseUint(strings.TrimPrefix(value, "0x"), 16, 64)
+	if err != nil {
+		return nil, fmt.Errorf("vcgencmd: unexpected output %q", output)
+	}
+	flags := []string{}
+	for _, bit := range []uint64{0x1, 0x2, 0x4, 0x8} {
+		if bits&bit != 0 {
+			flags = append(flags, vcgencmdThrottledFlags[bit])
+		}
+	}
+	return flags, nil
+}
+
+// Sum of the Intel thermal throttle counters of every core, they only grow while the cores are being throttled
+func coreThrottleCount() (count uint64, ok bool) {
+	paths, _ := filepath.Glob("/sys/devices/system/cpu/cpu[0-9]*/thermal_throttle/core_throttle_count")
+	for _, path := range paths {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		value, err := strconv.ParseUint(strings.TrimSpace(string(content)), 10, 64)
+		if err != nil {
+			continue
+		}
+		count += value
+		ok = true
+	}
+	return count, ok
+}
+
+// -- COLLECTOR
+// Throttling is detected from "vcgencmd get_throttled" (Raspberry Pi), the Intel core throttle counters growing between samples,
+// and thermal zones above their passive trip point (the point where the kernel starts throttling, used by Jetson and most ARM boards).
+// Options: vcgencmd (path of the tool, "vcgencmd" by default, empty to disable it), timeout (2s by default)
+type thermalCollector struct {
+	mutex         sync.Mutex
+	vcgencmd      string
+	timeout       time.Duration
+	throttleCount uint64
+	hasCount      bool
+}
+
+func init() {
+	RegisterCollector(&thermalCollector{vcgencmd: "vcgencmd", timeout: 2 * time.Second})
+}
+
+func (c *thermalCollector) Name() string {
+	return "thermal"
+}
+
+func (c *thermalCollector) Configure(options map[string]string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if value, ok := options["vcgencmd"]; ok {
+		c.vcgencmd = value
+	}
+	if value, ok := options["timeout"]; ok {
+		timeout, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		c.timeout = timeout
+	}
+	return nil
+}
+
+func (c *thermalCollector) Collect(stats *DrcStats) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	thermalStats := DrcThermalStats{
+		Sensors:        GetThermalSensors(),
+		ThrottledFlags: []string{},
+	}
+	for _, sensor := range thermalStats.Sensors {
+		if sensor.Temperature > thermalStats.MaxTemperature {
+			thermalStats.MaxTemperature = sensor.Temperature
+		}
+	}
+	thermalStats.CoreFrequency, thermalStats.MaxFrequency = GetCPUFrequency()
+
+	if c.vcgencmd != "" {
+		if _, err := exec.LookPath(c.vcgencmd); err == nil {
+			ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+			output, err := exec.CommandContext(ctx, c.vcgencmd, "get_throttled").Output()
+			cancel()
+			// A failed vcgencmd (firmware or permission issue) doesn't lose the rest of the thermal stats
+			if err != nil {
+				CheckError(fmt.Errorf("vcgencmd: %v", err))
+			} else if flags, err := ParseVcgencmdThrottled(string(output)); err != nil {
+				CheckError(err)
+			} else {
+				thermalStats.ThrottledFlags = append(thermalStats.ThrottledFlags, flags...)
+			}
+		}
+	}
+
+	if count, ok := coreThrottleCount(); ok {
+		if c.hasCount && count > c.throttleCount {
+			thermalStats.ThrottledFlags = append(thermalStats.ThrottledFlags, "core-throttle")
+		}
+		c.throttleCount, c.hasCount = count, true
+	}
+
+	for _, zone := range readThermalZones() {
+		if zone.passive > 0 && zone.sensor.Temperature >= zone.passive {
+			thermalStats.ThrottledFlags = append(thermalStats.ThrottledFlags, "passive-trip:"+zone.sensor.Name)
+		}
+	}
+
+	thermalStats.Throttled = len(thermalStats.ThrottledFlags) > 0
+	stats.ThermalStats = thermalStats
+	return nil
+}
@@ -0,0 +1,44 @@
+package internal
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+// ConfigureGatewayTLS sets the TLS configuration of GatewayClient, used for heartbeats, target fetching and latency posting.
+// caFile verifies the gateway certificate (the system pool is used if empty), certFile and keyFile are the client certificate
+// presented to gateways that verify their clients. Every value is optional.
+func ConfigureGatewayTLS(caFile string, certFile string, keyFile string) error {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if caFile != "" {
+		caPEM, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return fmt.Errorf("tls: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return fmt.Errorf("tls: no certificates found in %s", caFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return fmt.Errorf("tls: the client certificate needs both TLS_CERT and TLS_KEY")
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return fmt.Errorf("tls: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	GatewayClient.Transport = transport
+	return nil
+}
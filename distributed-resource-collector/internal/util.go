@@ -11,11 +11,15 @@ import (
 )
 
 // GetEnv is a simple function that will give you a fallback value in case the environment variable is empty. Sort of a default option.
+// Without the environment variable the value of the config file is used (see LoadConfig).
 func GetEnv(key string, fallback string) string {
 	if value, ok := os.LookupEnv(key); ok && value != "" {
 		//fmt.Println(value)
 		return value
 	}
+	if value, ok := ConfigValue(key); ok && value != "" {
+		return value
+	}
 	return fallback
 }
 
@@ -67,6 +71,26 @@ func CustomContains(str string, subStrings ...string) bool {
 	return false
 }
 
+// Splits a comma separated list, trimming spaces and ignoring empty items
+func SplitList(list string) (items []string) {
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Return a boolean if the string is one of the items of the slice
+func StringInSlice(str string, slice []string) bool {
+	for _, item := range slice {
+		if item == str {
+			return true
+		}
+	}
+	return false
+}
+
 // There are multiple ways to check if we're running from inside a Docker Container, this default operation is good enough for what we need.
 func InDockerContainer() bool {
 	if _, err := os.Stat("/.dockerenv"); err == nil {
@@ -87,9 +111,10 @@ func GroupMiddleware(group *parallelizer.Group) gin.HandlerFunc {
 }
 
 // Adds every key and value in map to the gin context as middleware. Allows access to these variables from inside the handlers
-func EnviromentMiddleware(variables map[string]string) gin.HandlerFunc {
+// The variables are read on every request, so a config reload reaches the handlers without restarting the server
+func EnviromentMiddleware(variables *Enviroment) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		for key, value := range variables {
+		for key, value := range variables.Values() {
 			if key != "" && value != "" {
 				c.Set(key, value)
 				//c.Next()
@@ -0,0 +1,82 @@
+package pkg
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dmonteroh/distributed-resource-collector/internal"
+	"github.com/gin-gonic/gin"
+)
+
+func BandwidthEndpoint(c *gin.Context) {
+	defer internal.RecoverEndpoint(c)
+	execMode := c.MustGet("EXEC_MODE").(string)
+	targetsApp := c.MustGet("TARGETS_APP").(string)
+	options := c.MustGet("BANDWIDTH").(*internal.BandwidthOptions)
+	latencyTargets, err := FetchLatencyTargets(targetsApp)
+	if err != nil {
+		panic(err)
+	}
+	bandwidthResults := bandwidthHandler(execMode, latencyTargets, options)
+	c.JSON(200, bandwidthResults)
+}
+
+// Tests run one after the other, parallel transfers would share the link and measure each other
+func bandwidthHandler(execMode string, latencyTargets internal.LatencyTargets, options *internal.BandwidthOptions) internal.BandwidthResults {
+	bandwidthResults := internal.BandwidthResults{
+		Source:  latencyTargets.Source,
+		Results: []internal.BandwidthResult{},
+	}
+
+	for _, target := range latencyTargets.Targets {
+		if target.BandwidthPort == "" {
+			continue
+		}
+		result, err := internal.MeasureBandwidth(options, target)
+		if err != nil {
+			fmt.Println("bandwidth:", target.Hostname, err)
+		}
+		if execMode == "DEBUG" {
+			fmt.Println(result.String())
+		}
+		bandwidthResults.Results = append(bandwidthResults.Results, result)
+	}
+
+	internal.ObserveBandwidthRound(bandwidthResults)
+	tmpTime := time.Now()
+	bandwidthResults.Timestamp = internal.LatencyTimestamp{
+		TimeLocal:   tmpTime,
+		TimeSeconds: tmpTime.Unix(),
+		TimeNano:    tmpTime.UnixNano(),
+	}
+	return bandwidthResults
+}
+
+// Results go to the sinks of the job
+func sendBandwidth(targetUrl string, bandwidthUrl string, execMode string, options *internal.BandwidthOptions, sinks internal.SinkGroup) {
+	defer recoverHeartbeat()
+	latencyTargets, err := FetchLatencyTargets(targetUrl)
+	if err == nil {
+		bandwidthResults := bandwidthHandler(execMode, latencyTargets, options)
+		if len(bandwidthResults.Results) == 0 {
+			return
+		}
+		if execMode == "DEBUG" {
+			fmt.Println("DEUBG MODE - POST")
+			fmt.Println(bandwidthResults.String())
+		}
+		err := sinks.Send(internal.Payload{Job: "bandwidth", URL: bandwidthUrl, Timestamp: bandwidthResults.Timestamp.TimeLocal, Body: []byte(bandwidthResults.String())})
+		if err != nil {
+			panic(err)
+		}
+	} else {
+		fmt.Println(err)
+	}
+}
+
+// The bandwidth tests use the link for BANDWIDTH_DURATION per target, they run on their own (slower) schedule
+func BandwidthCron(scheduler *internal.Scheduler, schedule internal.Schedule, targetUrl string, bandwidthUrl string, execMode string, options *internal.BandwidthOptions, sinks internal.SinkGroup) error {
+	return scheduler.Add(schedule, func() {
+		sendBandwidth(targetUrl, bandwidthUrl, execMode, options, sinks)
+	})
+}
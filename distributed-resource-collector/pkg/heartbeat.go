@@ -1,13 +1,10 @@
 package pkg
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
-	"net/http"
 
 	"github.com/gin-gonic/gin"
-	"github.com/go-co-op/gocron"
 
 	"github.com/dmonteroh/distributed-resource-collector/internal"
 )
@@ -15,10 +12,15 @@ import (
 func HeartbeatEndpoint(c *gin.Context) {
 	defer internal.RecoverEndpoint(c)
 	execMode := c.MustGet("EXEC_MODE").(string)
+	stats := internal.GetServerStats()
+	if queue, ok := c.Get("QUEUE"); ok {
+		status := queue.(*internal.ForwardQueue).Status()
+		stats.Queue = &status
+	}
 	if execMode == "DEBUG" {
-		c.JSON(200, internal.GetServerStats().String())
+		c.JSON(200, stats.String())
 	} else {
-		c.JSON(200, internal.GetServerStats())
+		c.JSON(200, stats)
 	}
 }
 
@@ -30,57 +32,69 @@ func recoverHeartbeat() {
 			msg += err.(string)
 		case *json.SyntaxError:
 			msg += errType.Error()
+		case error:
+			msg += errType.Error()
 		default:
 		}
 		fmt.Println(msg)
 	}
 }
 
-func sendHeartbeat(url string, execMode string) {
+// Heartbeats go to the sinks of the job, the history keeps them locally
+func sendHeartbeat(url string, execMode string, sinks internal.SinkGroup, history *internal.History) {
 	defer recoverHeartbeat()
 	body := internal.GetServerStats()
 	if execMode == "DEBUG" {
 		fmt.Println(body.String())
 	}
-	res, err := http.Post(url, "application/json", bytes.NewBuffer([]byte(body.String())))
+	history.AddStats(body)
+	internal.ObserveStats(body)
+	err := sinks.Send(internal.Payload{Job: "heartbeat", URL: url, Timestamp: body.Timestamp.TimeLocal, Body: []byte(body.String())})
 	if err != nil {
 		panic(err)
 	}
+}
 
-	defer res.Body.Close()
+// Sends an out-of-band heartbeat, flagged with its events, when the watcher finds crossed thresholds. It goes to the heartbeat sinks.
+func sendEventHeartbeat(url string, execMode string, watcher *internal.EventWatcher, sinks internal.SinkGroup, history *internal.History) {
+	defer recoverHeartbeat()
+	events := watcher.Check()
+	if len(events) == 0 {
+		return
+	}
+	body := internal.GetServerStats()
+	body.Events = events
+	fmt.Println("EVENT HEARTBEAT:", internal.EventSummary(events))
+	if execMode == "DEBUG" {
+		fmt.Println(body.String())
+	}
+	history.AddStats(body)
+	internal.ObserveStats(body)
+	err := sinks.Send(internal.Payload{Job: "heartbeat", URL: url, Timestamp: body.Timestamp.TimeLocal, Body: []byte(body.String())})
+	if err != nil {
+		panic(err)
+	}
 }
 
-func recoverCron() {
-	if err := recover(); err != nil {
-		msg := "Error: [Recovered] "
-		switch errType := err.(type) {
-		case string:
-			msg += err.(string)
-		case *json.SyntaxError:
-			msg += errType.Error()
-		default:
-		}
+// PushHeartbeat posts one heartbeat straight to the gateway, without the queue, so the caller sees the error
+func PushHeartbeat(url string, execMode string) (internal.DrcStats, error) {
+	body := internal.GetServerStats()
+	if execMode == "DEBUG" {
+		fmt.Println(body.String())
 	}
+	return body, internal.PostJson(url, []byte(body.String()))
 }
 
-// This debugging job returns the amount of jobs that have run, and the time for the next expected run
-func debugCron(cronRes *gocron.Job) {
-	fmt.Println("RUN COUNT:", cronRes.RunCount())
-	fmt.Println("NEXT RUN: ", cronRes.NextRun())
+// HeartbeatCron sends the server stats to the sinks on the heartbeat schedule
+func HeartbeatCron(scheduler *internal.Scheduler, schedule internal.Schedule, app string, execMode string, sinks internal.SinkGroup, history *internal.History) error {
+	return scheduler.Add(schedule, func() {
+		sendHeartbeat(app, execMode, sinks, history)
+	})
 }
 
-func HeartbeatCron(cron *gocron.Scheduler, seconds int, app string, execMode string) {
-	defer recoverCron()
-	cronRes, cronErr := cron.Every(seconds).Seconds().Do(sendHeartbeat, app, execMode)
-	if cronErr != nil {
-		panic(cronErr)
-	}
-	go func() {
-		if execMode == "DEBUG" {
-			_, cronErrDebug := cron.Every(seconds).Seconds().Do(debugCron, cronRes)
-			if cronErrDebug != nil {
-				panic(cronErr)
-			}
-		}
-	}()
+// EventCron samples the node on the event schedule and sends the event heartbeats
+func EventCron(scheduler *internal.Scheduler, schedule internal.Schedule, app string, execMode string, watcher *internal.EventWatcher, sinks internal.SinkGroup, history *internal.History) error {
+	return scheduler.Add(schedule, func() {
+		sendEventHeartbeat(app, execMode, watcher, sinks, history)
+	})
 }
@@ -0,0 +1,52 @@
+package pkg
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/dmonteroh/distributed-resource-collector/internal"
+)
+
+// HistoryEndpoint returns the recent heartbeats and probe rounds kept by the DRC (step=raw, the default) or their rollups (step=1m or 5m).
+// from and to are unix seconds, RFC3339 times or durations back from now ("30m" and "-30m" are both half an hour ago), by default the whole history is returned.
+func HistoryEndpoint(c *gin.Context) {
+	defer internal.RecoverEndpoint(c)
+	history := c.MustGet("HISTORY").(*internal.History)
+	now := time.Now()
+	from, err := parseHistoryTime(c.Query("from"), time.Time{}, now)
+	if err != nil {
+		panic(err)
+	}
+	to, err := parseHistoryTime(c.Query("to"), now, now)
+	if err != nil {
+		panic(err)
+	}
+	step := c.DefaultQuery("step", "raw")
+	response, err := history.Query(from, to, step)
+	if err != nil {
+		panic(err)
+	}
+	c.JSON(200, response)
+}
+
+func parseHistoryTime(value string, fallback time.Time, now time.Time) (time.Time, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if duration, err := time.ParseDuration(value); err == nil {
+		if duration > 0 {
+			duration = -duration
+		}
+		return now.Add(duration), nil
+	}
+	return time.Time{}, fmt.Errorf("history: invalid time %s, expected unix seconds, RFC3339 or a duration", value)
+}
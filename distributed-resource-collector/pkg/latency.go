@@ -1,57 +1,53 @@
 package pkg
 
 import (
-	"bytes"
+	"context"
 	"errors"
 	"fmt"
-	"io"
 	"io/ioutil"
-	"net/http"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
 
-	"golang.org/x/crypto/ssh"
-
 	"github.com/dmonteroh/distributed-resource-collector/internal"
 	"github.com/gin-gonic/gin"
-	"github.com/go-co-op/gocron"
 )
 
 func LatencyEndpoint(c *gin.Context) {
 	defer internal.RecoverEndpoint(c)
 	execMode := c.MustGet("EXEC_MODE").(string)
 	targetsApp := c.MustGet("TARGETS_APP").(string)
-	latencyTargets, err := latencyTargetsHandler(targetsApp)
+	probers := c.MustGet("PROBERS").(*internal.Probers)
+	latencyTargets, err := FetchLatencyTargets(targetsApp)
 	if err != nil {
 		panic(err)
 	}
-	latencyHandler(execMode, latencyTargets)
+	ProbeTargets(c.Request.Context(), execMode, latencyTargets, probers)
 }
 
 func ManualLatencyEndpoint(c *gin.Context) {
 	defer internal.RecoverEndpoint(c)
 	execMode := c.MustGet("EXEC_MODE").(string)
+	probers := c.MustGet("PROBERS").(*internal.Probers)
 	jsonData, _ := ioutil.ReadAll(c.Request.Body)
 	latencyTargets, err := internal.LatencyTargetsJsonToStruct(string(jsonData))
 	if err != nil {
 		panic(err)
 	}
-	latencyResults := latencyHandler(execMode, latencyTargets)
+	// The probes are cancelled when the client disconnects
+	latencyResults := ProbeTargets(c.Request.Context(), execMode, latencyTargets, probers)
 	c.JSON(200, latencyResults)
 }
 
-func latencyTargetsHandler(url string) (internal.LatencyTargets, error) {
-	res, err := http.Get(url)
+// FetchLatencyTargets gets the targets of the DRC from the gateway (TARGETS_APP), or from a file:// url without a gateway
+func FetchLatencyTargets(url string) (internal.LatencyTargets, error) {
+	jsonData, err := readLatencyTargets(url)
 	if err != nil {
-		panic(err)
+		return internal.LatencyTargets{}, err
 	}
-	defer res.Body.Close()
-	jsonData, _ := ioutil.ReadAll(res.Body)
 	latencyTargets, err := internal.LatencyTargetsJsonToStruct(string(jsonData))
 	if err != nil {
-		panic(err)
+		return internal.LatencyTargets{}, fmt.Errorf("latency: invalid targets: %v", err)
 	}
 
 	//VALIDATIONS
@@ -66,34 +62,78 @@ func latencyTargetsHandler(url string) (internal.LatencyTargets, error) {
 	return latencyTargets, err
 }
 
-func latencyHandler(execMode string, latencyTargets internal.LatencyTargets) internal.LatencyResults {
-	// latencyGroup := parallelizer.NewGroup(parallelizer.WithPoolSize(12), parallelizer.WithJobQueueSize(3))
-	// defer latencyGroup.Close()
+func readLatencyTargets(url string) ([]byte, error) {
+	if strings.HasPrefix(url, "file://") {
+		jsonData, err := ioutil.ReadFile(strings.TrimPrefix(url, "file://"))
+		if err != nil {
+			return nil, fmt.Errorf("latency: %v", err)
+		}
+		return jsonData, nil
+	}
+	res, err := internal.GatewayClient.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("latency: %v", err)
+	}
+	defer res.Body.Close()
+	jsonData, _ := ioutil.ReadAll(res.Body)
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return nil, fmt.Errorf("latency: GET %s returned %s", url, res.Status)
+	}
+	return jsonData, nil
+}
+
+// ProbeTargets runs one latency round over the targets, used by the endpoints, the latency job and the probe command.
+// Targets are probed by a pool of probers.Workers workers, every probe is limited by probers.Timeout and the whole round by probers.RoundTimeout.
+// The results keep the order of the targets, targets that weren't probed before the round ended (or ctx was cancelled) are left out.
+func ProbeTargets(ctx context.Context, execMode string, latencyTargets internal.LatencyTargets, probers *internal.Probers) internal.LatencyResults {
+	if probers.RoundTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, probers.RoundTimeout)
+		defer cancel()
+	}
 	latencyResults := internal.LatencyResults{
 		Source:  latencyTargets.Source,
 		Results: []internal.LatencyResult{},
 	}
 
-	waitGroup := new(sync.WaitGroup)
-	waitGroup.Add(len(latencyTargets.Targets))
-
-	c1 := make(chan internal.LatencyResult)
+	targets := latencyTargets.Targets
+	results := make([]internal.LatencyResult, len(targets))
+	probed := make([]bool, len(targets))
+	workers := probers.Workers
+	if workers < 1 || workers > len(targets) {
+		workers = len(targets)
+	}
 
-	go func() {
-		for c := range c1 {
-			latencyResults.Results = append(latencyResults.Results, c)
+	jobs := make(chan int)
+	waitGroup := new(sync.WaitGroup)
+	waitGroup.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer waitGroup.Done()
+			// Every worker writes only the index it received, no lock is needed
+			for i := range jobs {
+				results[i], probed[i] = handleLatencyTarget(ctx, targets[i], execMode, probers)
+			}
+		}()
+	}
+dispatch:
+	for i := range targets {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			break dispatch
 		}
-	}()
+	}
+	close(jobs)
+	waitGroup.Wait()
 
-	for _, target := range latencyTargets.Targets {
-		go func(target internal.LatencyTarget) {
-			handleLatencyTarget(target, execMode, c1, waitGroup)
-		}(target)
+	for i := range targets {
+		if probed[i] {
+			latencyResults.Results = append(latencyResults.Results, results[i])
+		}
 	}
+	internal.ObserveLatencyRound(latencyResults)
 	// Timestamp after operations
-	waitGroup.Wait()
-	//Without this sleep, the last result is skipped, don't know why
-	time.Sleep(time.Microsecond * 15)
 	tmpTime := time.Now()
 	latencyResults.Timestamp = internal.LatencyTimestamp{
 		TimeLocal:   tmpTime,
@@ -104,120 +144,45 @@ func latencyHandler(execMode string, latencyTargets internal.LatencyTargets) int
 	return latencyResults
 }
 
-func handleLatencyTarget(target internal.LatencyTarget, execMode string, c1 chan internal.LatencyResult, waitGroup *sync.WaitGroup) {
-	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
-	//cmd := "touch latency_" + latencyTargets.Hostname + " && echo '" + timestamp[len(timestamp)-1:] + "' > latency_" + latencyTargets.Hostname + " && cat latency_" + latencyTargets.Hostname
-	cmd := "echo " + timestamp[len(timestamp)-1:]
-	funcStart := time.Now()
-	elapsed := int64(0)
-	result, ok := sshServer(target, cmd, timestamp[len(timestamp)-1:])
-	if ok {
-		elapsed = time.Since(funcStart).Milliseconds()
-		if execMode == "DEBUG" {
-			fmt.Println(result)
+// The target is probed with its own method (tcp, udp, http or ssh), or with the default method of the DRC.
+// It returns false when the round ended before the target could be probed.
+func handleLatencyTarget(ctx context.Context, target internal.LatencyTarget, execMode string, probers *internal.Probers) (internal.LatencyResult, bool) {
+	latencyResult, err := probers.Probe(ctx, target)
+	if err != nil {
+		fmt.Println("latency:", target.Hostname, err)
+		if ctx.Err() != nil && err == ctx.Err() {
+			return latencyResult, false
 		}
-	} else {
-		elapsed = int64(-1)
 	}
-	latencyResult := internal.LatencyResult{Hostname: target.Hostname, Latency: elapsed}
 	if execMode == "DEBUG" {
 		fmt.Println(latencyResult.String())
 	}
-	c1 <- latencyResult
-	defer waitGroup.Done()
+	return latencyResult, true
 }
 
-// sshServer creates an SSH connection to the desired hostname, runs a command and compares the result of the command to the expected value
-func sshServer(target internal.LatencyTarget, cmd string, expected string) (string, bool) {
-	config := &ssh.ClientConfig{
-		User: target.HostUser,
-		Auth: []ssh.AuthMethod{
-			ssh.Password(target.HostPassword),
-		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		HostKeyAlgorithms: []string{
-			ssh.KeyAlgoRSA,
-			ssh.KeyAlgoDSA,
-			ssh.KeyAlgoECDSA256,
-			ssh.KeyAlgoECDSA384,
-			ssh.KeyAlgoECDSA521,
-			ssh.KeyAlgoED25519,
-		},
-		// optional tcp connect timeout
-		Timeout: 5 * time.Second,
-	}
-
-	client, err := ssh.Dial("tcp", target.Hostname+":"+target.Hostport, config)
-	if err != nil {
-		return err.Error(), false
-	}
-	defer client.Close()
-
-	session, err := client.NewSession()
-	if err != nil {
-		return err.Error(), false
-	}
-	defer session.Close()
-
-	stdout, err := session.StdoutPipe()
-	if err != nil {
-		return err.Error(), false
-	}
-
-	stdin, err := session.StdinPipe()
-	if err != nil {
-		return err.Error(), false
-	}
-	defer stdin.Close()
-
-	err = session.Run(cmd)
-	if err != nil {
-		return fmt.Sprintf("unable to execute remote command: %s", err), false
-	}
-
-	var buf bytes.Buffer
-	if _, err := io.Copy(&buf, stdout); err != nil {
-		return fmt.Sprintf("reading failed: %s", err), false
-	}
-
-	if sttyOutput := buf.String(); !strings.Contains(sttyOutput, expected) {
-		return fmt.Sprintf("FALSE RESULT, expected %s and got %s", expected, sttyOutput), false
-	} else {
-		return buf.String(), true
-	}
-
-}
-
-func sendLatency(targetUrl string, latencyUrl string, execMode string) {
+// Results go to the sinks of the job, the history keeps them locally
+func sendLatency(targetUrl string, latencyUrl string, execMode string, probers *internal.Probers, sinks internal.SinkGroup, history *internal.History) {
 	defer recoverHeartbeat()
-	latencyTargets, err := latencyTargetsHandler(targetUrl)
+	latencyTargets, err := FetchLatencyTargets(targetUrl)
 	if err == nil {
-		latencyResults := latencyHandler(execMode, latencyTargets)
+		latencyResults := ProbeTargets(context.Background(), execMode, latencyTargets, probers)
+		history.AddLatency(latencyResults)
 		if execMode == "DEBUG" {
 			fmt.Println("DEUBG MODE - POST")
 			fmt.Println(latencyResults.String())
 		}
-		res, err := http.Post(latencyUrl, "application/json", bytes.NewBuffer([]byte(latencyResults.String())))
+		err := sinks.Send(internal.Payload{Job: "latency", URL: latencyUrl, Timestamp: latencyResults.Timestamp.TimeLocal, Body: []byte(latencyResults.String())})
 		if err != nil {
 			panic(err)
 		}
-
-		defer res.Body.Close()
+	} else {
+		fmt.Println(err)
 	}
 }
 
-func LatencyCron(cron *gocron.Scheduler, seconds int, targetUrl string, latencyUrl string, execMode string) {
-	defer recoverCron()
-	cronRes, cronErr := cron.Every(seconds).Seconds().Do(sendLatency, targetUrl, latencyUrl, execMode)
-	if cronErr != nil {
-		panic(cronErr)
-	}
-	go func() {
-		if execMode == "DEBUG" {
-			_, cronErrDebug := cron.Every(seconds).Seconds().Do(debugCron, cronRes)
-			if cronErrDebug != nil {
-				panic(cronErr)
-			}
-		}
-	}()
+// LatencyCron probes the targets and sends the results to the sinks on the latency schedule
+func LatencyCron(scheduler *internal.Scheduler, schedule internal.Schedule, targetUrl string, latencyUrl string, execMode string, probers *internal.Probers, sinks internal.SinkGroup, history *internal.History) error {
+	return scheduler.Add(schedule, func() {
+		sendLatency(targetUrl, latencyUrl, execMode, probers, sinks, history)
+	})
 }
@@ -0,0 +1,12 @@
+package pkg
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"github.com/dmonteroh/distributed-resource-collector/internal"
+)
+
+// MetricsEndpoint serves the server stats, the last latency and bandwidth rounds and the DRC counters in the Prometheus text format
+func MetricsEndpoint(c *gin.Context) {
+	internal.MetricsHandler.ServeHTTP(c.Writer, c.Request)
+}
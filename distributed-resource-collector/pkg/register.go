@@ -0,0 +1,48 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/dmonteroh/distributed-resource-collector/internal"
+)
+
+// Registers the node in the inventory, a failed registration is retried on the next run of the job
+func sendRegistration(url string, execMode string, registration *internal.Registration) {
+	defer recoverHeartbeat()
+	asset, err := RegisterNode(url, registration)
+	if err != nil {
+		panic(err)
+	}
+	if execMode == "DEBUG" {
+		fmt.Println("REGISTERED:", asset.String())
+	}
+}
+
+// RegisterNode creates or updates the asset of the node through the gateway, it returns the asset as it's stored in the inventory.
+// The inventory only accepts registrations signed with the signing key of the node.
+func RegisterNode(url string, registration *internal.Registration) (internal.Asset, error) {
+	if internal.SigningKey == nil {
+		return internal.Asset{}, fmt.Errorf("registration: the registration is signed with the signing key, SIGNING_KEY can't be none")
+	}
+	asset, err := registration.Asset()
+	if err != nil {
+		return internal.Asset{}, err
+	}
+	response, err := internal.PostJsonResponse(url, []byte(asset.String()))
+	if err != nil {
+		return asset, err
+	}
+	registered := internal.Asset{}
+	if err := json.Unmarshal(response, &registered); err != nil {
+		return asset, fmt.Errorf("registration: %v", err)
+	}
+	return registered, nil
+}
+
+// RegisterCron registers the node on the register schedule, the first run registers it on start
+func RegisterCron(scheduler *internal.Scheduler, schedule internal.Schedule, app string, execMode string, registration *internal.Registration) error {
+	return scheduler.Add(schedule, func() {
+		sendRegistration(app, execMode, registration)
+	})
+}
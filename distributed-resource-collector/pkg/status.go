@@ -0,0 +1,14 @@
+package pkg
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"github.com/dmonteroh/distributed-resource-collector/internal"
+)
+
+// StatusEndpoint lists the scheduled jobs with their last and next run
+func StatusEndpoint(c *gin.Context) {
+	defer internal.RecoverEndpoint(c)
+	scheduler := c.MustGet("SCHEDULER").(*internal.Scheduler)
+	c.JSON(200, scheduler.Status())
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net/http"
 	"os"
 	"path/filepath"
 
@@ -25,11 +26,18 @@ func main() {
 	inventoryContract := internal.GetEnv("INVENTORY_SC", "inventory-sc")
 	latencyContract := internal.GetEnv("LATENCY_SC", "latency-sc")
 	selectorContract := internal.GetEnv("SELECTOR_SC", "selector-sc")
+	requireSignature := internal.GetEnv("REQUIRE_SIGNATURE", "false")
+	tlsCert := internal.GetEnv("TLS_CERT", "")
+	tlsKey := internal.GetEnv("TLS_KEY", "")
+	tlsClientCA := internal.GetEnv("TLS_CLIENT_CA", "")
+	tlsClientAuth := internal.GetEnv("TLS_CLIENT_AUTH", "none")
+	trustedProxies := internal.GetEnv("TRUSTED_PROXIES", "")
 
 	// MAP VARIABLES INTO MAP
 	variables := map[string]string{
-		"APP_TYPE":  appType,
-		"EXEC_MODE": execMode,
+		"APP_TYPE":          appType,
+		"EXEC_MODE":         execMode,
+		"REQUIRE_SIGNATURE": requireSignature,
 	}
 
 	// CONNECT TO THE FABRIC NETWORK
@@ -48,12 +56,17 @@ func main() {
 
 	// INITIALIZE HTTP SERVER AND ADD MIDDLEWARE
 	r := gin.Default()
+	// THE CLIENT IP IS THE KEY OF THE ASSETS, X-FORWARDED-FOR IS ONLY READ FROM TRUSTED_PROXIES (COMMA SEPARATED, NONE BY DEFAULT)
+	if err := r.SetTrustedProxies(internal.SplitList(trustedProxies)); err != nil {
+		log.Fatalf("Failed to set trusted proxies: %v", err)
+	}
 	r.Use(internal.EnviromentMiddleware(variables))
 	r.Use(internal.ContractMiddleware("resources", resourcesSC))
 	r.Use(internal.ContractMiddleware("inventory", inventorySC))
 	r.Use(internal.ContractMiddleware("latency", latencySC))
 	r.Use(internal.ContractMiddleware("selector", selectorSC))
 	r.Use(cors.Default())
+	r.Use(internal.ClientIdentityMiddleware())
 
 	// --- APP HTTP ROUTES
 	// ASSETS
@@ -71,9 +84,11 @@ func main() {
 	r.GET("/inventory/sensors", pkg.GetSensorInventoryHandler)
 	r.GET("/inventory/servers", pkg.GetServersInventoryHandler)
 	r.GET("/inventory/servers/gpu", pkg.GetGPUServersInventoryHandler)
+	r.GET("/inventory/labels", pkg.GetLabelsInventoryHandler)
 	r.GET("/inventory/:asset", pkg.GetInventoryHandler)
 	r.PUT("/inventory", pkg.UpdateInventoryHandler)
 	r.POST("/inventory", pkg.CreateInventoryHandler)
+	r.POST("/inventory/register", pkg.RegisterInventoryHandler)
 	// LATENCY
 	r.GET("/latency", pkg.GetAllLatencyHandler)
 	r.GET("/latency/targets", pkg.GetLatencyTargetsHandler)
@@ -83,6 +98,12 @@ func main() {
 	r.GET("/latency/:asset", pkg.GetLatencyHandler)
 	r.PUT("/latency", pkg.UpdateLatencyHandler)
 	r.POST("/latency", pkg.CreateLatencyHandler)
+	// BANDWIDTH
+	r.GET("/bandwidth/source/:source/minutes/:minutes", pkg.GetLimitedBandwidthListSource)
+	r.GET("/bandwidth/target/:target/minutes/:minutes", pkg.GetLimitedBandwidthListTarget)
+	r.GET("/bandwidth/:asset", pkg.GetBandwidthHandler)
+	r.POST("/bandwidth", pkg.CreateBandwidthHandler)
+	r.POST("/bandwidth/batch", pkg.CreateBandwidthBatchHandler)
 	// -- SELECTOR
 	r.GET("/selector/target/:target/minutes/:minutes/gpu/:gpu", pkg.GetSelectedAssetHandler)
 	r.GET("/selector/target/:target", pkg.GetAllSelectionTargetHandler)
@@ -91,10 +112,25 @@ func main() {
 	r.GET("/selector", pkg.GetAllSelectionsHandler)
 	// -- COLLECTOR
 	r.POST("/collector", pkg.UpsertResourceHandler)
+	r.POST("/collector/batch", pkg.UpsertResourceBatchHandler)
 	r.POST("/measurement", pkg.CreateLatencyHandler)
+	r.POST("/measurement/batch", pkg.CreateLatencyBatchHandler)
 
-	// START HTTP SERVER
-	r.Run(":" + listenPort)
+	// START HTTP SERVER (HTTPS WHEN TLS_CERT AND TLS_KEY ARE SET)
+	if tlsCert != "" && tlsKey != "" {
+		tlsConfig, err := internal.ServerTLSConfig(tlsClientCA, tlsClientAuth)
+		if err != nil {
+			log.Fatalf("Failed to configure TLS: %v", err)
+		}
+		server := &http.Server{
+			Addr:      ":" + listenPort,
+			Handler:   r,
+			TLSConfig: tlsConfig,
+		}
+		log.Fatal(server.ListenAndServeTLS(tlsCert, tlsKey))
+	} else {
+		r.Run(":" + listenPort)
+	}
 }
 
 func initFabric() *gateway.Network {
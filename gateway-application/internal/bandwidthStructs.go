@@ -0,0 +1,67 @@
+package internal
+
+import (
+	"encoding/json"
+
+	"github.com/wI2L/jettison"
+)
+
+// -- Bandwidth Results
+// Posted by the DRC, Bandwidth is in Mbit/s (-1 when the test failed)
+type BandwidthResults struct {
+	Source    string            `json:"source"`
+	Timestamp LatencyTimestamp  `json:"timestamp"`
+	Results   []BandwidthResult `json:"results"`
+}
+
+func BandwidthResultsJsonToStruct(v string) (results BandwidthResults, err error) {
+	err = json.Unmarshal([]byte(v), &results)
+	return results, err
+}
+
+type BandwidthResult struct {
+	Hostname  string  `json:"hostname"`
+	Bandwidth float64 `json:"bandwidth"`
+	Direction string  `json:"direction"`
+	Bytes     int64   `json:"bytes"`
+	Duration  float64 `json:"duration"` // ms
+}
+
+// Stored by latency-sc with docType "bandwidth", the results are stored as measurements
+type BandwidthAsset struct {
+	DocType      string            `json:"docType"`
+	ID           string            `json:"id"`
+	Source       string            `json:"source"`
+	Timestamp    LatencyTimestamp  `json:"timestamp"`
+	Measurements []BandwidthResult `json:"measurements"`
+}
+
+func (d BandwidthAsset) String() string {
+	s, _ := jettison.MarshalOpts(d, jettison.NilMapEmpty(), jettison.NilSliceEmpty())
+	return string(s)
+}
+
+func BandwidthAssetJsonToStruct(v string) (asset BandwidthAsset, err error) {
+	err = json.Unmarshal([]byte(v), &asset)
+	return asset, err
+}
+
+func JsonToBandwidthAssetArray(v string) (assets []BandwidthAsset, err error) {
+	err = json.Unmarshal([]byte(v), &assets)
+	return assets, err
+}
+
+func CreateBandwidthAsset(id string, bandwidthResults BandwidthResults) BandwidthAsset {
+	return BandwidthAsset{
+		DocType:      "bandwidth",
+		ID:           id,
+		Source:       bandwidthResults.Source,
+		Timestamp:    bandwidthResults.Timestamp,
+		Measurements: bandwidthResults.Results,
+	}
+}
+
+// Same ID as the latency results of the source, with its own prefix so both can be stored at the same time
+func CreateBandwidthID(appType string, source string, timestamp LatencyTimestamp) string {
+	return "bandwidth-" + CreateLatencyID(appType, source, timestamp)
+}
@@ -18,13 +18,21 @@ type ServerSelection struct {
 	Asset               Asset   `json:"asset"`
 	Target              string  `json:"target"`
 	AverageLatency      float64 `json:"averageLatency"`
+	AverageJitter       float64 `json:"averageJitter"`
+	AverageLoss         float64 `json:"averageLoss"`
+	AverageBandwidth    float64 `json:"averageBandwidth"`
 	CPUAverageUsage     float64 `json:"cpuAverageUsage"`
 	MemoryUsePercentage float64 `json:"memoryUsePercentage"`
 	ContainersRunning   int     `json:"containersRunning"`
+	NetworkUtilization  float64 `json:"networkUtilization"`
+	GPUAverageUsage     float64 `json:"gpuAverageUsage"`
+	MaxTemperature      float64 `json:"maxTemperature"`
+	ThermalPenalty      bool    `json:"thermalPenalty"`
 }
 
+// encoding/json, the asset has the labels map
 func (d ServerSelection) String() string {
-	s, _ := jettison.MarshalOpts(d, jettison.NilMapEmpty(), jettison.NilSliceEmpty())
+	s, _ := json.Marshal(d)
 	return string(s)
 }
 
@@ -0,0 +1,77 @@
+package internal
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ServerTLSConfig builds the TLS configuration of the HTTP server. clientAuth is one of:
+// none (no client certificates), optional (certificates are verified if the client sends one), require (every client needs a valid certificate).
+// Client certificates are verified against the CA bundle in clientCAFile.
+func ServerTLSConfig(clientCAFile string, clientAuth string) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	switch clientAuth {
+	case "", "none":
+		config.ClientAuth = tls.NoClientCert
+		return config, nil
+	case "optional":
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	case "require":
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf("tls: unknown client auth %s, expected none, optional or require", clientAuth)
+	}
+
+	if clientCAFile == "" {
+		return nil, fmt.Errorf("tls: client auth %s needs a client CA (TLS_CLIENT_CA)", clientAuth)
+	}
+	caPEM, err := ioutil.ReadFile(clientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("tls: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("tls: no certificates found in %s", clientCAFile)
+	}
+	config.ClientCAs = pool
+	return config, nil
+}
+
+// Adds the subject of the verified client certificate to the gin context as CLIENT_IDENTITY (full subject) and CLIENT_CN (common name).
+// Requests without a verified certificate don't have these keys.
+func ClientIdentityMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.TLS != nil && len(c.Request.TLS.VerifiedChains) > 0 && len(c.Request.TLS.VerifiedChains[0]) > 0 {
+			cert := c.Request.TLS.VerifiedChains[0][0]
+			c.Set("CLIENT_IDENTITY", cert.Subject.String())
+			c.Set("CLIENT_CN", cert.Subject.CommonName)
+		}
+	}
+}
+
+// ClientIdentity returns the subject of the verified client certificate of the request, the second value is false without one
+func ClientIdentity(c *gin.Context) (string, bool) {
+	identity, ok := c.Get("CLIENT_IDENTITY")
+	if !ok {
+		return "", false
+	}
+	return identity.(string), true
+}
+
+// CheckClientIdentity returns an error when the request has a verified client certificate whose common name isn't key, the asset key
+// the request writes (the address of the DRC). Requests without a verified certificate are accepted, TLS_CLIENT_AUTH=require rejects them.
+func CheckClientIdentity(c *gin.Context, key string) error {
+	identity, ok := ClientIdentity(c)
+	if !ok {
+		return nil
+	}
+	if cn := c.GetString("CLIENT_CN"); cn != key {
+		return fmt.Errorf("the client certificate %s is not issued to %s", identity, key)
+	}
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -35,6 +36,17 @@ func DateFormatID(d int64) string {
 	return t.Format(layout)
 }
 
+// Splits a comma separated list, empty items are dropped
+func SplitList(list string) []string {
+	items := []string{}
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 // Adds every key and value in map to the gin context as middleware. Allows access to these variables from inside the handlers
 func EnviromentMiddleware(variables map[string]string) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -63,9 +75,44 @@ func RecoverEndpoint(c *gin.Context) {
 			msg += err.(string)
 		case *json.SyntaxError:
 			msg += errType.Error()
+		case error:
+			msg += errType.Error()
 		default:
 		}
 		fmt.Println(msg)
 		c.JSON(400, gin.H{"error": msg})
 	}
 }
+
+// -- SIGNATURE
+// Headers sent by the DRC with every signed payload. The signature is verified by resources-sc against the public key registered in the inventory.
+const (
+	PublicKeyHeader = "X-Drc-Public-Key"
+	NonceHeader     = "X-Drc-Nonce"
+	TimestampHeader = "X-Drc-Timestamp"
+	SignatureHeader = "X-Drc-Signature"
+)
+
+type DrcSignature struct {
+	PublicKey string
+	Nonce     string
+	Timestamp string
+	Signature string
+}
+
+// Returns the transaction arguments that follow the payload in the signed transactions of resources-sc
+func (d DrcSignature) Args() []string {
+	return []string{d.PublicKey, d.Nonce, d.Timestamp, d.Signature}
+}
+
+// SignatureFromRequest reads the signature headers, the second value is false when the request isn't signed
+func SignatureFromRequest(c *gin.Context) (DrcSignature, bool) {
+	signature := DrcSignature{
+		PublicKey: c.GetHeader(PublicKeyHeader),
+		Nonce:     c.GetHeader(NonceHeader),
+		Timestamp: c.GetHeader(TimestampHeader),
+		Signature: c.GetHeader(SignatureHeader),
+	}
+	signed := signature.PublicKey != "" && signature.Nonce != "" && signature.Timestamp != "" && signature.Signature != ""
+	return signature, signed
+}
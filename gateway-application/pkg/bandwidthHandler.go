@@ -0,0 +1,119 @@
+package pkg
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-sdk-go/pkg/gateway"
+
+	"github.com/dmonteroh/fabric-distributed-resources/internal"
+)
+
+// Bandwidth tests are stored by latency-sc, next to the latency results
+
+func GetBandwidthHandler(c *gin.Context) {
+	defer internal.RecoverEndpoint(c)
+	contract := c.MustGet("latency").(*gateway.Contract)
+	asset := c.Param("asset")
+
+	res, err := contract.EvaluateTransaction("ReadBandwidthAsset", asset)
+	if err != nil {
+		panic(err.Error())
+	}
+	readRes, err := internal.BandwidthAssetJsonToStruct(string(res))
+	if err != nil {
+		panic(err.Error())
+	}
+	c.JSON(200, readRes)
+}
+
+func GetLimitedBandwidthListTarget(c *gin.Context) {
+	defer internal.RecoverEndpoint(c)
+	contract := c.MustGet("latency").(*gateway.Contract)
+	target := c.Param("target")
+	minutes := c.Param("minutes")
+	res, err := contract.EvaluateTransaction("GetBandwidthListTimeTarget", target, minutes)
+	if err != nil {
+		panic(err.Error())
+	}
+	readRes, err := internal.JsonToBandwidthAssetArray(string(res))
+	if err != nil {
+		panic(err.Error())
+	}
+	c.JSON(200, readRes)
+}
+
+func GetLimitedBandwidthListSource(c *gin.Context) {
+	defer internal.RecoverEndpoint(c)
+	contract := c.MustGet("latency").(*gateway.Contract)
+	source := c.Param("source")
+	minutes := c.Param("minutes")
+	res, err := contract.EvaluateTransaction("GetBandwidthListTimeSource", source, minutes)
+	if err != nil {
+		panic(err.Error())
+	}
+	readRes, err := internal.JsonToBandwidthAssetArray(string(res))
+	if err != nil {
+		panic(err.Error())
+	}
+	c.JSON(200, readRes)
+}
+
+func CreateBandwidthHandler(c *gin.Context) {
+	defer internal.RecoverEndpoint(c)
+	contract := c.MustGet("latency").(*gateway.Contract)
+	appType := c.MustGet("APP_TYPE").(string)
+
+	jsonData, _ := ioutil.ReadAll(c.Request.Body)
+	bandwidthResults, err := internal.BandwidthResultsJsonToStruct(string(jsonData))
+	if err != nil {
+		panic(err)
+	}
+	bandwidthId := internal.CreateBandwidthID(appType, bandwidthResults.Source, bandwidthResults.Timestamp)
+	bandwidthAsset := internal.CreateBandwidthAsset(bandwidthId, bandwidthResults)
+
+	_, err = contract.SubmitTransaction("CreateBandwidthAsset", bandwidthAsset.String())
+	if err != nil {
+		panic(err.Error())
+	}
+
+	log.Println(bandwidthAsset.String())
+	c.JSON(200, gin.H{"key": bandwidthAsset.ID})
+}
+
+// CreateBandwidthBatchHandler stores many bandwidth results of the same DRC in a single transaction, the body is an array of BandwidthResults
+func CreateBandwidthBatchHandler(c *gin.Context) {
+	defer internal.RecoverEndpoint(c)
+	contract := c.MustGet("latency").(*gateway.Contract)
+	appType := c.MustGet("APP_TYPE").(string)
+
+	jsonData, _ := ioutil.ReadAll(c.Request.Body)
+	var batch []internal.BandwidthResults
+	err := json.Unmarshal(jsonData, &batch)
+	if err != nil {
+		panic(err)
+	}
+	if len(batch) == 0 {
+		panic("empty batch")
+	}
+
+	assets := []internal.BandwidthAsset{}
+	for _, bandwidthResults := range batch {
+		bandwidthId := internal.CreateBandwidthID(appType, bandwidthResults.Source, bandwidthResults.Timestamp)
+		assets = append(assets, internal.CreateBandwidthAsset(bandwidthId, bandwidthResults))
+	}
+	payload, _ := json.Marshal(assets)
+	res, err := contract.SubmitTransaction("CreateBandwidthAssets", string(payload))
+	if err != nil {
+		panic(err.Error())
+	}
+	results, err := internal.JsonToBatchResults(string(res))
+	if err != nil {
+		panic(err.Error())
+	}
+
+	log.Println(string(res))
+	c.JSON(200, results)
+}
@@ -1,6 +1,7 @@
 package pkg
 
 import (
+	"encoding/json"
 	"io/ioutil"
 
 	"github.com/gin-gonic/gin"
@@ -75,6 +76,38 @@ func CreateInventoryHandler(c *gin.Context) {
 	c.JSON(200, gin.H{"key": inventory.ID})
 }
 
+// The DRC registers itself on start and on its register schedule. The asset key is the address the gateway sees, like the heartbeats,
+// and properties.hostname (the address probed by the other nodes) defaults to it. inventory-sc matches the node by properties.hostId and
+// verifies the signature of the body, unsigned registrations are rejected. A client certificate has to be issued to the asset key.
+func RegisterInventoryHandler(c *gin.Context) {
+	defer internal.RecoverEndpoint(c)
+	contract := c.MustGet("inventory").(*gateway.Contract)
+
+	jsonData, _ := ioutil.ReadAll(c.Request.Body)
+	if _, err := internal.JsonToAsset(string(jsonData)); err != nil {
+		panic(err)
+	}
+	if err := internal.CheckClientIdentity(c, c.ClientIP()); err != nil {
+		c.JSON(403, gin.H{"error": "Error: " + err.Error()})
+		return
+	}
+	signature, signed := internal.SignatureFromRequest(c)
+	if !signed {
+		panic("registrations have to be signed with the signing key of the DRC")
+	}
+
+	args := append([]string{c.ClientIP(), string(jsonData)}, signature.Args()...)
+	res, err := contract.SubmitTransaction("RegisterAsset", args...)
+	if err != nil {
+		panic(err.Error())
+	}
+	registered, err := internal.JsonToAsset(string(res))
+	if err != nil {
+		panic(err.Error())
+	}
+	c.JSON(200, registered)
+}
+
 func GetServersInventoryHandler(c *gin.Context) {
 	defer internal.RecoverEndpoint(c)
 	contract := c.MustGet("inventory").(*gateway.Contract)
@@ -111,6 +144,30 @@ func GetGPUServersInventoryHandler(c *gin.Context) {
 	c.JSON(200, readRes)
 }
 
+// GET /inventory/labels?labels=arch=arm64,cuda=true returns the enabled assets with every label, a key without a value only needs the label
+func GetLabelsInventoryHandler(c *gin.Context) {
+	defer internal.RecoverEndpoint(c)
+	contract := c.MustGet("inventory").(*gateway.Contract)
+
+	selector, err := internal.ParseLabelSelector(c.Query("labels"))
+	if err != nil {
+		panic(err.Error())
+	}
+	selectorJson, _ := json.Marshal(selector)
+	res, err := contract.EvaluateTransaction("GetAssetsByLabels", string(selectorJson))
+	if err != nil {
+		panic(err.Error())
+	}
+	readRes, err := internal.JsonToAssetArray(string(res))
+	if err != nil {
+		panic(err.Error())
+	} else if len(readRes) < 1 {
+		readRes = []internal.Asset{}
+	}
+
+	c.JSON(200, readRes)
+}
+
 func ManualServersInventoryHandler(c *gin.Context) []internal.Asset {
 	defer internal.RecoverEndpoint(c)
 	contract := c.MustGet("inventory").(*gateway.Contract)
@@ -1,6 +1,7 @@
 package pkg
 
 import (
+	"encoding/json"
 	"io/ioutil"
 	"log"
 
@@ -295,3 +296,38 @@ func CreateLatencyHandler(c *gin.Context) {
 	log.Println(latencyAsset.String())
 	c.JSON(200, gin.H{"key": latencyAsset.ID})
 }
+
+// CreateLatencyBatchHandler stores many latency results of the same DRC in a single transaction, the body is an array of LatencyResults
+func CreateLatencyBatchHandler(c *gin.Context) {
+	defer internal.RecoverEndpoint(c)
+	contract := c.MustGet("latency").(*gateway.Contract)
+	appType := c.MustGet("APP_TYPE").(string)
+
+	jsonData, _ := ioutil.ReadAll(c.Request.Body)
+	var batch []internal.LatencyResults
+	err := json.Unmarshal(jsonData, &batch)
+	if err != nil {
+		panic(err)
+	}
+	if len(batch) == 0 {
+		panic("empty batch")
+	}
+
+	assets := []internal.LatencyAsset{}
+	for _, latencyResults := range batch {
+		latencyId := internal.CreateLatencyID(appType, latencyResults.Source, latencyResults.Timestamp)
+		assets = append(assets, internal.CreateLatencyAsset(latencyId, latencyResults))
+	}
+	payload, _ := json.Marshal(assets)
+	res, err := contract.SubmitTransaction("CreateAssets", string(payload))
+	if err != nil {
+		panic(err.Error())
+	}
+	results, err := internal.JsonToBatchResults(string(res))
+	if err != nil {
+		panic(err.Error())
+	}
+
+	log.Println(string(res))
+	c.JSON(200, results)
+}
@@ -1,6 +1,7 @@
 package pkg
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"strconv"
@@ -12,6 +13,9 @@ import (
 	"github.com/dmonteroh/fabric-distributed-resources/internal"
 )
 
+// Signed heartbeats are verified and stored by resources-sc (CreateSignedAsset, UpdateSignedAsset), the payload is passed exactly as it was signed.
+// Unsigned heartbeats are rejected with REQUIRE_SIGNATURE and for the nodes with a public key in the inventory.
+// A client certificate has to be issued to the address of the DRC (common name), the key of its stats.
 func UpsertResourceHandler(c *gin.Context) {
 	defer internal.RecoverEndpoint(c)
 	appType := c.MustGet("APP_TYPE").(string)
@@ -21,17 +25,34 @@ func UpsertResourceHandler(c *gin.Context) {
 	if err != nil {
 		panic(err)
 	}
+	if err := internal.CheckClientIdentity(c, clientIP); err != nil {
+		c.JSON(403, gin.H{"error": "Error: " + err.Error()})
+		return
+	}
+	signature, signed := internal.SignatureFromRequest(c)
+	if !signed && signatureRequired(c, clientIP) {
+		c.JSON(401, gin.H{"error": "Error: the heartbeat is not signed"})
+		return
+	}
 
 	if appType == "single_insert" {
 		stats := internal.ConvertToStorage(drcStats)
-		stats.ID = clientIP + "-" + internal.DateFormatID(drcStats.Timestamp.TimeSeconds)
+		stats.ID = internal.CreateStatID(clientIP, drcStats)
 		stats.Hostname = clientIP
-		createResource(c, stats)
+		if signed {
+			submitSignedResource(c, "CreateSignedAsset", stats.ID, clientIP, string(jsonData), signature)
+		} else {
+			createResource(c, stats)
+		}
 	} else if appType == "single_upsert" {
 		stats := internal.ConvertToStorage(drcStats)
 		stats.ID = clientIP
 		stats.Hostname = clientIP
-		if resourceExists(c, stats.ID) {
+		if signed && resourceExists(c, stats.ID) {
+			submitSignedResource(c, "UpdateSignedAsset", stats.ID, clientIP, string(jsonData), signature)
+		} else if signed {
+			submitSignedResource(c, "CreateSignedAsset", stats.ID, clientIP, string(jsonData), signature)
+		} else if resourceExists(c, stats.ID) {
 			updateResource(c, stats)
 		} else {
 			createResource(c, stats)
@@ -41,6 +62,98 @@ func UpsertResourceHandler(c *gin.Context) {
 	}
 }
 
+// UpsertResourceBatchHandler stores many heartbeats of the same DRC in a single transaction, the body is an array of DrcStats.
+// With single_upsert only the newest heartbeat is stored, every item of the batch reports the result of that write.
+func UpsertResourceBatchHandler(c *gin.Context) {
+	defer internal.RecoverEndpoint(c)
+	appType := c.MustGet("APP_TYPE").(string)
+	contract := c.MustGet("resources").(*gateway.Contract)
+	var clientIP string = c.ClientIP()
+	jsonData, _ := ioutil.ReadAll(c.Request.Body)
+	var batch []internal.DrcStats
+	err := json.Unmarshal(jsonData, &batch)
+	if err != nil {
+		panic(err)
+	}
+	if len(batch) == 0 {
+		panic("empty batch")
+	}
+	if err := internal.CheckClientIdentity(c, clientIP); err != nil {
+		c.JSON(403, gin.H{"error": "Error: " + err.Error()})
+		return
+	}
+	signature, signed := internal.SignatureFromRequest(c)
+	if !signed && signatureRequired(c, clientIP) {
+		c.JSON(401, gin.H{"error": "Error: the batch is not signed"})
+		return
+	}
+
+	if appType == "single_insert" {
+		stats := []internal.StoredStat{}
+		ids := []string{}
+		for _, drcStats := range batch {
+			stat := internal.ConvertToStorage(drcStats)
+			stat.ID = internal.CreateStatID(clientIP, drcStats)
+			stat.Hostname = clientIP
+			stats = append(stats, stat)
+			ids = append(ids, stat.ID)
+		}
+		var res []byte
+		if signed {
+			idsJSON, _ := json.Marshal(ids)
+			args := append([]string{string(idsJSON), clientIP, string(jsonData)}, signature.Args()...)
+			res, err = contract.SubmitTransaction("CreateSignedAssets", args...)
+		} else {
+			payload, _ := json.Marshal(stats)
+			res, err = contract.SubmitTransaction("CreateAssets", string(payload))
+		}
+		if err != nil {
+			panic(err.Error())
+		}
+		results, err := internal.JsonToBatchResults(string(res))
+		if err != nil {
+			panic(err.Error())
+		}
+		c.JSON(200, results)
+	} else if appType == "single_upsert" && signed {
+		args := append([]string{clientIP, clientIP, string(jsonData)}, signature.Args()...)
+		_, err := contract.SubmitTransaction("UpsertSignedAssets", args...)
+		if err != nil {
+			panic(err.Error())
+		}
+		results := []internal.BatchResult{}
+		for range batch {
+			results = append(results, internal.BatchResult{ID: clientIP, Success: true})
+		}
+		c.JSON(200, results)
+	} else if appType == "single_upsert" {
+		newest := batch[0]
+		for _, drcStats := range batch {
+			if drcStats.Timestamp.TimeNano > newest.Timestamp.TimeNano {
+				newest = drcStats
+			}
+		}
+		stats := internal.ConvertToStorage(newest)
+		stats.ID = clientIP
+		stats.Hostname = clientIP
+		function := "CreateAsset"
+		if resourceExists(c, stats.ID) {
+			function = "UpdateAsset"
+		}
+		_, err := contract.SubmitTransaction(function, stats.ID, stats.String())
+		if err != nil {
+			panic(err.Error())
+		}
+		results := []internal.BatchResult{}
+		for range batch {
+			results = append(results, internal.BatchResult{ID: stats.ID, Success: true})
+		}
+		c.JSON(200, results)
+	} else {
+		panic("APP_TYPE not implemented")
+	}
+}
+
 func UpdateResourceHandler(c *gin.Context) {
 	defer internal.RecoverEndpoint(c)
 
@@ -230,6 +343,35 @@ func createResource(c *gin.Context, stats internal.StoredStat) {
 	c.JSON(200, gin.H{"key": stats.ID})
 }
 
+// Submits one of the signed transactions of resources-sc (CreateSignedAsset, UpdateSignedAsset)
+func submitSignedResource(c *gin.Context, function string, statID string, hostname string, payload string, signature internal.DrcSignature) {
+	defer internal.RecoverEndpoint(c)
+	contract := c.MustGet("resources").(*gateway.Contract)
+
+	args := append([]string{statID, hostname, payload}, signature.Args()...)
+	_, err := contract.SubmitTransaction(function, args...)
+	if err != nil {
+		panic(err.Error())
+	}
+	c.JSON(200, gin.H{"key": statID})
+}
+
+// Signatures are required by REQUIRE_SIGNATURE and for every node with a public key in the inventory, whatever REQUIRE_SIGNATURE says.
+// Unsigned stats are only accepted from an inventory asset without a public key: nodes that aren't in the inventory and failed lookups
+// require the signature too, which rejects them (resources-sc checks it again on the unsigned transactions).
+func signatureRequired(c *gin.Context, clientIP string) bool {
+	if required, _ := strconv.ParseBool(c.GetString("REQUIRE_SIGNATURE")); required {
+		return true
+	}
+	inventory := c.MustGet("inventory").(*gateway.Contract)
+	res, err := inventory.EvaluateTransaction("ReadAsset", clientIP)
+	if err != nil {
+		return true
+	}
+	asset, err := internal.JsonToAsset(string(res))
+	return err != nil || asset.Properties.PublicKey != ""
+}
+
 // FABRIC CALLS
 
 // log.Println("--> Submit Transaction: InitLedger, function creates the initial set of assets on the ledger")
@@ -2,6 +2,7 @@ package pkg
 
 import (
 	"fmt"
+	"math"
 	"sort"
 	"strconv"
 	"sync"
@@ -12,14 +13,16 @@ import (
 	"github.com/hyperledger/fabric-sdk-go/pkg/gateway"
 )
 
-func filterServers(servers []internal.Asset, latencyAnalysis []internal.LatencyAnalysis) ([]internal.Asset, []internal.LatencyAnalysis) {
+// Servers without a latency analysis of the target are left out. When minBandwidth (Mbit/s) is above zero, servers whose average
+// bandwidth to the target is below it are left out too, including the servers that have no bandwidth tests
+func filterServers(servers []internal.Asset, latencyAnalysis []internal.LatencyAnalysis, minBandwidth float64) ([]internal.Asset, []internal.LatencyAnalysis) {
 	filteredServers := []internal.Asset{}
 	filteredAnalysis := []internal.LatencyAnalysis{}
 
 	if len(servers) > 0 {
 		for _, server := range servers {
 			for _, analysis := range latencyAnalysis {
-				if server.ID == analysis.Hostname {
+				if server.ID == analysis.Hostname && (minBandwidth <= 0 || analysis.AverageBandwidth >= minBandwidth) {
 					filteredServers = append(filteredServers, server)
 					filteredAnalysis = append(filteredAnalysis, analysis)
 				}
@@ -40,6 +43,9 @@ func combineAnalysis(target string, servers []internal.Asset, latencyAnalysis []
 		for _, lat := range latencyAnalysis {
 			if lat.Hostname == server.ID {
 				tmpSel.AverageLatency = lat.AverageLatency
+				tmpSel.AverageJitter = lat.AverageJitter
+				tmpSel.AverageLoss = lat.AverageLoss
+				tmpSel.AverageBandwidth = lat.AverageBandwidth
 			}
 		}
 		for _, stat := range statAnalysis {
@@ -47,6 +53,10 @@ func combineAnalysis(target string, servers []internal.Asset, latencyAnalysis []
 				tmpSel.CPUAverageUsage = stat.CPUAverageUsage
 				tmpSel.MemoryUsePercentage = stat.MemoryUsePercentage
 				tmpSel.ContainersRunning = stat.ContainersRunning
+				tmpSel.NetworkUtilization = stat.NetworkUtilization
+				tmpSel.GPUAverageUsage = stat.GPUAverageUsage
+				tmpSel.MaxTemperature = stat.MaxTemperature
+				tmpSel.ThermalPenalty = stat.ThermalPenalty
 			}
 		}
 
@@ -55,13 +65,43 @@ func combineAnalysis(target string, servers []internal.Asset, latencyAnalysis []
 	return selectionSlice
 }
 
-func sortSelection(items []internal.ServerSelection) {
+// Links that lose more than this ratio of the latency probes go after the rest
+const MaxLatencyLoss = 0.05
+
+// Latency used to rank the servers, a jittery link counts as slower than a stable link with the same average
+func selectionLatency(item internal.ServerSelection) float64 {
+	return item.AverageLatency + item.AverageJitter
+}
+
+// Latency (ms) of the buckets in which GPU servers are sorted by GPU usage, a few milliseconds matter less than an idle GPU
+const GPULatencyBucket = 5.0
+
+// Hot or throttled servers always go after the rest, their latency and usage can't be trusted to stay the same. Lossy links go next.
+// When a GPU server is requested, servers in the same GPULatencyBucket are sorted by GPU usage first, so an idle GPU is preferred over a
+// busy one that is slightly closer. Servers with the same latency are sorted by CPU usage
+func sortSelection(items []internal.ServerSelection, gpu bool) {
 	sort.Slice(items, func(i, j int) bool {
 		var sortedByLatency, sortedByCPU bool
 
-		sortedByLatency = items[i].AverageLatency < items[j].AverageLatency
+		if items[i].ThermalPenalty != items[j].ThermalPenalty {
+			return !items[i].ThermalPenalty
+		}
+		if lossyI, lossyJ := items[i].AverageLoss > MaxLatencyLoss, items[j].AverageLoss > MaxLatencyLoss; lossyI != lossyJ {
+			return !lossyI
+		}
+		if gpu {
+			bucketI, bucketJ := math.Floor(selectionLatency(items[i])/GPULatencyBucket), math.Floor(selectionLatency(items[j])/GPULatencyBucket)
+			if bucketI != bucketJ {
+				return bucketI < bucketJ
+			}
+			if items[i].GPUAverageUsage != items[j].GPUAverageUsage {
+				return items[i].GPUAverageUsage < items[j].GPUAverageUsage
+			}
+		}
+
+		sortedByLatency = selectionLatency(items[i]) < selectionLatency(items[j])
 
-		if items[i].AverageLatency == items[j].AverageLatency {
+		if selectionLatency(items[i]) == selectionLatency(items[j]) {
 			sortedByCPU = items[i].CPUAverageUsage < items[j].CPUAverageUsage
 			return sortedByCPU
 		}
@@ -78,6 +118,17 @@ func GetSelectedAssetHandler(c *gin.Context) {
 	if err != nil {
 		panic(err.Error())
 	}
+	minBandwidth, err := strconv.ParseFloat(c.DefaultQuery("minBandwidth", "0"), 64)
+	if err != nil {
+		panic(err.Error())
+	}
+	// ?labels=arch=arm64,cuda=true only keeps the servers with every label
+	var labels map[string]string
+	if c.Query("labels") != "" {
+		if labels, err = internal.ParseLabelSelector(c.Query("labels")); err != nil {
+			panic(err.Error())
+		}
+	}
 
 	fmt.Printf("Selecting SERVER for %s after %s minute analysis", target, minutes)
 
@@ -88,6 +139,15 @@ func GetSelectedAssetHandler(c *gin.Context) {
 	} else {
 		servers = ManualServersInventoryHandler(c)
 	}
+	if labels != nil {
+		labeledServers := []internal.Asset{}
+		for _, server := range servers {
+			if server.MatchLabels(labels) {
+				labeledServers = append(labeledServers, server)
+			}
+		}
+		servers = labeledServers
+	}
 
 	// Check that there are servers
 
@@ -95,7 +155,7 @@ func GetSelectedAssetHandler(c *gin.Context) {
 	var latencyAnalysis []internal.LatencyAnalysis = ManualAnalysisTimeTarget(c)
 
 	// FILTER Latency Analysis and Server List
-	var filteredServers, filteredAnalysis = filterServers(servers, latencyAnalysis)
+	var filteredServers, filteredAnalysis = filterServers(servers, latencyAnalysis, minBandwidth)
 
 	// GET Resource Analysis for Server List
 	var resourceAnalysis []internal.StatAnalysis = []internal.StatAnalysis{}
@@ -123,16 +183,19 @@ func GetSelectedAssetHandler(c *gin.Context) {
 	// Combine Data into single Slice
 	selectionObj := combineAnalysis(target, filteredServers, filteredAnalysis, resourceAnalysis)
 	// Sort combined Data
-	sortSelection(selectionObj)
+	sortSelection(selectionObj, gpu == 1)
 
-	contract := c.MustGet("selector").(*gateway.Contract)
-	storeSelection := internal.StoreSelection(selectionObj[0])
+	// No server left after the filters (e.g. minBandwidth), there's no selection to store
+	if len(selectionObj) > 0 {
+		contract := c.MustGet("selector").(*gateway.Contract)
+		storeSelection := internal.StoreSelection(selectionObj[0])
 
-	fmt.Println(storeSelection)
+		fmt.Println(storeSelection)
 
-	_, err = contract.SubmitTransaction("CreateAsset", storeSelection.String())
-	if err != nil {
-		panic(err.Error())
+		_, err = contract.SubmitTransaction("CreateAsset", storeSelection.String())
+		if err != nil {
+			panic(err.Error())
+		}
 	}
 
 	if len(selectionObj) == 0 {
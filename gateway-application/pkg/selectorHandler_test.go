@@ -0,0 +1,45 @@
+package pkg
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/dmonteroh/fabric-distributed-resources/internal"
+)
+
+func selectionIDs(items []internal.ServerSelection) []string {
+	ids := []string{}
+	for _, item := range items {
+		ids = append(ids, item.Asset.ID)
+	}
+	return ids
+}
+
+func TestSortSelection(t *testing.T) {
+	// busy is 0.5ms closer than idle, both are in the same GPULatencyBucket
+	busy := internal.ServerSelection{Asset: internal.Asset{ID: "busy"}, AverageLatency: 11.2, AverageJitter: 0.3, GPUAverageUsage: 95, CPUAverageUsage: 10}
+	idle := internal.ServerSelection{Asset: internal.Asset{ID: "idle"}, AverageLatency: 11.5, AverageJitter: 0.5, GPUAverageUsage: 5, CPUAverageUsage: 10}
+	far := internal.ServerSelection{Asset: internal.Asset{ID: "far"}, AverageLatency: 40, GPUAverageUsage: 0}
+	hot := internal.ServerSelection{Asset: internal.Asset{ID: "hot"}, AverageLatency: 1, ThermalPenalty: true}
+	lossy := internal.ServerSelection{Asset: internal.Asset{ID: "lossy"}, AverageLatency: 2, AverageLoss: 0.2}
+
+	tests := []struct {
+		name  string
+		items []internal.ServerSelection
+		gpu   bool
+		want  []string
+	}{
+		{name: "gpu prefers the idle gpu", items: []internal.ServerSelection{busy, far, idle}, gpu: true, want: []string{"idle", "busy", "far"}},
+		{name: "cpu prefers the lowest latency", items: []internal.ServerSelection{idle, far, busy}, gpu: false, want: []string{"busy", "idle", "far"}},
+		{name: "hot and lossy go last", items: []internal.ServerSelection{hot, lossy, far, idle}, gpu: true, want: []string{"idle", "far", "lossy", "hot"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sortSelection(test.items, test.gpu)
+			got := selectionIDs(test.items)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("sortSelection() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package chaincode
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Maximum difference (seconds) between the timestamp signed by the DRC and the timestamp of the transaction
+const SignatureWindow int64 = 300
+
+// Nonces are stored as composite keys, they are not returned by the range queries over the assets
+const nonceObjectType = "nonce"
+
+// Registrations are signed by the DRC like the heartbeats of resources-sc: the ed25519 signature of payload + "\n" + nonce + "\n" + timestamp.
+// publicKey is the key that has to have signed it (base64), the key of the asset or the key of the registration for a new node.
+// A nonce can only be used once per host while its timestamp is within SignatureWindow, older nonces are pruned on every verification.
+func verifySignature(ctx contractapi.TransactionContextInterface, hostID string, publicKey string, payload string, nonce string, timestamp string, signature string) error {
+	key, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return fmt.Errorf("the public key of %s is not a valid ed25519 key", hostID)
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("failed to decode signature: %v", err)
+	}
+
+	signedAt, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("failed to read signature timestamp: %v", err)
+	}
+	txTimestamp, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return fmt.Errorf("failed to read transaction timestamp: %v", err)
+	}
+	if diff := txTimestamp.GetSeconds() - signedAt; diff > SignatureWindow || diff < -SignatureWindow {
+		return fmt.Errorf("the signature of %s expired, signed %d seconds from the transaction", hostID, diff)
+	}
+
+	if nonce == "" {
+		return fmt.Errorf("the signature of %s has no nonce", hostID)
+	}
+	if err := pruneNonces(ctx, hostID, txTimestamp.GetSeconds()); err != nil {
+		return err
+	}
+	nonceKey, err := ctx.GetStub().CreateCompositeKey(nonceObjectType, []string{hostID, nonce})
+	if err != nil {
+		return fmt.Errorf("failed to create nonce key: %v", err)
+	}
+	used, err := ctx.GetStub().GetState(nonceKey)
+	if err != nil {
+		return fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if used != nil {
+		return fmt.Errorf("the nonce %s of %s was already used", nonce, hostID)
+	}
+
+	message := []byte(payload + "\n" + nonce + "\n" + timestamp)
+	if !ed25519.Verify(ed25519.PublicKey(key), message, sig) {
+		return fmt.Errorf("invalid signature for %s", hostID)
+	}
+
+	return ctx.GetStub().PutState(nonceKey, []byte(timestamp))
+}
+
+// Deletes the nonces of the host signed more than SignatureWindow before now, their signatures would be rejected as expired anyway
+func pruneNonces(ctx contractapi.TransactionContextInterface, hostID string, now int64) error {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(nonceObjectType, []string{hostID})
+	if err != nil {
+		return fmt.Errorf("failed to read nonces: %v", err)
+	}
+	defer resultsIterator.Close()
+	for resultsIterator.HasNext() {
+		nonce, err := resultsIterator.Next()
+		if err != nil {
+			return fmt.Errorf("failed to read nonces: %v", err)
+		}
+		signedAt, err := strconv.ParseInt(string(nonce.Value), 10, 64)
+		if err != nil || now-signedAt > SignatureWindow {
+			if err := ctx.GetStub().DelState(nonce.Key); err != nil {
+				return fmt.Errorf("failed to delete nonce: %v", err)
+			}
+		}
+	}
+	return nil
+}
@@ -3,12 +3,16 @@ package chaincode
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 
 	"github.com/dmonteroh/distributed-resources-smartcontract/inventory-sc/internal"
 	"github.com/hyperledger/fabric-chaincode-go/shim"
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
 )
 
+// Host ids are indexed as composite keys (host id -> asset key), they are not returned by the range queries over the assets
+const hostIDObjectType = "hostId"
+
 // SmartContract provides functions for managing an Asset
 type SmartContract struct {
 	contractapi.Contract
@@ -64,11 +68,17 @@ func (s *SmartContract) CreateAsset(ctx contractapi.TransactionContextInterface,
 	if exists {
 		return fmt.Errorf("the Asset with key: %s already exists", asset.ID)
 	}
+	if err := s.checkHostID(ctx, asset); err != nil {
+		return err
+	}
 
 	// RUN VALIDATIONS
 	validJson := []byte(asset.String())
 
-	return ctx.GetStub().PutState(asset.ID, validJson)
+	if err := ctx.GetStub().PutState(asset.ID, validJson); err != nil {
+		return err
+	}
+	return indexHostID(ctx, asset.Properties.HostID, asset.ID)
 }
 
 // UpdateAsset updates an existing asset in the world state with provided parameters.
@@ -77,31 +87,226 @@ func (s *SmartContract) UpdateAsset(ctx contractapi.TransactionContextInterface,
 	if err != nil {
 		return err
 	}
-	exists, err := s.AssetExists(ctx, asset.ID)
+	stored, err := s.ReadAsset(ctx, asset.ID)
 	if err != nil {
 		return err
 	}
-	if !exists {
-		return fmt.Errorf("the Asset with key: %s does not exist", asset.ID)
+	if err := s.checkHostID(ctx, asset); err != nil {
+		return err
 	}
 
 	// RUN VALIDATIONS
 	validJson := []byte(asset.String())
 
-	return ctx.GetStub().PutState(asset.ID, validJson)
+	if err := ctx.GetStub().PutState(asset.ID, validJson); err != nil {
+		return err
+	}
+	if stored.Properties.HostID != asset.Properties.HostID {
+		if err := unindexHostID(ctx, stored.Properties.HostID, asset.ID); err != nil {
+			return err
+		}
+	}
+	return indexHostID(ctx, asset.Properties.HostID, asset.ID)
+}
+
+// RegisterAsset creates or updates the asset of a DRC that registers itself under assetKey, the address the gateway sees. payload is the
+// asset as it was posted and signed by the DRC (see verifySignature), properties.hostname defaults to assetKey.
+// Nodes are matched by properties.hostId first (see indexHostID), so a node that comes back with another address (DHCP) keeps its asset,
+// moved to the new key, as long as no other asset has that key. New nodes are registered disabled (state 0) until an operator enables them.
+// The registration has to be signed with the public key of the asset, or for a new node with the key it registers (proof of possession).
+// An asset without public key (created by hand) is adopted only from its own key and never moved. The properties discovered by the DRC
+// are replaced, the ones set by hand (owner, state, SSH account, host key and probe settings) are kept, and the public key of an asset
+// can't be changed by a registration. The registered labels are merged into the labels of the asset, so the labels set by hand are kept too.
+func (s *SmartContract) RegisterAsset(ctx contractapi.TransactionContextInterface, assetKey string, payload string, publicKey string, nonce string, timestamp string, signature string) (internal.Asset, error) {
+	asset, err := internal.JsonToAsset(payload)
+	if err != nil {
+		return internal.Asset{}, err
+	}
+	asset.ID = assetKey
+	if asset.Properties.Hostname == "" {
+		asset.Properties.Hostname = assetKey
+	}
+	if asset.ID == "" || asset.Properties.HostID == "" {
+		return internal.Asset{}, fmt.Errorf("a registration needs the asset key and properties.hostId")
+	}
+	if asset.Properties.PublicKey == "" || publicKey != asset.Properties.PublicKey {
+		return internal.Asset{}, fmt.Errorf("a registration has to be signed with the public key of the asset (properties.publicKey)")
+	}
+
+	// The asset of the node, by host id or by key
+	existing, err := s.assetByHostID(ctx, asset.Properties.HostID)
+	if err != nil {
+		return internal.Asset{}, err
+	}
+	current, err := ctx.GetStub().GetState(asset.ID)
+	if err != nil {
+		return internal.Asset{}, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if current != nil {
+		occupant, err := internal.JsonToAsset(string(current))
+		if err != nil {
+			return internal.Asset{}, err
+		}
+		if occupant.Properties.HostID != "" && occupant.Properties.HostID != asset.Properties.HostID {
+			return internal.Asset{}, fmt.Errorf("the Asset with key: %s belongs to host %s, delete it before registering another host", asset.ID, occupant.Properties.HostID)
+		}
+		if existing != nil && existing.ID != asset.ID {
+			return internal.Asset{}, fmt.Errorf("the Asset with key: %s is another asset, delete it before moving host %s from %s", asset.ID, asset.Properties.HostID, existing.ID)
+		}
+		if existing == nil {
+			existing = &occupant
+		}
+	}
+
+	if existing == nil {
+		if err := verifySignature(ctx, asset.Properties.HostID, asset.Properties.PublicKey, payload, nonce, timestamp, signature); err != nil {
+			return internal.Asset{}, err
+		}
+		// Disabled until an operator enables it
+		asset.State = 0
+		if err := ctx.GetStub().PutState(asset.ID, []byte(asset.String())); err != nil {
+			return internal.Asset{}, err
+		}
+		return asset, indexHostID(ctx, asset.Properties.HostID, asset.ID)
+	}
+	if existing.Properties.PublicKey == "" && existing.ID != asset.ID {
+		return internal.Asset{}, fmt.Errorf("the Asset with key: %s has no public key, it can only be registered from its own key", existing.ID)
+	}
+	if existing.Properties.PublicKey != "" && asset.Properties.PublicKey != existing.Properties.PublicKey {
+		return internal.Asset{}, fmt.Errorf("the public key of the Asset with key: %s can't be changed by a registration", existing.ID)
+	}
+	// Same key as the asset, or the key it gets when it has none
+	if err := verifySignature(ctx, asset.Properties.HostID, asset.Properties.PublicKey, payload, nonce, timestamp, signature); err != nil {
+		return internal.Asset{}, err
+	}
+
+	registered := *existing
+	registered.ID = asset.ID
+	registered.Name = asset.Name
+	registered.Type = asset.Type
+	registered.Properties.GPU = asset.Properties.GPU
+	registered.Properties.Hostname = asset.Properties.Hostname
+	registered.Properties.PublicKey = asset.Properties.PublicKey
+	registered.Properties.ResponderProtocol = asset.Properties.ResponderProtocol
+	registered.Properties.ResponderPort = asset.Properties.ResponderPort
+	registered.Properties.BandwidthPort = asset.Properties.BandwidthPort
+	registered.Properties.HostID = asset.Properties.HostID
+	registered.Properties.Addresses = asset.Properties.Addresses
+	registered.Properties.CPUModel = asset.Properties.CPUModel
+	registered.Properties.CPUCores = asset.Properties.CPUCores
+	registered.Properties.Memory = asset.Properties.Memory
+	// Labels are merged, the labels set by hand on the asset are kept
+	labels := map[string]string{}
+	for key, value := range existing.Properties.Labels {
+		labels[key] = value
+	}
+	for key, value := range asset.Properties.Labels {
+		labels[key] = value
+	}
+	registered.Properties.Labels = labels
+	if registered.Owner == "" {
+		registered.Owner = asset.Owner
+	}
+
+	if existing.ID != registered.ID {
+		if err := ctx.GetStub().DelState(existing.ID); err != nil {
+			return internal.Asset{}, err
+		}
+	}
+	if err := ctx.GetStub().PutState(registered.ID, []byte(registered.String())); err != nil {
+		return internal.Asset{}, err
+	}
+	return registered, indexHostID(ctx, registered.Properties.HostID, registered.ID)
 }
 
 // DeleteAsset deletes an given asset from the world state.
 func (s *SmartContract) DeleteAsset(ctx contractapi.TransactionContextInterface, assetKey string) error {
-	exists, err := s.AssetExists(ctx, assetKey)
+	asset, err := s.ReadAsset(ctx, assetKey)
 	if err != nil {
 		return err
 	}
-	if !exists {
-		return fmt.Errorf("the Asset with key: %s does not exist", assetKey)
+
+	if err := ctx.GetStub().DelState(assetKey); err != nil {
+		return err
+	}
+	return unindexHostID(ctx, asset.Properties.HostID, assetKey)
+}
+
+// Returns the asset indexed under hostID, nil when the host id isn't indexed or its asset was deleted or has another host id now
+func (s *SmartContract) assetByHostID(ctx contractapi.TransactionContextInterface, hostID string) (*internal.Asset, error) {
+	indexKey, err := ctx.GetStub().CreateCompositeKey(hostIDObjectType, []string{hostID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create host id key: %v", err)
+	}
+	assetKey, err := ctx.GetStub().GetState(indexKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if assetKey == nil {
+		return nil, nil
+	}
+	stored, err := ctx.GetStub().GetState(string(assetKey))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if stored == nil {
+		return nil, nil
+	}
+	asset, err := internal.JsonToAsset(string(stored))
+	if err != nil {
+		return nil, err
+	}
+	if asset.Properties.HostID != hostID {
+		return nil, nil
 	}
+	return &asset, nil
+}
 
-	return ctx.GetStub().DelState(assetKey)
+// A host id belongs to a single asset, returns an error when another asset is indexed under the host id of asset
+func (s *SmartContract) checkHostID(ctx contractapi.TransactionContextInterface, asset internal.Asset) error {
+	if asset.Properties.HostID == "" {
+		return nil
+	}
+	other, err := s.assetByHostID(ctx, asset.Properties.HostID)
+	if err != nil {
+		return err
+	}
+	if other != nil && other.ID != asset.ID {
+		return fmt.Errorf("the Asset with key: %s already has the host id %s", other.ID, asset.Properties.HostID)
+	}
+	return nil
+}
+
+// Points the host id index to assetKey. The index is read and written by every transaction that registers the host id, so two concurrent
+// registrations of the same node conflict on it (MVCC) instead of both creating an asset, which a rich query over the assets can't detect.
+func indexHostID(ctx contractapi.TransactionContextInterface, hostID string, assetKey string) error {
+	if hostID == "" {
+		return nil
+	}
+	indexKey, err := ctx.GetStub().CreateCompositeKey(hostIDObjectType, []string{hostID})
+	if err != nil {
+		return fmt.Errorf("failed to create host id key: %v", err)
+	}
+	return ctx.GetStub().PutState(indexKey, []byte(assetKey))
+}
+
+// Removes the host id from the index when it points to assetKey
+func unindexHostID(ctx contractapi.TransactionContextInterface, hostID string, assetKey string) error {
+	if hostID == "" {
+		return nil
+	}
+	indexKey, err := ctx.GetStub().CreateCompositeKey(hostIDObjectType, []string{hostID})
+	if err != nil {
+		return fmt.Errorf("failed to create host id key: %v", err)
+	}
+	indexed, err := ctx.GetStub().GetState(indexKey)
+	if err != nil {
+		return fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if string(indexed) != assetKey {
+		return nil
+	}
+	return ctx.GetStub().DelState(indexKey)
 }
 
 // AssetExists returns true when asset with given ID exists in world state
@@ -152,6 +357,32 @@ func (s *SmartContract) GetServerGPUAssets(ctx contractapi.TransactionContextInt
 	return stringQuery(ctx, assetQuery)
 }
 
+// GetAssetsByLabels returns the enabled assets that have every label of labelsJson ({"arch":"arm64","cuda":"true"}), of any type.
+// The value "*" matches every asset that has the label. Dots in the keys are escaped, CouchDB reads them as nested fields.
+func (s *SmartContract) GetAssetsByLabels(ctx contractapi.TransactionContextInterface, labelsJson string) ([]internal.Asset, error) {
+	labels := map[string]string{}
+	if err := json.Unmarshal([]byte(labelsJson), &labels); err != nil {
+		return nil, err
+	}
+	if len(labels) == 0 {
+		return nil, fmt.Errorf("no labels to match, use GetAllAssets instead")
+	}
+	selector := map[string]interface{}{"state": 1}
+	for key, value := range labels {
+		field := "properties.labels." + strings.ReplaceAll(key, ".", `\.`)
+		if value == "*" {
+			selector[field] = map[string]bool{"$exists": true}
+		} else {
+			selector[field] = value
+		}
+	}
+	assetQuery, err := json.Marshal(map[string]interface{}{"selector": selector})
+	if err != nil {
+		return nil, err
+	}
+	return stringQuery(ctx, string(assetQuery))
+}
+
 func (s *SmartContract) GetServerAssetsExceptId(ctx contractapi.TransactionContextInterface, excludeId string) ([]internal.Asset, error) {
 	assetQuery := fmt.Sprintf(`{"selector":{"type":0,"state":1,"$not":{"id":"%s"}}}`, excludeId)
 	return stringQuery(ctx, assetQuery)
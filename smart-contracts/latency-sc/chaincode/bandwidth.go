@@ -0,0 +1,145 @@
+package chaincode
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/dmonteroh/distributed-resources-smartcontract/latency-sc/internal"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// -- BANDWIDTH
+// Bandwidth tests run by the DRCs, stored as BandwidthAsset (docType "bandwidth") in the same world state as the latency assets
+
+func validateBandwidthAsset(asset internal.BandwidthAsset) error {
+	if len(asset.Measurements) == 0 {
+		return fmt.Errorf("no bandwidth results were posted, ignored")
+	}
+	if asset.ID == "" {
+		return fmt.Errorf("bandwidth results was posted without ID, ignored")
+	}
+	return nil
+}
+
+// ReadBandwidthAsset returns the bandwidth asset stored in the world state with given id.
+func (s *SmartContract) ReadBandwidthAsset(ctx contractapi.TransactionContextInterface, assetKey string) (internal.BandwidthAsset, error) {
+	assetJson, err := ctx.GetStub().GetState(assetKey)
+	if err != nil {
+		return internal.BandwidthAsset{}, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if assetJson == nil || !internal.IsBandwidthAsset(assetJson) {
+		return internal.BandwidthAsset{}, fmt.Errorf("the Bandwidth Asset with key: %s does not exist", assetKey)
+	}
+	return internal.BandwidthAssetJsonToStruct(string(assetJson))
+}
+
+// CreateBandwidthAsset issues a new bandwidth asset to the world state with given details.
+func (s *SmartContract) CreateBandwidthAsset(ctx contractapi.TransactionContextInterface, assetJson string) error {
+	asset, err := internal.BandwidthAssetJsonToStruct(assetJson)
+	if err != nil {
+		return err
+	}
+	asset.DocType = internal.BandwidthDocType
+
+	// RUN VALIDATIONS
+	if err := validateBandwidthAsset(asset); err != nil {
+		return err
+	}
+	exists, err := s.AssetExists(ctx, asset.ID)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("the Asset for %s already exists", asset.ID)
+	}
+
+	return ctx.GetStub().PutState(asset.ID, []byte(asset.String()))
+}
+
+// CreateBandwidthAssets issues many bandwidth assets in a single transaction, assetsJson is an array of BandwidthAsset.
+// An asset that can't be stored doesn't fail the transaction, its error is reported in the result with the same index.
+func (s *SmartContract) CreateBandwidthAssets(ctx contractapi.TransactionContextInterface, assetsJson string) ([]internal.BatchResult, error) {
+	assets, err := internal.JsonToBandwidthAssetArray(assetsJson)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the batch: %v", err)
+	}
+
+	results := []internal.BatchResult{}
+	written := map[string]bool{}
+	for _, asset := range assets {
+		asset.DocType = internal.BandwidthDocType
+		result := internal.BatchResult{ID: asset.ID}
+		exists, err := s.AssetExists(ctx, asset.ID)
+		if validErr := validateBandwidthAsset(asset); validErr != nil {
+			result.Error = validErr.Error()
+		} else if err != nil {
+			result.Error = err.Error()
+		} else if exists || written[asset.ID] {
+			result.Error = fmt.Sprintf("the Asset for %s already exists", asset.ID)
+		} else if err := ctx.GetStub().PutState(asset.ID, []byte(asset.String())); err != nil {
+			result.Error = fmt.Sprintf("failed to put to world state: %v", err)
+		} else {
+			result.Success = true
+			written[asset.ID] = true
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// GetBandwidthListTimeTarget returns the bandwidth tests against target in the last minutes, only the results of target are kept
+func (s *SmartContract) GetBandwidthListTimeTarget(ctx contractapi.TransactionContextInterface, target string, minutes int) ([]internal.BandwidthAsset, error) {
+	timeStart := time.Now()
+	timeEnd := timeStart.Add(time.Duration(-time.Duration(minutes) * time.Minute))
+	assetQuery := fmt.Sprintf(`{"selector": {"docType": "%s","measurements": {"$elemMatch": {"hostname": "%s"}},"timestamp.timeSeconds": {"$lt": %d,"$gte": %d}}}`, internal.BandwidthDocType, target, timeStart.Unix(), timeEnd.Unix())
+	assets, err := bandwidthQuery(ctx, assetQuery)
+	if err != nil {
+		return nil, err
+	}
+	for i := range assets {
+		filtered := []internal.BandwidthResult{}
+		for _, result := range assets[i].Measurements {
+			if result.Hostname == target {
+				filtered = append(filtered, result)
+			}
+		}
+		assets[i].Measurements = filtered
+	}
+	return assets, nil
+}
+
+// GetBandwidthListTimeSource returns the bandwidth tests run by source in the last minutes
+func (s *SmartContract) GetBandwidthListTimeSource(ctx contractapi.TransactionContextInterface, source string, minutes int) ([]internal.BandwidthAsset, error) {
+	timeStart := time.Now()
+	timeEnd := timeStart.Add(time.Duration(-time.Duration(minutes) * time.Minute))
+	assetQuery := fmt.Sprintf(`{"selector": {"docType": "%s","source": "%s","timestamp.timeSeconds": {"$lt": %d,"$gte": %d}}}`, internal.BandwidthDocType, source, timeStart.Unix(), timeEnd.Unix())
+	return bandwidthQuery(ctx, assetQuery)
+}
+
+// Unlike the latency queries, no results is an empty list: most nodes don't run bandwidth tests
+func bandwidthQuery(ctx contractapi.TransactionContextInterface, queryString string) ([]internal.BandwidthAsset, error) {
+	resultsIterator, err := ctx.GetStub().GetQueryResult(queryString)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	assets := []internal.BandwidthAsset{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		asset, err := internal.BandwidthAssetJsonToStruct(string(queryResponse.Value))
+		if err != nil {
+			return nil, err
+		}
+		assets = append(assets, asset)
+	}
+
+	sort.Slice(assets, func(i, j int) bool {
+		return assets[i].Timestamp.TimeSeconds > assets[j].Timestamp.TimeSeconds
+	})
+	return assets, nil
+}
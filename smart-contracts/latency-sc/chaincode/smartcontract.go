@@ -74,6 +74,39 @@ func (s *SmartContract) CreateAsset(ctx contractapi.TransactionContextInterface,
 	return ctx.GetStub().PutState(asset.ID, validJson)
 }
 
+// CreateAssets issues many assets in a single transaction, assetsJson is an array of LatencyAsset.
+// An asset that can't be stored doesn't fail the transaction, its error is reported in the result with the same index.
+func (s *SmartContract) CreateAssets(ctx contractapi.TransactionContextInterface, assetsJson string) ([]internal.BatchResult, error) {
+	assets, err := internal.JsonToLatencyAssetArray(assetsJson)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the batch: %v", err)
+	}
+
+	results := []internal.BatchResult{}
+	// Writes of this transaction aren't visible to GetState until it's committed, duplicates inside the batch are tracked here
+	written := map[string]bool{}
+	for _, asset := range assets {
+		result := internal.BatchResult{ID: asset.ID}
+		exists, err := s.AssetExists(ctx, asset.ID)
+		if len(asset.Results) == 0 {
+			result.Error = "no latency results were posted, ignored"
+		} else if asset.ID == "" {
+			result.Error = "latency results was posted without ID, ignored"
+		} else if err != nil {
+			result.Error = err.Error()
+		} else if exists || written[asset.ID] {
+			result.Error = fmt.Sprintf("the Asset for %s already exists", asset.ID)
+		} else if err := ctx.GetStub().PutState(asset.ID, []byte(asset.String())); err != nil {
+			result.Error = fmt.Sprintf("failed to put to world state: %v", err)
+		} else {
+			result.Success = true
+			written[asset.ID] = true
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
 // UpdateAsset updates an existing asset in the world state with provided parameters.
 func (s *SmartContract) UpdateAsset(ctx contractapi.TransactionContextInterface, assetJson string) error {
 	asset, err := internal.LatencyAssetJsonToStruct(assetJson)
@@ -176,6 +209,9 @@ func iteratorSlicerTarget(ctx contractapi.TransactionContextInterface, queryStri
 			if err != nil {
 				return nil, err
 			}
+			if internal.IsBandwidthAsset(queryResponse.Value) {
+				continue
+			}
 			asset, err := internal.LatencyAssetJsonToStruct(string(queryResponse.Value))
 			if err != nil {
 				return nil, err
@@ -202,6 +238,9 @@ func iteratorSlicer(resultsIterator shim.StateQueryIteratorInterface) ([]interna
 			if err != nil {
 				return nil, err
 			}
+			if internal.IsBandwidthAsset(queryResponse.Value) {
+				continue
+			}
 			asset, err := internal.LatencyAssetJsonToStruct(string(queryResponse.Value))
 			if err != nil {
 				return nil, err
@@ -250,23 +289,39 @@ func (s *SmartContract) GetAnalysisTimeTarget(ctx contractapi.TransactionContext
 	if err != nil {
 		return targetAnalysis, err
 	}
-	latencySelection := make(map[string][]int64)
+	latencySelection := make(map[string]internal.LatencyAnalysis)
 
 	for _, latencyAsset := range latencyAssetList {
 		for _, results := range latencyAsset.Results {
-			if results.Latency > -1 {
-				latencySelection[latencyAsset.Source] = append(latencySelection[latencyAsset.Source], results.Latency)
+			latencySelection[latencyAsset.Source] = internal.AddLatencyResult(latencySelection[latencyAsset.Source], results)
+		}
+	}
+
+	bandwidthAssetList, err := s.GetBandwidthListTimeTarget(ctx, target, minutes)
+	if err != nil {
+		return targetAnalysis, err
+	}
+	for _, bandwidthAsset := range bandwidthAssetList {
+		if latAnalysis, ok := latencySelection[bandwidthAsset.Source]; ok {
+			for _, result := range bandwidthAsset.Measurements {
+				if result.Bandwidth > 0 {
+					latAnalysis.BandwidthSummary = append(latAnalysis.BandwidthSummary, result.Bandwidth)
+				}
 			}
+			latencySelection[bandwidthAsset.Source] = latAnalysis
 		}
 	}
 
-	for k, v := range latencySelection {
-		var latAnalysis internal.LatencyAnalysis
+	// Sources that never reached the target are left out, as before, their loss isn't enough to rank them
+	for k, latAnalysis := range latencySelection {
+		if len(latAnalysis.LatencySummary) == 0 {
+			continue
+		}
 		latAnalysis.Target = target
 		latAnalysis.Duration = minutes
 		latAnalysis.Hostname = k
-		latAnalysis.LatencySummary = v
-		latAnalysis.LatencyCount = len(v)
+		latAnalysis.LatencyCount = len(latAnalysis.LatencySummary)
+		latAnalysis.BandwidthCount = len(latAnalysis.BandwidthSummary)
 		latAnalysis = internal.AnalizeLatencySummary(latAnalysis)
 		targetAnalysis = append(targetAnalysis, latAnalysis)
 	}
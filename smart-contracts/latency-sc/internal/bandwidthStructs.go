@@ -0,0 +1,54 @@
+package internal
+
+import (
+	"encoding/json"
+
+	"github.com/wI2L/jettison"
+)
+
+// Bandwidth measurements are stored next to the latency assets, DocType tells them apart in the queries
+const BandwidthDocType = "bandwidth"
+
+// Bandwidth is in Mbit/s, -1 when the test failed. Direction is download (the target sends to the source DRC) or upload.
+type BandwidthResult struct {
+	Hostname  string  `json:"hostname"`
+	Bandwidth float64 `json:"bandwidth"`
+	Direction string  `json:"direction"`
+	Bytes     int64   `json:"bytes"`
+	Duration  float64 `json:"duration"` // ms
+}
+
+// The results are stored as measurements, so the latency queries over results never match a bandwidth asset
+type BandwidthAsset struct {
+	DocType      string            `json:"docType"`
+	ID           string            `json:"id"`
+	Source       string            `json:"source"`
+	Timestamp    LatencyTimestamp  `json:"timestamp"`
+	Measurements []BandwidthResult `json:"measurements"`
+}
+
+func (d BandwidthAsset) String() string {
+	s, _ := jettison.MarshalOpts(d, jettison.NilMapEmpty(), jettison.NilSliceEmpty())
+	return string(s)
+}
+
+func BandwidthAssetJsonToStruct(v string) (asset BandwidthAsset, err error) {
+	err = json.Unmarshal([]byte(v), &asset)
+	return asset, err
+}
+
+func JsonToBandwidthAssetArray(v string) (assets []BandwidthAsset, err error) {
+	err = json.Unmarshal([]byte(v), &assets)
+	return assets, err
+}
+
+// IsBandwidthAsset tells if a stored document is a bandwidth asset instead of a latency asset
+func IsBandwidthAsset(v []byte) bool {
+	var doc struct {
+		DocType string `json:"docType"`
+	}
+	if err := json.Unmarshal(v, &doc); err != nil {
+		return false
+	}
+	return doc.DocType == BandwidthDocType
+}
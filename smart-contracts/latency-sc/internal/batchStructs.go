@@ -0,0 +1,25 @@
+package internal
+
+import (
+	"encoding/json"
+
+	"github.com/wI2L/jettison"
+)
+
+// -- BATCH
+// Result of every item of a batch write, in the same order as the items were sent
+type BatchResult struct {
+	ID      string `json:"id"`
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+}
+
+func (d BatchResult) String() string {
+	s, _ := jettison.MarshalOpts(d, jettison.NilMapEmpty(), jettison.NilSliceEmpty())
+	return string(s)
+}
+
+func JsonToBatchResults(v string) (results []BatchResult, err error) {
+	err = json.Unmarshal([]byte(v), &results)
+	return results, err
+}
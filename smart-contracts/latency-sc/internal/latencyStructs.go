@@ -15,10 +15,15 @@ type LatencyTargets struct {
 }
 
 type LatencyTarget struct {
-	Hostname     string `json:"hostname"`
-	Hostport     string `json:"hostPort"`
-	HostUser     string `json:"hostUser"`
-	HostPassword string `json:"hostPassword"`
+	Hostname      string `json:"hostname"`
+	Hostport      string `json:"hostPort"`
+	HostUser      string `json:"hostUser"`
+	HostPassword  string `json:"hostPassword"`
+	HostKey       string `json:"hostKey"`       // SHA256 fingerprint or public key of the SSH server, verified by the probes
+	Method        string `json:"method"`        // tcp, udp, http or ssh, empty for the default method of the DRC
+	ProbePort     string `json:"probePort"`     // port of the tcp, udp and http probes, hostPort is used when empty
+	Responder     bool   `json:"responder"`     // the target runs the DRC responder, tcp probes measure the echo of a token instead of the connection
+	BandwidthPort string `json:"bandwidthPort"` // port of the DRC bandwidth server of the target, empty when it can't be tested
 }
 
 func LatencyJsonToStrcut(v string) (targets LatencyTargets, err error) {
@@ -55,9 +60,19 @@ func (r LatencyResults) String() string {
 	return string(s)
 }
 
+// Result of a probe round of the DRC. Latency is the average (ms) of the samples that got an answer, -1 when every sample was lost.
+// StdDev is the jitter of the round and Loss the ratio of lost samples (0-1). Results of older DRCs only have Hostname and Latency.
 type LatencyResult struct {
-	Hostname string `json:"hostname"`
-	Latency  int64  `json:"latency"`
+	Hostname string  `json:"hostname"`
+	Latency  int64   `json:"latency"`
+	Method   string  `json:"method"` // probe method that measured the latency
+	Samples  int     `json:"samples"`
+	Average  float64 `json:"average"`
+	Min      float64 `json:"min"`
+	Max      float64 `json:"max"`
+	Median   float64 `json:"median"`
+	StdDev   float64 `json:"stdDev"`
+	Loss     float64 `json:"loss"`
 }
 
 func (r LatencyResult) String() string {
@@ -86,6 +101,10 @@ func LatencyAssetJsonToStruct(v string) (asset LatencyAsset, err error) {
 	err = json.Unmarshal([]byte(v), &asset)
 	return asset, err
 }
+func JsonToLatencyAssetArray(v string) (assets []LatencyAsset, err error) {
+	err = json.Unmarshal([]byte(v), &assets)
+	return assets, err
+}
 
 func CreateLatencyAsset(id string, latencyResults LatencyResults) LatencyAsset {
 	return LatencyAsset{
@@ -114,13 +133,25 @@ func DateFormatID(d int64) string {
 
 /////////////////////
 
+// Jitter and loss come from the probe rounds of the DRC, a link with a low average latency can still be a bad choice if it's jittery or lossy.
+// LossSummary includes the rounds where every sample was lost, the rest of the summaries only the rounds that got an answer.
 type LatencyAnalysis struct {
-	Hostname       string  `json:"hostname"`
-	Target         string  `json:"target"`
-	Duration       int     `json:"duration"`
-	AverageLatency float64 `json:"averageLatency"`
-	LatencyCount   int     `json:"latencyCount"`
-	LatencySummary []int64 `json:"statSummary"`
+	Hostname       string    `json:"hostname"`
+	Target         string    `json:"target"`
+	Duration       int       `json:"duration"`
+	AverageLatency float64   `json:"averageLatency"`
+	LatencyCount   int       `json:"latencyCount"`
+	LatencySummary []int64   `json:"statSummary"`
+	MinLatency     float64   `json:"minLatency"`
+	MaxLatency     float64   `json:"maxLatency"`
+	AverageJitter  float64   `json:"averageJitter"`
+	AverageLoss    float64   `json:"averageLoss"`
+	JitterSummary  []float64 `json:"jitterSummary"`
+	LossSummary    []float64 `json:"lossSummary"`
+	// Bandwidth tests (Mbit/s) of the same source against the target, zero when the source doesn't run them
+	AverageBandwidth float64   `json:"averageBandwidth"`
+	BandwidthCount   int       `json:"bandwidthCount"`
+	BandwidthSummary []float64 `json:"bandwidthSummary"`
 }
 
 func (d LatencyAnalysis) String() string {
@@ -141,6 +172,56 @@ func AnalizeLatencySummary(latencyAnalysis LatencyAnalysis) LatencyAnalysis {
 		}
 		latencyAnalysis.AverageLatency = AverageLatency / float64(len(latencyAnalysis.LatencySummary))
 	}
+	if len(latencyAnalysis.JitterSummary) > 0 {
+		var AverageJitter float64 = 0
+		for _, jitter := range latencyAnalysis.JitterSummary {
+			AverageJitter += jitter
+		}
+		latencyAnalysis.AverageJitter = AverageJitter / float64(len(latencyAnalysis.JitterSummary))
+	}
+	if len(latencyAnalysis.LossSummary) > 0 {
+		var AverageLoss float64 = 0
+		for _, loss := range latencyAnalysis.LossSummary {
+			AverageLoss += loss
+		}
+		latencyAnalysis.AverageLoss = AverageLoss / float64(len(latencyAnalysis.LossSummary))
+	}
+	if len(latencyAnalysis.BandwidthSummary) > 0 {
+		var AverageBandwidth float64 = 0
+		for _, bandwidth := range latencyAnalysis.BandwidthSummary {
+			AverageBandwidth += bandwidth
+		}
+		latencyAnalysis.AverageBandwidth = AverageBandwidth / float64(len(latencyAnalysis.BandwidthSummary))
+	}
 
 	return latencyAnalysis
 }
+
+// AddLatencyResult adds a probe round to the summaries of the analysis. Results of older DRCs (a single sample, no statistics)
+// count as a round without jitter, lost when the latency is -1.
+func AddLatencyResult(latencyAnalysis LatencyAnalysis, result LatencyResult) LatencyAnalysis {
+	loss := result.Loss
+	if result.Samples == 0 && result.Latency < 0 {
+		loss = 1
+	}
+	latencyAnalysis.LossSummary = append(latencyAnalysis.LossSummary, loss)
+	if result.Latency < 0 {
+		return latencyAnalysis
+	}
+
+	min, max := float64(result.Latency), float64(result.Latency)
+	if result.Samples > 0 {
+		min, max = result.Min, result.Max
+	}
+	if len(latencyAnalysis.LatencySummary) == 0 || min < latencyAnalysis.MinLatency {
+		latencyAnalysis.MinLatency = min
+	}
+	if len(latencyAnalysis.LatencySummary) == 0 || max > latencyAnalysis.MaxLatency {
+		latencyAnalysis.MaxLatency = max
+	}
+	latencyAnalysis.LatencySummary = append(latencyAnalysis.LatencySummary, result.Latency)
+	if result.Samples > 1 {
+		latencyAnalysis.JitterSummary = append(latencyAnalysis.JitterSummary, result.StdDev)
+	}
+	return latencyAnalysis
+}
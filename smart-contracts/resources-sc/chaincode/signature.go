@@ -0,0 +1,251 @@
+package chaincode
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/dmonteroh/distributed-resources-smartcontract/resources-sc/internal"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Maximum difference (seconds) between the timestamp signed by the DRC and the timestamp of the transaction
+const SignatureWindow int64 = 300
+
+// Nonces are stored as composite keys, they are not returned by the range queries over the stats
+const nonceObjectType = "nonce"
+
+// Heartbeats are signed by the DRC with the ed25519 key registered in the inventory asset of the node (Properties.PublicKey).
+// The signed message is the payload exactly as it was sent, followed by the nonce and the timestamp: payload + "\n" + nonce + "\n" + timestamp.
+// A nonce can only be used once per node and the timestamp must be within SignatureWindow of the transaction, so a captured heartbeat can't be replayed.
+// Nonces signed more than SignatureWindow ago are pruned on every verification, their heartbeats would be rejected as expired anyway.
+func verifySignature(ctx contractapi.TransactionContextInterface, hostname string, payload string, publicKey string, nonce string, timestamp string, signature string) error {
+	asset, err := readInventoryAsset(ctx, hostname)
+	if err != nil {
+		return err
+	}
+	if asset.Properties.PublicKey == "" {
+		return fmt.Errorf("the inventory asset %s has no public key", hostname)
+	}
+	if publicKey != asset.Properties.PublicKey {
+		return fmt.Errorf("unknown public key for %s", hostname)
+	}
+	key, err := base64.StdEncoding.DecodeString(asset.Properties.PublicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return fmt.Errorf("the public key of %s is not a valid ed25519 key", hostname)
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("failed to decode signature: %v", err)
+	}
+
+	signedAt, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("failed to read signature timestamp: %v", err)
+	}
+	txTimestamp, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return fmt.Errorf("failed to read transaction timestamp: %v", err)
+	}
+	if diff := txTimestamp.GetSeconds() - signedAt; diff > SignatureWindow || diff < -SignatureWindow {
+		return fmt.Errorf("the signature of %s expired, signed %d seconds from the transaction", hostname, diff)
+	}
+
+	if nonce == "" {
+		return fmt.Errorf("the signature of %s has no nonce", hostname)
+	}
+	if err := pruneNonces(ctx, hostname, txTimestamp.GetSeconds()); err != nil {
+		return err
+	}
+	nonceKey, err := ctx.GetStub().CreateCompositeKey(nonceObjectType, []string{hostname, nonce})
+	if err != nil {
+		return fmt.Errorf("failed to create nonce key: %v", err)
+	}
+	used, err := ctx.GetStub().GetState(nonceKey)
+	if err != nil {
+		return fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if used != nil {
+		return fmt.Errorf("the nonce %s of %s was already used", nonce, hostname)
+	}
+
+	message := []byte(payload + "\n" + nonce + "\n" + timestamp)
+	if !ed25519.Verify(ed25519.PublicKey(key), message, sig) {
+		return fmt.Errorf("invalid signature for %s", hostname)
+	}
+
+	return ctx.GetStub().PutState(nonceKey, []byte(timestamp))
+}
+
+// Deletes the nonces of the node signed more than SignatureWindow before now
+func pruneNonces(ctx contractapi.TransactionContextInterface, hostname string, now int64) error {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(nonceObjectType, []string{hostname})
+	if err != nil {
+		return fmt.Errorf("failed to read nonces: %v", err)
+	}
+	defer resultsIterator.Close()
+	for resultsIterator.HasNext() {
+		nonce, err := resultsIterator.Next()
+		if err != nil {
+			return fmt.Errorf("failed to read nonces: %v", err)
+		}
+		signedAt, err := strconv.ParseInt(string(nonce.Value), 10, 64)
+		if err != nil || now-signedAt > SignatureWindow {
+			if err := ctx.GetStub().DelState(nonce.Key); err != nil {
+				return fmt.Errorf("failed to delete nonce: %v", err)
+			}
+		}
+	}
+	return nil
+}
+
+// Unsigned stats are only accepted for the nodes in the inventory without a public key, unknown nodes and failed lookups are rejected
+func requireUnsigned(ctx contractapi.TransactionContextInterface, hostname string) error {
+	exists, err := inventoryAssetExists(ctx, hostname)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("%s is not in the inventory, its stats are rejected", hostname)
+	}
+	asset, err := readInventoryAsset(ctx, hostname)
+	if err != nil {
+		return err
+	}
+	if asset.Properties.PublicKey != "" {
+		return fmt.Errorf("the inventory asset %s has a public key, its stats have to be signed", hostname)
+	}
+	return nil
+}
+
+// CreateSignedAsset verifies the signature of a heartbeat (DrcStats, as posted by the DRC) and stores it as statIP
+func (s *SmartContract) CreateSignedAsset(ctx contractapi.TransactionContextInterface, statIP string, hostname string, payload string, publicKey string, nonce string, timestamp string, signature string) error {
+	err := verifySignature(ctx, hostname, payload, publicKey, nonce, timestamp, signature)
+	if err != nil {
+		return err
+	}
+	toStore, err := signedStoredStat(statIP, hostname, payload)
+	if err != nil {
+		return err
+	}
+	return s.createStat(ctx, statIP, toStore)
+}
+
+// UpdateSignedAsset verifies the signature of a heartbeat (DrcStats, as posted by the DRC) and replaces the stats stored as statIP
+func (s *SmartContract) UpdateSignedAsset(ctx contractapi.TransactionContextInterface, statIP string, hostname string, payload string, publicKey string, nonce string, timestamp string, signature string) error {
+	err := verifySignature(ctx, hostname, payload, publicKey, nonce, timestamp, signature)
+	if err != nil {
+		return err
+	}
+	exists, err := s.AssetExists(ctx, statIP)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("the Stats for %s do not exist", statIP)
+	}
+	toStore, err := signedStoredStat(statIP, hostname, payload)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(statIP, []byte(toStore.String()))
+}
+
+// CreateSignedAssets verifies the signature of a batch of heartbeats (array of DrcStats, as posted by the DRC) and stores each one with the ID of the same index.
+// The signature covers the whole batch, if it's invalid nothing is stored.
+func (s *SmartContract) CreateSignedAssets(ctx contractapi.TransactionContextInterface, idsJSON string, hostname string, payload string, publicKey string, nonce string, timestamp string, signature string) ([]internal.BatchResult, error) {
+	err := verifySignature(ctx, hostname, payload, publicKey, nonce, timestamp, signature)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	if err := json.Unmarshal([]byte(idsJSON), &ids); err != nil {
+		return nil, fmt.Errorf("failed to read the batch IDs: %v", err)
+	}
+	var batch []internal.DrcStats
+	if err := json.Unmarshal([]byte(payload), &batch); err != nil {
+		return nil, fmt.Errorf("failed to read the batch: %v", err)
+	}
+	if len(ids) != len(batch) {
+		return nil, fmt.Errorf("the batch has %d stats and %d IDs", len(batch), len(ids))
+	}
+
+	stats := []internal.StoredStat{}
+	for i, drcStats := range batch {
+		toStore := internal.ConvertToStorage(drcStats)
+		toStore.ID = ids[i]
+		toStore.Hostname = hostname
+		stats = append(stats, toStore)
+	}
+	return s.createStats(ctx, stats, false), nil
+}
+
+// UpsertSignedAssets verifies the signature of a batch of heartbeats (array of DrcStats, as posted by the DRC) and stores only the newest one as statIP,
+// creating or replacing the stored stats. Used when the gateway keeps a single record per node (single_upsert).
+func (s *SmartContract) UpsertSignedAssets(ctx contractapi.TransactionContextInterface, statIP string, hostname string, payload string, publicKey string, nonce string, timestamp string, signature string) error {
+	err := verifySignature(ctx, hostname, payload, publicKey, nonce, timestamp, signature)
+	if err != nil {
+		return err
+	}
+	var batch []internal.DrcStats
+	if err := json.Unmarshal([]byte(payload), &batch); err != nil {
+		return fmt.Errorf("failed to read the batch: %v", err)
+	}
+	if len(batch) == 0 {
+		return fmt.Errorf("empty batch for %s", statIP)
+	}
+
+	newest := batch[0]
+	for _, drcStats := range batch {
+		if drcStats.Timestamp.TimeNano > newest.Timestamp.TimeNano {
+			newest = drcStats
+		}
+	}
+	toStore := internal.ConvertToStorage(newest)
+	toStore.ID = statIP
+	toStore.Hostname = hostname
+	return ctx.GetStub().PutState(statIP, []byte(toStore.String()))
+}
+
+func signedStoredStat(statIP string, hostname string, payload string) (internal.StoredStat, error) {
+	drcStats, err := internal.DrcJsonToStruct(payload)
+	if err != nil {
+		return internal.StoredStat{}, err
+	}
+	toStore := internal.ConvertToStorage(drcStats)
+	toStore.ID = statIP
+	toStore.Hostname = hostname
+	return toStore, nil
+}
+
+// INVETORY SMART CONTRACT INVOKATION
+func inventoryAssetExists(ctx contractapi.TransactionContextInterface, assetKey string) (bool, error) {
+	queryArgs := [][]byte{[]byte("AssetExists"), []byte(assetKey)}
+	response := ctx.GetStub().InvokeChaincode("inventory-sc", queryArgs, "mychannel")
+	if response.Status != shim.OK {
+		return false, fmt.Errorf("failed to query chaincode. Error %s", response.Payload)
+	}
+	return strconv.ParseBool(string(response.GetPayload()))
+}
+
+func readInventoryAsset(ctx contractapi.TransactionContextInterface, assetKey string) (internal.Asset, error) {
+	params := []string{"ReadAsset", assetKey}
+	queryArgs := make([][]byte, len(params))
+	for i, arg := range params {
+		queryArgs[i] = []byte(arg)
+	}
+
+	response := ctx.GetStub().InvokeChaincode("inventory-sc", queryArgs, "mychannel")
+	if response.Status != shim.OK {
+		return internal.Asset{}, fmt.Errorf("failed to query chaincode. Error %s", response.Payload)
+	}
+
+	asset, err := internal.JsonToAsset(string(response.GetPayload()))
+	if err != nil {
+		return internal.Asset{}, fmt.Errorf("failed to query chaincode. Error %s", err)
+	}
+	return asset, nil
+}
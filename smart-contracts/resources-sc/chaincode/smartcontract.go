@@ -30,19 +30,26 @@ func (s *SmartContract) InitLedger(ctx contractapi.TransactionContextInterface)
 }
 
 // CreateAsset issues a new asset to the world state with given details.
+// Unsigned stats are only accepted for the nodes in the inventory without a public key, signed nodes use CreateSignedAsset.
 func (s *SmartContract) CreateAsset(ctx contractapi.TransactionContextInterface, statIP string, statJSON string) error {
-	exists, err := s.AssetExists(ctx, statIP)
+	toStore, err := internal.JsonToStoredStat(statJSON)
 	if err != nil {
 		return err
 	}
-	if exists {
-		return fmt.Errorf("the Stats for %s already exists", statIP)
+	if err := requireUnsigned(ctx, toStore.Hostname); err != nil {
+		return err
 	}
+	return s.createStat(ctx, statIP, toStore)
+}
 
-	toStore, err := internal.JsonToStoredStat(statJSON)
+func (s *SmartContract) createStat(ctx contractapi.TransactionContextInterface, statIP string, toStore internal.StoredStat) error {
+	exists, err := s.AssetExists(ctx, statIP)
 	if err != nil {
 		return err
 	}
+	if exists {
+		return fmt.Errorf("the Stats for %s already exists", statIP)
+	}
 	// toStore := internal.ConvertToStorage(tmpStat)
 	// toStore.ID = statIP
 	// RUN VALIDATION
@@ -50,6 +57,50 @@ func (s *SmartContract) CreateAsset(ctx contractapi.TransactionContextInterface,
 	return ctx.GetStub().PutState(statIP, []byte(toStore.String()))
 }
 
+// CreateAssets issues many assets in a single transaction, statsJSON is an array of StoredStat and every stat is stored under its ID.
+// A stat that can't be stored doesn't fail the transaction, its error is reported in the result with the same index.
+// Like CreateAsset, the stats of nodes with a public key in the inventory, or without an inventory asset, are rejected.
+func (s *SmartContract) CreateAssets(ctx contractapi.TransactionContextInterface, statsJSON string) ([]internal.BatchResult, error) {
+	stats, err := internal.ArrayStoredStat(statsJSON)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the batch: %v", err)
+	}
+	return s.createStats(ctx, stats, true), nil
+}
+
+// Stores every stat under its ID, unsigned stats are checked with requireUnsigned (once per hostname)
+func (s *SmartContract) createStats(ctx contractapi.TransactionContextInterface, stats []internal.StoredStat, unsigned bool) []internal.BatchResult {
+	results := []internal.BatchResult{}
+	// Writes of this transaction aren't visible to GetState until it's committed, duplicates inside the batch are tracked here
+	written := map[string]bool{}
+	rejected := map[string]error{}
+	for _, stat := range stats {
+		result := internal.BatchResult{ID: stat.ID}
+		if unsigned {
+			if _, checked := rejected[stat.Hostname]; !checked {
+				rejected[stat.Hostname] = requireUnsigned(ctx, stat.Hostname)
+			}
+		}
+		exists, err := s.AssetExists(ctx, stat.ID)
+		if stat.ID == "" {
+			result.Error = "the Stats were posted without ID, ignored"
+		} else if rejected[stat.Hostname] != nil {
+			result.Error = rejected[stat.Hostname].Error()
+		} else if err != nil {
+			result.Error = err.Error()
+		} else if exists || written[stat.ID] {
+			result.Error = fmt.Sprintf("the Stats for %s already exists", stat.ID)
+		} else if err := ctx.GetStub().PutState(stat.ID, []byte(stat.String())); err != nil {
+			result.Error = fmt.Sprintf("failed to put to world state: %v", err)
+		} else {
+			result.Success = true
+			written[stat.ID] = true
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
 // ReadAsset returns the asset stored in the world state with given id.
 func (s *SmartContract) ReadAsset(ctx contractapi.TransactionContextInterface, statIP string) (*internal.StoredStat, error) {
 	statJSON, err := ctx.GetStub().GetState(statIP)
@@ -78,6 +129,18 @@ func (s *SmartContract) UpdateAsset(ctx contractapi.TransactionContextInterface,
 	if !exists {
 		return fmt.Errorf("the Stats for %s do not exist", statIP)
 	}
+	// Unsigned stats are rejected for the nodes with a public key in the inventory (they use UpdateSignedAsset) and for unknown nodes
+	stored, err := s.ReadAsset(ctx, statIP)
+	if err != nil {
+		return err
+	}
+	hostname := stored.Hostname
+	if hostname == "" {
+		hostname = statIP
+	}
+	if err := requireUnsigned(ctx, hostname); err != nil {
+		return err
+	}
 
 	tmpStat, err := internal.DrcJsonToStruct(statJSON)
 	if err != nil {
@@ -85,6 +148,8 @@ func (s *SmartContract) UpdateAsset(ctx contractapi.TransactionContextInterface,
 	}
 	toStore := internal.ConvertToStorage(tmpStat)
 	toStore.ID = statIP
+	// The hostname of the stored stat is kept, the queries by hostname find the updated stats
+	toStore.Hostname = stored.Hostname
 
 	return ctx.GetStub().PutState(statIP, []byte(toStore.String()))
 }
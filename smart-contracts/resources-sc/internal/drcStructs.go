@@ -63,12 +63,27 @@ func (d DrcProcStats) String() string {
 }
 
 // -- DOCKER
+// CPUPercent is calculated like "docker stats", 100% is one full core. MemoryUsage doesn't count the page cache.
+// Network and block IO are cumulative since the container was started, in bytes.
 type DrcDockerStats struct {
-	ContainerID string `json:"containerID"`
-	Name        string `json:"name"`
-	Image       string `json:"image"`
-	Status      string `json:"status"`
-	State       string `json:"State"`
+	ContainerID   string  `json:"containerID"`
+	Name          string  `json:"name"`
+	Image         string  `json:"image"`
+	Status        string  `json:"status"`
+	State         string  `json:"State"`
+	CPUPercent    float64 `json:"cpuPercent"`
+	MemoryUsage   uint64  `json:"memoryUsage"`
+	MemoryLimit   uint64  `json:"memoryLimit"`
+	MemoryPercent float64 `json:"memoryPercent"`
+	NetworkRx     uint64  `json:"networkRx"`
+	NetworkTx     uint64  `json:"networkTx"`
+	BlockRead     uint64  `json:"blockRead"`
+	BlockWrite    uint64  `json:"blockWrite"`
+}
+
+// The Docker socket reports the State as "running", GO-PsUtil reports it as "true"
+func (d DrcDockerStats) Running() bool {
+	return d.State == "running" || d.State == "true" || d.Status == "running"
 }
 
 func (d DrcDockerStats) String() string {
@@ -76,6 +91,71 @@ func (d DrcDockerStats) String() string {
 	return string(s)
 }
 
+// -- NETWORK
+// Counters are cumulative since boot, rates are per second since the previous sample
+type DrcNetStats struct {
+	Interface       string  `json:"interface"`
+	Speed           uint64  `json:"speed"` // Mbit/s, 0 if unknown
+	BytesSent       uint64  `json:"bytesSent"`
+	BytesRecv       uint64  `json:"bytesRecv"`
+	PacketsSent     uint64  `json:"packetsSent"`
+	PacketsRecv     uint64  `json:"packetsRecv"`
+	ErrorsIn        uint64  `json:"errorsIn"`
+	ErrorsOut       uint64  `json:"errorsOut"`
+	DropsIn         uint64  `json:"dropsIn"`
+	DropsOut        uint64  `json:"dropsOut"`
+	BytesSentRate   float64 `json:"bytesSentRate"`
+	BytesRecvRate   float64 `json:"bytesRecvRate"`
+	PacketsSentRate float64 `json:"packetsSentRate"`
+	PacketsRecvRate float64 `json:"packetsRecvRate"`
+	ErrorRate       float64 `json:"errorRate"`
+	DropRate        float64 `json:"dropRate"`
+	Utilization     float64 `json:"utilization"` // Busiest direction as a percentage of Speed
+}
+
+func (d DrcNetStats) String() string {
+	s, _ := jettison.MarshalOpts(d, jettison.NilMapEmpty(), jettison.NilSliceEmpty())
+	return string(s)
+}
+
+// -- GPU
+type DrcGPUStats struct {
+	Index       int     `json:"index"`
+	Name        string  `json:"name"`
+	Vendor      string  `json:"vendor"`
+	Utilization float64 `json:"utilization"`
+	MemoryUsed  uint64  `json:"memoryUsed"`
+	MemoryTotal uint64  `json:"memoryTotal"`
+	Temperature float64 `json:"temperature"`
+}
+
+func (d DrcGPUStats) String() string {
+	s, _ := jettison.MarshalOpts(d, jettison.NilMapEmpty(), jettison.NilSliceEmpty())
+	return string(s)
+}
+
+// -- THERMAL
+type DrcThermalSensor struct {
+	Name        string  `json:"name"`
+	Temperature float64 `json:"temperature"`
+	Critical    float64 `json:"critical"` // 0 if unknown
+}
+
+// Temperatures are in Celsius and frequencies in MHz
+type DrcThermalStats struct {
+	Sensors        []DrcThermalSensor `json:"sensors"`
+	MaxTemperature float64            `json:"maxTemperature"`
+	CoreFrequency  []float64          `json:"coreFrequency"`
+	MaxFrequency   float64            `json:"maxFrequency"`
+	Throttled      bool               `json:"throttled"`
+	ThrottledFlags []string           `json:"throttledFlags"`
+}
+
+func (d DrcThermalStats) String() string {
+	s, _ := jettison.MarshalOpts(d, jettison.NilMapEmpty(), jettison.NilSliceEmpty())
+	return string(s)
+}
+
 // -- TIMESTAMP
 type DrcTimestamp struct {
 	TimeLocal   time.Time `json:"timeLocal"`
@@ -104,27 +184,51 @@ func (d DrcHost) String() string {
 	return string(s)
 }
 
+// -- EVENTS
+// Threshold crossed between two scheduled heartbeats, the DRC sends an out-of-band heartbeat with the events that triggered it
+type DrcEvent struct {
+	Type      string  `json:"type"`      // cpu, memory, disk or container
+	Subject   string  `json:"subject"`   // disk path or container name, empty for cpu and memory
+	Value     float64 `json:"value"`     // usage in percent, 0 for containers
+	Threshold float64 `json:"threshold"` // percent, 0 for containers
+	State     string  `json:"state"`     // state the container moved to (exited, dead, removed...)
+}
+
 // -- RESPONSE OBJECT
 type DrcStats struct {
-	Timestamp  DrcTimestamp     `json:"timestamp"`
-	DrcHost    DrcHost          `json:"host"`
-	CPUStats   DrcCPUStats      `json:"cpuStats"`
-	MemStats   DrcMemStats      `json:"memStats"`
-	DiskStats  []DrcDiskStats   `json:"diskStats"`
-	ProcStats  DrcProcStats     `json:"procStats"`
-	DockerSats []DrcDockerStats `json:"dockerStats"`
+	Timestamp    DrcTimestamp     `json:"timestamp"`
+	DrcHost      DrcHost          `json:"host"`
+	CPUStats     DrcCPUStats      `json:"cpuStats"`
+	MemStats     DrcMemStats      `json:"memStats"`
+	DiskStats    []DrcDiskStats   `json:"diskStats"`
+	ProcStats    DrcProcStats     `json:"procStats"`
+	DockerSats   []DrcDockerStats `json:"dockerStats"`
+	NetStats     []DrcNetStats    `json:"netStats"`
+	GPUStats     []DrcGPUStats    `json:"gpuStats"`
+	ThermalStats DrcThermalStats  `json:"thermalStats"`
+	// Results of collectors without a dedicated section, indexed by collector name
+	Extra map[string]interface{} `json:"extra,omitempty"`
+	// Thresholds that triggered an out-of-band heartbeat, empty on scheduled heartbeats
+	Events []DrcEvent `json:"events,omitempty"`
 }
 
 type StoredStat struct {
-	ID         string           `json:"id"`
-	Hostname   string           `json:"hostname"`
-	Timestamp  DrcTimestamp     `json:"timestamp"`
-	DrcHost    DrcHost          `json:"host"`
-	CPUStats   DrcCPUStats      `json:"cpuStats"`
-	MemStats   DrcMemStats      `json:"memStats"`
-	DiskStats  []DrcDiskStats   `json:"diskStats"`
-	ProcStats  DrcProcStats     `json:"procStats"`
-	DockerSats []DrcDockerStats `json:"dockerStats"`
+	ID           string           `json:"id"`
+	Hostname     string           `json:"hostname"`
+	Timestamp    DrcTimestamp     `json:"timestamp"`
+	DrcHost      DrcHost          `json:"host"`
+	CPUStats     DrcCPUStats      `json:"cpuStats"`
+	MemStats     DrcMemStats      `json:"memStats"`
+	DiskStats    []DrcDiskStats   `json:"diskStats"`
+	ProcStats    DrcProcStats     `json:"procStats"`
+	DockerSats   []DrcDockerStats `json:"dockerStats"`
+	NetStats     []DrcNetStats    `json:"netStats"`
+	GPUStats     []DrcGPUStats    `json:"gpuStats"`
+	ThermalStats DrcThermalStats  `json:"thermalStats"`
+	// Results of collectors without a dedicated section, indexed by collector name
+	Extra map[string]interface{} `json:"extra,omitempty"`
+	// Thresholds that triggered an out-of-band heartbeat, empty on scheduled heartbeats
+	Events []DrcEvent `json:"events,omitempty"`
 }
 
 func JsonToStoredStat(v string) (storedStat StoredStat, err error) {
@@ -137,8 +241,9 @@ func ArrayStoredStat(v string) (storedStats []StoredStat, err error) {
 	return storedStats, err
 }
 
+// encoding/json instead of jettison, which can't encode the interface values of Extra
 func (d DrcStats) String() string {
-	s, _ := jettison.MarshalOpts(d, jettison.NilMapEmpty(), jettison.NilSliceEmpty())
+	s, _ := json.Marshal(d)
 	return string(s)
 }
 
@@ -149,20 +254,26 @@ func DrcJsonToStruct(v string) (drcStats DrcStats, err error) {
 
 func ConvertToStorage(drcStats DrcStats) StoredStat {
 	return StoredStat{
-		ID:         "",
-		Hostname:   "",
-		Timestamp:  drcStats.Timestamp,
-		DrcHost:    drcStats.DrcHost,
-		CPUStats:   drcStats.CPUStats,
-		MemStats:   drcStats.MemStats,
-		DiskStats:  drcStats.DiskStats,
-		ProcStats:  drcStats.ProcStats,
-		DockerSats: drcStats.DockerSats,
+		ID:           "",
+		Hostname:     "",
+		Timestamp:    drcStats.Timestamp,
+		DrcHost:      drcStats.DrcHost,
+		CPUStats:     drcStats.CPUStats,
+		MemStats:     drcStats.MemStats,
+		DiskStats:    drcStats.DiskStats,
+		ProcStats:    drcStats.ProcStats,
+		DockerSats:   drcStats.DockerSats,
+		NetStats:     drcStats.NetStats,
+		GPUStats:     drcStats.GPUStats,
+		ThermalStats: drcStats.ThermalStats,
+		Extra:        drcStats.Extra,
+		Events:       drcStats.Events,
 	}
 }
 
+// encoding/json instead of jettison, which can't encode the interface values of Extra
 func (d StoredStat) String() string {
-	s, _ := jettison.MarshalOpts(d, jettison.NilMapEmpty(), jettison.NilSliceEmpty())
+	s, _ := json.Marshal(d)
 	return string(s)
 }
 
@@ -174,6 +285,19 @@ type StatSummary struct {
 	CPUAverageUsage     float64      `json:"cpuAverageUsage"`
 	MemoryUsePercentage float64      `json:"MemoryUsePercentage"`
 	ContainersRunning   int          `json:"containersRunning"`
+	ContainersCPUUsage  float64      `json:"containersCpuUsage"`
+	ContainersMemory    uint64       `json:"containersMemory"`
+	ContainersMemoryPct float64      `json:"containersMemoryPercentage"`
+	NetworkSentRate     float64      `json:"networkSentRate"`
+	NetworkRecvRate     float64      `json:"networkRecvRate"`
+	NetworkErrorRate    float64      `json:"networkErrorRate"`
+	NetworkDropRate     float64      `json:"networkDropRate"`
+	NetworkUtilization  float64      `json:"networkUtilization"`
+	GPUAverageUsage     float64      `json:"gpuAverageUsage"`
+	GPUMemoryPercentage float64      `json:"gpuMemoryPercentage"`
+	GPUTemperature      float64      `json:"gpuTemperature"`
+	MaxTemperature      float64      `json:"maxTemperature"`
+	Throttled           bool         `json:"throttled"`
 }
 
 func SummarizeStoredStat(d StoredStat) StatSummary {
@@ -185,12 +309,51 @@ func SummarizeStoredStat(d StoredStat) StatSummary {
 
 	var runningCount = 0
 	for _, v := range d.DockerSats {
-		if v.Status == "running" || v.State == "true" {
+		if v.Running() {
 			runningCount += 1
+			summary.ContainersCPUUsage += v.CPUPercent
+			summary.ContainersMemory += v.MemoryUsage
 		}
 	}
 
 	summary.ContainersRunning = runningCount
+	// Container CPU is reported per core (like "docker stats"), it's normalised to the whole host so it can be compared with CPUAverageUsage
+	if cores := len(d.CPUStats.CoreUsage); cores > 0 {
+		summary.ContainersCPUUsage = summary.ContainersCPUUsage / float64(cores)
+	}
+	if d.MemStats.Total > 0 {
+		summary.ContainersMemoryPct = float64(summary.ContainersMemory) / float64(d.MemStats.Total) * 100
+	}
+	summary.MaxTemperature = d.ThermalStats.MaxTemperature
+	summary.Throttled = d.ThermalStats.Throttled
+
+	// Rates are added over every interface, utilization is the one of the most saturated link
+	for _, v := range d.NetStats {
+		summary.NetworkSentRate += v.BytesSentRate
+		summary.NetworkRecvRate += v.BytesRecvRate
+		summary.NetworkErrorRate += v.ErrorRate
+		summary.NetworkDropRate += v.DropRate
+		if v.Utilization > summary.NetworkUtilization {
+			summary.NetworkUtilization = v.Utilization
+		}
+	}
+
+	// GPU usage is the average over every device, the temperature is the one of the hottest device
+	if len(d.GPUStats) > 0 {
+		var memoryUsed, memoryTotal uint64
+		for _, v := range d.GPUStats {
+			summary.GPUAverageUsage += v.Utilization
+			memoryUsed += v.MemoryUsed
+			memoryTotal += v.MemoryTotal
+			if v.Temperature > summary.GPUTemperature {
+				summary.GPUTemperature = v.Temperature
+			}
+		}
+		summary.GPUAverageUsage = summary.GPUAverageUsage / float64(len(d.GPUStats))
+		if memoryTotal > 0 {
+			summary.GPUMemoryPercentage = float64(memoryUsed) / float64(memoryTotal) * 100
+		}
+	}
 
 	return summary
 }
@@ -200,12 +363,30 @@ func (d StatSummary) String() string {
 	return string(s)
 }
 
+// Temperature (Celsius) from which a node is penalised during selection even if it wasn't throttled yet.
+// Most edge boards (Jetson, Raspberry Pi) start throttling between 80 and 85 degrees.
+const HotTemperature float64 = 80
+
 type StatAnalysis struct {
 	Hostname            string        `json:"hostname"`
 	Duration            int           `json:"duration"`
 	CPUAverageUsage     float64       `json:"cpuAverageUsage"`
 	MemoryUsePercentage float64       `json:"MemoryUsePercentage"`
 	ContainersRunning   int           `json:"containersRunning"`
+	ContainersCPUUsage  float64       `json:"containersCpuUsage"`
+	ContainersMemory    uint64        `json:"containersMemory"`
+	ContainersMemoryPct float64       `json:"containersMemoryPercentage"`
+	NetworkSentRate     float64       `json:"networkSentRate"`
+	NetworkRecvRate     float64       `json:"networkRecvRate"`
+	NetworkErrorRate    float64       `json:"networkErrorRate"`
+	NetworkDropRate     float64       `json:"networkDropRate"`
+	NetworkUtilization  float64       `json:"networkUtilization"`
+	GPUAverageUsage     float64       `json:"gpuAverageUsage"`
+	GPUMemoryPercentage float64       `json:"gpuMemoryPercentage"`
+	GPUTemperature      float64       `json:"gpuTemperature"`
+	MaxTemperature      float64       `json:"maxTemperature"`
+	ThrottledRatio      float64       `json:"throttledRatio"`
+	ThermalPenalty      bool          `json:"thermalPenalty"`
 	StatSummary         []StatSummary `json:"statSummary"`
 }
 
@@ -214,14 +395,53 @@ func AnalizeStatSummary(statAnalysis StatAnalysis) StatAnalysis {
 		var CPUAverageUsage float64 = 0
 		var MemoryUsePercentage float64 = 0
 		var ContainersRunning int = 0
+		var ContainersCPUUsage, ContainersMemoryPct float64
+		var ContainersMemory uint64 = 0
+		var NetworkSentRate, NetworkRecvRate, NetworkErrorRate, NetworkDropRate, NetworkUtilization float64
+		var GPUAverageUsage, GPUMemoryPercentage, GPUTemperature float64
+		var MaxTemperature float64 = 0
+		var ThrottledCount int = 0
 		for _, summary := range statAnalysis.StatSummary {
 			CPUAverageUsage += summary.CPUAverageUsage
 			MemoryUsePercentage += summary.MemoryUsePercentage
 			ContainersRunning += summary.ContainersRunning
+			ContainersCPUUsage += summary.ContainersCPUUsage
+			ContainersMemory += summary.ContainersMemory
+			ContainersMemoryPct += summary.ContainersMemoryPct
+			NetworkSentRate += summary.NetworkSentRate
+			NetworkRecvRate += summary.NetworkRecvRate
+			NetworkErrorRate += summary.NetworkErrorRate
+			NetworkDropRate += summary.NetworkDropRate
+			NetworkUtilization += summary.NetworkUtilization
+			GPUAverageUsage += summary.GPUAverageUsage
+			GPUMemoryPercentage += summary.GPUMemoryPercentage
+			GPUTemperature += summary.GPUTemperature
+			if summary.MaxTemperature > MaxTemperature {
+				MaxTemperature = summary.MaxTemperature
+			}
+			if summary.Throttled {
+				ThrottledCount += 1
+			}
 		}
-		statAnalysis.CPUAverageUsage = CPUAverageUsage / float64(len(statAnalysis.StatSummary))
-		statAnalysis.MemoryUsePercentage = MemoryUsePercentage / float64(len(statAnalysis.StatSummary))
+		count := float64(len(statAnalysis.StatSummary))
+		statAnalysis.CPUAverageUsage = CPUAverageUsage / count
+		statAnalysis.MemoryUsePercentage = MemoryUsePercentage / count
 		statAnalysis.ContainersRunning = (ContainersRunning / len(statAnalysis.StatSummary))
+		statAnalysis.ContainersCPUUsage = ContainersCPUUsage / count
+		statAnalysis.ContainersMemory = ContainersMemory / uint64(len(statAnalysis.StatSummary))
+		statAnalysis.ContainersMemoryPct = ContainersMemoryPct / count
+		statAnalysis.NetworkSentRate = NetworkSentRate / count
+		statAnalysis.NetworkRecvRate = NetworkRecvRate / count
+		statAnalysis.NetworkErrorRate = NetworkErrorRate / count
+		statAnalysis.NetworkDropRate = NetworkDropRate / count
+		statAnalysis.NetworkUtilization = NetworkUtilization / count
+		statAnalysis.GPUAverageUsage = GPUAverageUsage / count
+		statAnalysis.GPUMemoryPercentage = GPUMemoryPercentage / count
+		statAnalysis.GPUTemperature = GPUTemperature / count
+		// Unlike the rest of the values, the temperature is the highest of the period, a node that ran hot once is likely to do it again
+		statAnalysis.MaxTemperature = MaxTemperature
+		statAnalysis.ThrottledRatio = float64(ThrottledCount) / count
+		statAnalysis.ThermalPenalty = statAnalysis.ThrottledRatio > 0 || statAnalysis.MaxTemperature >= HotTemperature
 	}
 
 	return statAnalysis
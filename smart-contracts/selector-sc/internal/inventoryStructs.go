@@ -23,20 +23,34 @@ type Asset struct {
 // Storing the information in plain text is not recommended due to security issues, even if the data can be saved as private in the Blockchain
 // instead, servers should be assigned SSH keys
 type Properties struct {
-	GPU          int    `json:"gpu"` //0 = false, 1 = true
-	Hostname     string `json:"hostname"`
-	HostPort     string `json:"hostPort"`
-	HostUser     string `json:"hostUser"`
-	HostPassword string `json:"hostPassword"`
+	GPU               int               `json:"gpu"` //0 = false, 1 = true
+	Hostname          string            `json:"hostname"`
+	HostPort          string            `json:"hostPort"`
+	HostUser          string            `json:"hostUser"`
+	HostPassword      string            `json:"hostPassword"`
+	PublicKey         string            `json:"publicKey"`         // ed25519 key used by the DRC to sign its heartbeats, base64 encoded
+	HostKey           string            `json:"hostKey"`           // SSH host key (SHA256 fingerprint or authorized_keys format) verified by the latency probes
+	ProbeMethod       string            `json:"probeMethod"`       // latency probe method (tcp, udp, http, ssh), empty for the default method of the DRC
+	ProbePort         string            `json:"probePort"`         // port of the tcp, udp and http probes
+	ResponderProtocol string            `json:"responderProtocol"` // udp or tcp, protocol of the DRC responder of the node
+	ResponderPort     string            `json:"responderPort"`     // port of the DRC responder, when set it's probed instead of hostPort and no SSH account is needed
+	BandwidthPort     string            `json:"bandwidthPort"`     // port of the DRC bandwidth server of the node, used by the bandwidth tests
+	HostID            string            `json:"hostId"`            // stable id of the machine (host.hostid of the heartbeats), matches the node on self-registration
+	Addresses         string            `json:"addresses"`         // comma separated IP addresses of the node, reported on self-registration
+	CPUModel          string            `json:"cpuModel"`          // reported on self-registration, like the rest of the hardware
+	CPUCores          int               `json:"cpuCores"`          // logical cores
+	Memory            uint64            `json:"memory"`            // total memory, bytes
+	Labels            map[string]string `json:"labels"`            // capabilities discovered by the DRC (arch, cuda, npu...) and labels set by hand, queried with GetAssetsByLabels
 }
 
+// encoding/json instead of jettison, which can't encode the labels map
 func (d Asset) String() string {
-	s, _ := jettison.MarshalOpts(d, jettison.NilMapEmpty(), jettison.NilSliceEmpty())
+	s, _ := json.Marshal(d)
 	return string(s)
 }
 
 func AssetArrayToJson(d []Asset) []byte {
-	s, _ := jettison.MarshalOpts(d, jettison.NilMapEmpty(), jettison.NilSliceEmpty())
+	s, _ := json.Marshal(d)
 	return s
 }
 